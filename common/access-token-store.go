@@ -1,10 +1,13 @@
 package common
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
+	"net/url"
 	"sync"
 	"time"
 )
@@ -13,60 +16,123 @@ type accessTokenStore struct {
 	AccessToken       string
 	Mutex             sync.RWMutex
 	ExpirationSeconds int
+	RefreshedTime     time.Time
+	LastError         string
+	LastErrorTime     time.Time
 }
 
 type response struct {
-	AccessToken string `json:"access_token"`
-	ExpiresIn   int    `json:"expires_in"`
+	AccessToken  string `json:"access_token"`
+	ExpiresIn    int    `json:"expires_in"`
+	ErrorCode    int    `json:"errcode"`
+	ErrorMessage string `json:"errmsg"`
 }
 
 var s accessTokenStore
 
+// The old access token stays valid for a while after a new one is issued, so it's refreshed before it expires
+const accessTokenRefreshAheadSeconds = 5 * 60
+
 func InitAccessTokenStore() {
-	go func() {
+	GoWorker(func(ctx context.Context) {
 		for {
-			RefreshAccessToken()
-			s.Mutex.RLock()
-			sleepDuration := Max(s.ExpirationSeconds, 60)
-			s.Mutex.RUnlock()
-			time.Sleep(time.Duration(sleepDuration) * time.Second)
+			sleepDuration := 60
+			if RefreshAccessToken() == nil {
+				s.Mutex.RLock()
+				sleepDuration = Max(s.ExpirationSeconds-accessTokenRefreshAheadSeconds, 60)
+				s.Mutex.RUnlock()
+			}
+			if !sleepContext(ctx, time.Duration(sleepDuration)*time.Second) {
+				return
+			}
 		}
-	}()
+	})
+}
+
+// RefreshAccessToken the error is kept until the next successful refresh, see GetAccessTokenStatus
+func RefreshAccessToken() error {
+	err := refreshAccessToken()
+	recordAccessTokenRefresh(err == nil)
+	s.Mutex.Lock()
+	if err != nil {
+		s.LastError = err.Error()
+		s.LastErrorTime = time.Now()
+	} else {
+		s.LastError = ""
+		s.LastErrorTime = time.Time{}
+	}
+	s.Mutex.Unlock()
+	if err != nil {
+		SysError("failed to refresh access token: " + err.Error())
+	}
+	return err
 }
 
-func RefreshAccessToken() {
+// refreshAccessToken the errors are shown by /readyz, so they must not contain the url with the app secret
+func refreshAccessToken() error {
 	// https://developers.weixin.qq.com/doc/offiaccount/Basic_Information/Get_access_token.html
 	client := http.Client{
 		Timeout: 5 * time.Second,
 	}
 	req, err := http.NewRequest("GET", fmt.Sprintf("https://api.weixin.qq.com/cgi-bin/token?grant_type=client_credential&appid=%s&secret=%s", WeChatAppID, WeChatAppSecret), nil)
 	if err != nil {
-		SysError(err.Error())
-		return
+		return errors.New("failed to create request")
 	}
 	responseData, err := client.Do(req)
 	if err != nil {
-		SysError("failed to refresh access token: " + err.Error())
-		return
+		RecordWeChatAPICall("cgi-bin/token", 0, err)
+		var urlErr *url.Error
+		if errors.As(err, &urlErr) {
+			err = urlErr.Err
+		}
+		return errors.New("failed to request access token: " + err.Error())
 	}
 	defer responseData.Body.Close()
 	var res response
 
 	body, err := io.ReadAll(responseData.Body)
-	err = json.Unmarshal(body, &res)
+	if err == nil {
+		err = json.Unmarshal(body, &res)
+	}
+	RecordWeChatAPICall("cgi-bin/token", res.ErrorCode, err)
 	if err != nil {
-		SysError("failed to decode response: " + err.Error())
-		return
+		return errors.New("failed to decode response: " + err.Error())
 	}
 	if res.AccessToken == "" {
-		SysError("failed to get access token, response: " + string(body))
-		return
+		return fmt.Errorf("failed to get access token: errcode %d, %s", res.ErrorCode, res.ErrorMessage)
 	}
 	s.Mutex.Lock()
 	s.AccessToken = res.AccessToken
 	s.ExpirationSeconds = res.ExpiresIn
+	s.RefreshedTime = time.Now()
 	s.Mutex.Unlock()
 	SysLog("access token refreshed")
+	return nil
+}
+
+type AccessTokenStatus struct {
+	Present       bool
+	ExpiresAt     time.Time
+	LastError     string
+	LastErrorTime time.Time
+}
+
+func GetAccessTokenStatus() AccessTokenStatus {
+	s.Mutex.RLock()
+	defer s.Mutex.RUnlock()
+	return AccessTokenStatus{
+		Present:       s.AccessToken != "",
+		ExpiresAt:     s.RefreshedTime.Add(time.Duration(s.ExpirationSeconds) * time.Second),
+		LastError:     s.LastError,
+		LastErrorTime: s.LastErrorTime,
+	}
+}
+
+// GetAccessTokenRefreshedTime returns the zero time if the access token was never refreshed successfully
+func GetAccessTokenRefreshedTime() time.Time {
+	s.Mutex.RLock()
+	defer s.Mutex.RUnlock()
+	return s.RefreshedTime
 }
 
 func GetAccessTokenAndExpirationSeconds() (string, int) {
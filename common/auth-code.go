@@ -0,0 +1,85 @@
+package common
+
+import (
+	"time"
+)
+
+// 登录会话成功时签发授权码，其有效期与二维码的有效期无关，授权码绑定到发起登录的客户端，只能兑换一次。
+// 过期后记录仍保留 AuthCodeRetentionDuration，以便区分重复使用与过期
+
+type AuthCodeGrant struct {
+	Code        string          `json:"code"`
+	ClientID    string          `json:"client_id"`
+	WeChatID    string          `json:"wechat_id"`
+	UserInfo    *WeChatUserInfo `json:"user_info"`
+	RedirectURI string          `json:"redirect_uri"`
+	Nonce       string          `json:"nonce"`
+	Scope       string          `json:"scope"`
+	Used        bool            `json:"used"`
+	CreatedAt   time.Time       `json:"created_at"`
+	ExpiredAt   time.Time       `json:"expired_at"`
+}
+
+type AuthCodeError struct {
+	Code    string
+	Message string
+}
+
+func (e *AuthCodeError) Error() string {
+	return e.Message
+}
+
+var (
+	ErrAuthCodeInvalid        = &AuthCodeError{Code: "invalid_code", Message: "授权码无效"}
+	ErrAuthCodeExpired        = &AuthCodeError{Code: "code_expired", Message: "授权码已过期"}
+	ErrAuthCodeUsed           = &AuthCodeError{Code: "code_used", Message: "授权码已被使用"}
+	ErrAuthCodeClientMismatch = &AuthCodeError{Code: "client_mismatch", Message: "授权码不属于当前客户端"}
+)
+
+var AuthCodeRetentionDuration = 10 * time.Minute
+
+// checkAuthCodeGrant 在标记为已使用前校验授权码，先校验客户端，泄露给其他客户端的授权码不会被其作废
+func checkAuthCodeGrant(grant *AuthCodeGrant, clientID string) error {
+	if grant == nil {
+		return ErrAuthCodeInvalid
+	}
+	if grant.ClientID != clientID {
+		return ErrAuthCodeClientMismatch
+	}
+	if grant.Used {
+		return ErrAuthCodeUsed
+	}
+	if grant.ExpiredAt.Before(time.Now()) {
+		return ErrAuthCodeExpired
+	}
+	return nil
+}
+
+func (m *LoginSessionManager) issueAuthCode(session *LoginSession) (*AuthCodeGrant, error) {
+	now := time.Now()
+	grant := &AuthCodeGrant{
+		Code:        m.generateLoginToken(),
+		ClientID:    session.ClientID,
+		WeChatID:    session.WeChatID,
+		UserInfo:    session.UserInfo,
+		RedirectURI: session.RedirectURI,
+		Nonce:       session.Nonce,
+		Scope:       session.Scope,
+		CreatedAt:   now,
+		ExpiredAt:   now.Add(time.Duration(AuthCodeValidSeconds) * time.Second),
+	}
+	if err := m.store.SaveAuthCode(grant); err != nil {
+		return nil, err
+	}
+	return grant, nil
+}
+
+// ExchangeAuthCode 由客户端兑换授权码，*AuthCodeError 以外的错误均为存储错误
+func (m *LoginSessionManager) ExchangeAuthCode(code string, clientID string) (*AuthCodeGrant, error) {
+	if code == "" {
+		return nil, ErrAuthCodeInvalid
+	}
+	return m.store.ConsumeAuthCode(code, func(grant *AuthCodeGrant) error {
+		return checkAuthCodeGrant(grant, clientID)
+	})
+}
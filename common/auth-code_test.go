@@ -0,0 +1,82 @@
+package common
+
+import (
+	"testing"
+	"time"
+)
+
+// newTestSessionManager returns a manager with a memory store, Redis is disabled for the test
+func newTestSessionManager(t *testing.T) *LoginSessionManager {
+	t.Helper()
+	setTestRedisEnabled(t, false)
+	return &LoginSessionManager{store: newMemoryLoginSessionStore()}
+}
+
+func setTestRedisEnabled(t *testing.T, enabled bool) {
+	t.Helper()
+	previous := RedisEnabled
+	t.Cleanup(func() {
+		RedisEnabled = previous
+	})
+	RedisEnabled = enabled
+}
+
+func TestExchangeAuthCode(t *testing.T) {
+	now := time.Now()
+	tests := []struct {
+		name     string
+		grant    *AuthCodeGrant // saved before the exchange, nil for none
+		code     string
+		clientID string
+		want     error
+	}{
+		{"valid", &AuthCodeGrant{Code: "c1", ClientID: "app", ExpiredAt: now.Add(time.Minute)}, "c1", "app", nil},
+		{"empty", nil, "", "app", ErrAuthCodeInvalid},
+		{"unknown", nil, "c1", "app", ErrAuthCodeInvalid},
+		{"used", &AuthCodeGrant{Code: "c1", ClientID: "app", Used: true, ExpiredAt: now.Add(time.Minute)}, "c1", "app", ErrAuthCodeUsed},
+		{"expired", &AuthCodeGrant{Code: "c1", ClientID: "app", ExpiredAt: now.Add(-time.Second)}, "c1", "app", ErrAuthCodeExpired},
+		{"past retention", &AuthCodeGrant{Code: "c1", ClientID: "app", ExpiredAt: now.Add(-AuthCodeRetentionDuration - time.Second)}, "c1", "app", ErrAuthCodeInvalid},
+		{"wrong client", &AuthCodeGrant{Code: "c1", ClientID: "app", ExpiredAt: now.Add(time.Minute)}, "c1", "other", ErrAuthCodeClientMismatch},
+		{"wrong client of used code", &AuthCodeGrant{Code: "c1", ClientID: "app", Used: true, ExpiredAt: now.Add(time.Minute)}, "c1", "other", ErrAuthCodeClientMismatch},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			m := newTestSessionManager(t)
+			if test.grant != nil {
+				if err := m.store.SaveAuthCode(test.grant); err != nil {
+					t.Fatalf("SaveAuthCode: %v", err)
+				}
+			}
+			grant, err := m.ExchangeAuthCode(test.code, test.clientID)
+			if err != test.want {
+				t.Fatalf("got %v, want %v", err, test.want)
+			}
+			if err == nil && (grant == nil || !grant.Used || grant.ClientID != test.clientID) {
+				t.Errorf("unexpected grant %+v", grant)
+			}
+		})
+	}
+}
+
+func TestExchangeAuthCodeOfSession(t *testing.T) {
+	m := newTestSessionManager(t)
+	session := m.CreateSessionWithOptions(LoginSessionOptions{ClientID: "app"})
+	session = m.UpdateSessionByScene(session.SceneID, "wx1", &WeChatUserInfo{OpenID: "wx1"})
+	if session == nil || session.AuthCode == "" {
+		t.Fatal("no auth code issued")
+	}
+	// The client is checked first, so another client can't burn the code
+	if _, err := m.ExchangeAuthCode(session.AuthCode, "other"); err != ErrAuthCodeClientMismatch {
+		t.Fatalf("exchange by another client: got %v, want ErrAuthCodeClientMismatch", err)
+	}
+	grant, err := m.ExchangeAuthCode(session.AuthCode, "app")
+	if err != nil {
+		t.Fatalf("ExchangeAuthCode: %v", err)
+	}
+	if grant.WeChatID != "wx1" {
+		t.Errorf("got WeChat id %q, want wx1", grant.WeChatID)
+	}
+	if _, err := m.ExchangeAuthCode(session.AuthCode, "app"); err != ErrAuthCodeUsed {
+		t.Errorf("reuse: got %v, want ErrAuthCodeUsed", err)
+	}
+}
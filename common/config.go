@@ -0,0 +1,313 @@
+package common
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/go-redis/redis/v8"
+	"github.com/joho/godotenv"
+	"gopkg.in/yaml.v3"
+	"io"
+	"net"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// AppConfig is the static configuration of the server, the options editable on the web page are stored in the
+// database instead. The values are read from the defaults, the config file, the environment variables (.env included)
+// and the flags, each overriding the ones before it. The environment variable of a field is the env tags of the
+// field and its parents joined by "_", e.g. LOG_LEVEL and RATE_LIMIT_CRITICAL_NUM.
+type AppConfig struct {
+	Port            int    `yaml:"port" env:"PORT"`
+	SQLDSN          string `yaml:"sql_dsn" env:"SQL_DSN" secret:"true"`
+	SQLitePath      string `yaml:"sqlite_path" env:"SQLITE_PATH"`
+	RedisConnString string `yaml:"redis_conn_string" env:"REDIS_CONN_STRING" secret:"true"`
+	SessionSecret   string `yaml:"session_secret" env:"SESSION_SECRET" secret:"true"`
+	MetricsToken    string `yaml:"metrics_token" env:"METRICS_TOKEN" secret:"true"`
+	// The master key encrypts the secret options in the database, the previous one is only used to decrypt
+	MasterKey             string           `yaml:"master_key" env:"MASTER_KEY" secret:"true"`
+	MasterKeyFile         string           `yaml:"master_key_file" env:"MASTER_KEY_FILE"`
+	PreviousMasterKey     string           `yaml:"previous_master_key" env:"PREVIOUS_MASTER_KEY" secret:"true"`
+	PreviousMasterKeyFile string           `yaml:"previous_master_key_file" env:"PREVIOUS_MASTER_KEY_FILE"`
+	UploadPath            string           `yaml:"upload_path" env:"UPLOAD_PATH"`
+	ShutdownTimeout       int              `yaml:"shutdown_timeout" env:"SHUTDOWN_TIMEOUT"`         // seconds
+	OptionSyncInterval    int              `yaml:"option_sync_interval" env:"OPTION_SYNC_INTERVAL"` // seconds, see StartOptionSync
+	TrustedProxies        []string         `yaml:"trusted_proxies" env:"TRUSTED_PROXIES"`           // only these may set X-Forwarded-For
+	Log                   LogConfig        `yaml:"log" env:"LOG"`
+	RateLimit             RateLimitsConfig `yaml:"rate_limit" env:"RATE_LIMIT"`
+}
+
+type LogConfig struct {
+	Dir        string `yaml:"dir" env:"DIR"`
+	Level      string `yaml:"level" env:"LEVEL"`
+	Format     string `yaml:"format" env:"FORMAT"`
+	MaxSize    int    `yaml:"max_size" env:"MAX_SIZE"`       // megabytes
+	MaxAge     int    `yaml:"max_age" env:"MAX_AGE"`         // days
+	MaxBackups int    `yaml:"max_backups" env:"MAX_BACKUPS"` // 0 for no limit
+}
+
+type RateLimitConfig struct {
+	Num      int   `yaml:"num" env:"NUM"`
+	Duration int64 `yaml:"duration" env:"DURATION"` // seconds
+}
+
+type RateLimitsConfig struct {
+	GlobalAPI RateLimitConfig `yaml:"global_api" env:"GLOBAL_API"`
+	GlobalWeb RateLimitConfig `yaml:"global_web" env:"GLOBAL_WEB"`
+	Upload    RateLimitConfig `yaml:"upload" env:"UPLOAD"`
+	Download  RateLimitConfig `yaml:"download" env:"DOWNLOAD"`
+	Critical  RateLimitConfig `yaml:"critical" env:"CRITICAL"`
+}
+
+// Config is the effective configuration, it's loaded by Init()
+var Config = defaultConfig()
+
+func defaultConfig() AppConfig {
+	return AppConfig{
+		Port:               3000,
+		SQLitePath:         SQLitePath,
+		SessionSecret:      SessionSecret,
+		UploadPath:         UploadPath,
+		ShutdownTimeout:    int(ShutdownTimeout.Seconds()),
+		OptionSyncInterval: 60,
+		Log: LogConfig{
+			Level:   "info",
+			Format:  "text",
+			MaxSize: 100,
+			MaxAge:  30,
+		},
+		RateLimit: RateLimitsConfig{
+			GlobalAPI: RateLimitConfig{Num: GlobalApiRateLimitNum, Duration: GlobalApiRateLimitDuration},
+			GlobalWeb: RateLimitConfig{Num: GlobalWebRateLimitNum, Duration: GlobalWebRateLimitDuration},
+			Upload:    RateLimitConfig{Num: UploadRateLimitNum, Duration: UploadRateLimitDuration},
+			Download:  RateLimitConfig{Num: DownloadRateLimitNum, Duration: DownloadRateLimitDuration},
+			Critical:  RateLimitConfig{Num: CriticalRateLimitNum, Duration: CriticalRateLimitDuration},
+		},
+	}
+}
+
+// LoadConfig is called after flag.Parse()
+func LoadConfig() (AppConfig, error) {
+	config := defaultConfig()
+	path := *configFile
+	if path == "" {
+		path = os.Getenv("CONFIG_FILE")
+	}
+	if path != "" {
+		if err := loadConfigFile(path, &config); err != nil {
+			return config, err
+		}
+	}
+	if err := godotenv.Load(); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return config, fmt.Errorf("failed to load .env: %v", err)
+	}
+	if err := applyConfigEnv(reflect.ValueOf(&config).Elem(), ""); err != nil {
+		return config, err
+	}
+	applyConfigFlags(&config)
+	if err := readConfigKeyFile(config.MasterKeyFile, &config.MasterKey); err != nil {
+		return config, err
+	}
+	if err := readConfigKeyFile(config.PreviousMasterKeyFile, &config.PreviousMasterKey); err != nil {
+		return config, err
+	}
+	return config, config.Validate()
+}
+
+func loadConfigFile(path string, config *AppConfig) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("failed to open config file: %v", err)
+	}
+	defer file.Close()
+	decoder := yaml.NewDecoder(file)
+	// Typos of keys are reported instead of being ignored silently
+	decoder.KnownFields(true)
+	if err := decoder.Decode(config); err != nil && !errors.Is(err, io.EOF) {
+		return fmt.Errorf("failed to parse config file %s: %v", path, err)
+	}
+	return nil
+}
+
+// readConfigKeyFile the key in the file takes precedence over the one given directly
+func readConfigKeyFile(path string, key *string) error {
+	if path == "" {
+		return nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("failed to read key file: %v", err)
+	}
+	*key = strings.TrimSpace(string(data))
+	return nil
+}
+
+// applyConfigEnv empty environment variables are treated as unset, lists are separated by commas
+func applyConfigEnv(v reflect.Value, prefix string) error {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		name := t.Field(i).Tag.Get("env")
+		if prefix != "" {
+			name = prefix + "_" + name
+		}
+		field := v.Field(i)
+		if field.Kind() == reflect.Struct {
+			if err := applyConfigEnv(field, name); err != nil {
+				return err
+			}
+			continue
+		}
+		value := os.Getenv(name)
+		if value == "" {
+			continue
+		}
+		switch field.Kind() {
+		case reflect.String:
+			field.SetString(value)
+		case reflect.Slice:
+			var values []string
+			for _, s := range strings.Split(value, ",") {
+				if s = strings.TrimSpace(s); s != "" {
+					values = append(values, s)
+				}
+			}
+			field.Set(reflect.ValueOf(values))
+		case reflect.Int, reflect.Int64:
+			n, err := strconv.ParseInt(value, 10, 64)
+			if err != nil {
+				return fmt.Errorf("invalid environment variable %s: %s is not an integer", name, value)
+			}
+			field.SetInt(n)
+		}
+	}
+	return nil
+}
+
+// applyConfigFlags only the flags given on the command line override the other sources
+func applyConfigFlags(config *AppConfig) {
+	flag.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "port":
+			config.Port = *port
+		case "log-dir":
+			config.Log.Dir = *logDir
+		case "log-level":
+			config.Log.Level = *logLevelName
+		case "log-format":
+			config.Log.Format = *logFormat
+		case "log-max-size":
+			config.Log.MaxSize = *logMaxSize
+		case "log-max-age":
+			config.Log.MaxAge = *logMaxAge
+		case "log-max-backups":
+			config.Log.MaxBackups = *logMaxBackups
+		}
+	})
+}
+
+// Validate reports all the problems at once
+func (c *AppConfig) Validate() error {
+	var problems []string
+	check := func(ok bool, format string, a ...interface{}) {
+		if !ok {
+			problems = append(problems, fmt.Sprintf(format, a...))
+		}
+	}
+	check(c.Port > 0 && c.Port < 65536, "port must be between 1 and 65535")
+	check(c.SQLDSN != "" || c.SQLitePath != "", "sqlite_path must not be empty if sql_dsn is not set")
+	if c.RedisConnString != "" {
+		_, err := redis.ParseURL(c.RedisConnString)
+		check(err == nil, "redis_conn_string is invalid: %v", err)
+	}
+	check(c.SessionSecret != "", "session_secret must not be empty")
+	check(c.UploadPath != "", "upload_path must not be empty")
+	if c.MasterKey != "" {
+		_, err := ParseMasterKey(c.MasterKey)
+		check(err == nil, "master_key is invalid: %v", err)
+	}
+	if c.PreviousMasterKey != "" {
+		_, err := ParseMasterKey(c.PreviousMasterKey)
+		check(err == nil, "previous_master_key is invalid: %v", err)
+		check(c.MasterKey != "", "master_key must be set if previous_master_key is set")
+	}
+	check(c.ShutdownTimeout > 0, "shutdown_timeout must be positive")
+	check(c.OptionSyncInterval > 0, "option_sync_interval must be positive")
+	for _, proxy := range c.TrustedProxies {
+		_, _, err := net.ParseCIDR(proxy)
+		check(err == nil || net.ParseIP(proxy) != nil, "trusted_proxies: %s is not an IP or CIDR", proxy)
+	}
+	_, err := ParseLogLevel(c.Log.Level)
+	check(err == nil, "log.level must be one of debug, info, warn and error")
+	check(c.Log.Format == "text" || c.Log.Format == "json", "log.format must be text or json")
+	check(c.Log.MaxSize > 0, "log.max_size must be positive")
+	check(c.Log.MaxAge >= 0, "log.max_age must not be negative")
+	check(c.Log.MaxBackups >= 0, "log.max_backups must not be negative")
+	maxDuration := int64(RateLimitKeyExpirationDuration.Seconds())
+	limits := []struct {
+		name  string
+		limit RateLimitConfig
+	}{
+		{"global_api", c.RateLimit.GlobalAPI},
+		{"global_web", c.RateLimit.GlobalWeb},
+		{"upload", c.RateLimit.Upload},
+		{"download", c.RateLimit.Download},
+		{"critical", c.RateLimit.Critical},
+	}
+	for _, l := range limits {
+		check(l.limit.Num > 0, "rate_limit.%s.num must be positive", l.name)
+		check(l.limit.Duration > 0 && l.limit.Duration <= maxDuration,
+			"rate_limit.%s.duration must be between 1 and %d", l.name, maxDuration)
+	}
+	if len(problems) > 0 {
+		return errors.New("invalid config: " + strings.Join(problems, "; "))
+	}
+	return nil
+}
+
+// apply sets the globals read by the rest of the code
+func (c *AppConfig) apply() {
+	SQLitePath = c.SQLitePath
+	SessionSecret = c.SessionSecret
+	MetricsToken = c.MetricsToken
+	UploadPath = c.UploadPath
+	ShutdownTimeout = time.Duration(c.ShutdownTimeout) * time.Second
+	GlobalApiRateLimitNum, GlobalApiRateLimitDuration = c.RateLimit.GlobalAPI.Num, c.RateLimit.GlobalAPI.Duration
+	GlobalWebRateLimitNum, GlobalWebRateLimitDuration = c.RateLimit.GlobalWeb.Num, c.RateLimit.GlobalWeb.Duration
+	UploadRateLimitNum, UploadRateLimitDuration = c.RateLimit.Upload.Num, c.RateLimit.Upload.Duration
+	DownloadRateLimitNum, DownloadRateLimitDuration = c.RateLimit.Download.Num, c.RateLimit.Download.Duration
+	CriticalRateLimitNum, CriticalRateLimitDuration = c.RateLimit.Critical.Num, c.RateLimit.Critical.Duration
+	_ = SetMasterKeys(c.MasterKey, c.PreviousMasterKey)
+	level, _ := ParseLogLevel(c.Log.Level)
+	SetLogLevel(level)
+	_ = SetLogFormat(c.Log.Format)
+}
+
+// Masked returns a copy with the secrets replaced, for printing
+func (c AppConfig) Masked() AppConfig {
+	maskConfigSecrets(reflect.ValueOf(&c).Elem())
+	return c
+}
+
+func maskConfigSecrets(v reflect.Value) {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		field := v.Field(i)
+		if field.Kind() == reflect.Struct {
+			maskConfigSecrets(field)
+		} else if t.Field(i).Tag.Get("secret") == "true" && field.String() != "" {
+			field.SetString("******")
+		}
+	}
+}
+
+func PrintConfig(c AppConfig) error {
+	data, err := yaml.Marshal(c.Masked())
+	if err != nil {
+		return err
+	}
+	_, err = os.Stdout.Write(data)
+	return err
+}
@@ -12,7 +12,7 @@ var SystemName = "微信服务器"
 var ServerAddress = "http://localhost:3000"
 var FooterHTML = ""
 
-// Any options with "Secret", "Token", "Key" in its key won't be return by GetOptions
+// The options declared secret in model.optionDefinitions are never returned by GetOptions
 
 var WeChatToken = ""
 var WeChatAppID = ""
@@ -32,6 +32,9 @@ var WeChatMenu = `{
 var SessionSecret = uuid.New().String()
 var SQLitePath = "wechat-server.db"
 
+// MetricsToken protects /metrics if it's not empty, see METRICS_TOKEN
+var MetricsToken = ""
+
 var OptionMap map[string]string
 var OptionMapRWMutex sync.RWMutex
 
@@ -41,6 +44,12 @@ var PasswordLoginEnabled = true
 var RegisterEnabled = false
 var EmailVerificationEnabled = false
 var GitHubOAuthEnabled = false
+var WeChatLoginConfirmEnabled = false
+var WeChatAuthEnabled = false
+var TwoFactorRequiredForAdmin = false
+
+// UserSessionKey is the session field holding the key of the server side session record
+const UserSessionKey = "session_key"
 
 var SMTPServer = ""
 var SMTPAccount = ""
@@ -56,6 +65,30 @@ const (
 	RoleRootUser   = 100
 )
 
+// Permissions are granted through roles, see model.Role
+const (
+	PermissionOptionsRead   = "options.read"
+	PermissionOptionsWrite  = "options.write"
+	PermissionUsersRead     = "users.read"
+	PermissionUsersManage   = "users.manage"
+	PermissionRolesManage   = "roles.manage"
+	PermissionClientsManage = "clients.manage"
+	PermissionFilesDelete   = "files.delete"
+	PermissionWeChatAPI     = "wechat.api" // the legacy user token access to /api/wechat
+	PermissionAuditRead     = "audit.read"
+	PermissionAll           = "*"
+)
+
+var Permissions = []string{PermissionOptionsRead, PermissionOptionsWrite, PermissionUsersRead, PermissionUsersManage,
+	PermissionRolesManage, PermissionClientsManage, PermissionFilesDelete, PermissionWeChatAPI, PermissionAuditRead}
+
+// Built-in roles granted by the numeric role of a user
+const (
+	BuiltinRoleUser  = "user"
+	BuiltinRoleAdmin = "admin"
+	BuiltinRoleRoot  = "root"
+)
+
 var (
 	FileUploadPermission    = RoleGuestUser
 	FileDownloadPermission  = RoleGuestUser
@@ -88,3 +121,38 @@ const (
 	UserStatusEnabled  = 1 // don't use 0, 0 is the default value!
 	UserStatusDisabled = 2 // also don't use 0
 )
+
+const (
+	ClientStatusEnabled  = 1
+	ClientStatusDisabled = 2
+)
+
+const (
+	IdentityProviderGitHub = "github"
+	IdentityProviderWeChat = "wechat"
+	IdentityProviderEmail  = "email"
+)
+
+// Scopes granted to API clients of /api/wechat
+const (
+	ClientScopeAccessTokenRead = "access_token:read"
+	ClientScopeLoginCreate     = "login:create"
+	ClientScopeUserResolve     = "user:resolve"
+)
+
+var ClientScopes = []string{ClientScopeAccessTokenRead, ClientScopeLoginCreate, ClientScopeUserResolve}
+
+// API tokens of users may be granted the scopes of API clients and the scopes below
+const (
+	TokenScopeFilesWrite = "files:write"
+)
+
+var TokenScopes = []string{ClientScopeAccessTokenRead, ClientScopeLoginCreate, ClientScopeUserResolve, TokenScopeFilesWrite}
+
+var MaxAPITokensPerUser = 20
+
+var OIDCTokenValidSeconds = 3600
+var AuthCodeValidSeconds = 120
+
+// RequestIdKey is the context key of the request id, which is also returned in the X-Request-Id header
+const RequestIdKey = "requestId"
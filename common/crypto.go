@@ -1,6 +1,10 @@
 package common
 
-import "golang.org/x/crypto/bcrypt"
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"golang.org/x/crypto/bcrypt"
+)
 
 func Password2Hash(password string) (string, error) {
 	passwordBytes := []byte(password)
@@ -12,3 +16,10 @@ func ValidatePasswordAndHash(password string, hash string) bool {
 	err := bcrypt.CompareHashAndPassword([]byte(hash), []byte(password))
 	return err == nil
 }
+
+// Secret2Hash is for random, high-entropy secrets generated by the system (client secrets, tokens),
+// which can be hashed with SHA-256 and looked up directly, unlike user chosen passwords.
+func Secret2Hash(secret string) string {
+	hash := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(hash[:])
+}
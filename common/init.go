@@ -6,18 +6,34 @@ import (
 	"log"
 	"os"
 	"path/filepath"
+	"strings"
 )
 
+// The flags override the config file and the environment variables, see AppConfig
 var (
-	Port         = flag.Int("port", 3000, "the listening port")
-	PrintVersion = flag.Bool("version", false, "print version and exit")
-	LogDir       = flag.String("log-dir", "", "specify the log directory")
+	port          = flag.Int("port", 3000, "the listening port")
+	printVersion  = flag.Bool("version", false, "print version and exit")
+	configFile    = flag.String("config", "", "the YAML config file, CONFIG_FILE if not set")
+	logDir        = flag.String("log-dir", "", "specify the log directory")
+	logLevelName  = flag.String("log-level", "info", "the minimum log level: debug, info, warn or error")
+	logFormat     = flag.String("log-format", "text", "the log format: text or json")
+	logMaxSize    = flag.Int("log-max-size", 100, "the size in megabytes at which log files are rotated")
+	logMaxAge     = flag.Int("log-max-age", 30, "the days to retain rotated log files, 0 to retain them forever")
+	logMaxBackups = flag.Int("log-max-backups", 0, "the maximum number of rotated log files to retain, 0 for no limit")
 	//Host         = flag.String("host", "localhost", "the server's ip address or domain")
 	//Path         = flag.String("path", "", "specify a local path to public")
 	//VideoPath    = flag.String("video", "", "specify a video folder to public")
 	//NoBrowser    = flag.Bool("no-browser", false, "open browser or not")
 )
 
+const (
+	CommandConfigPrint = "config print"
+	CommandRotateKey   = "options rotate-key"
+)
+
+// Command is the command given after the flags, empty to run the server
+var Command = ""
+
 // UploadPath Maybe override by ENV_VAR
 var UploadPath = "upload"
 
@@ -25,34 +41,50 @@ var UploadPath = "upload"
 //var ImageUploadPath = "upload/images"
 //var VideoServePath = "upload"
 
-func init() {
+// Init parses the flags and loads the config, it's called by main before anything else.
+// It isn't done in init(), which would also parse the flags of test binaries.
+func Init() {
+	flag.Usage = func() {
+		_, _ = fmt.Fprintf(flag.CommandLine.Output(), "Usage: %s [flags] [config print | options rotate-key]\n", os.Args[0])
+		flag.PrintDefaults()
+	}
 	flag.Parse()
 
-	if *PrintVersion {
+	if *printVersion {
 		fmt.Println(Version)
 		os.Exit(0)
 	}
 
-	if os.Getenv("SESSION_SECRET") != "" {
-		SessionSecret = os.Getenv("SESSION_SECRET")
-	}
-	if os.Getenv("SQLITE_PATH") != "" {
-		SQLitePath = os.Getenv("SQLITE_PATH")
+	var err error
+	Config, err = LoadConfig()
+	if err != nil {
+		log.Fatal(err)
 	}
-	if os.Getenv("UPLOAD_PATH") != "" {
-		UploadPath = os.Getenv("UPLOAD_PATH")
-		//ExplorerRootPath = UploadPath
-		//ImageUploadPath = path.Join(UploadPath, "images")
-		//VideoServePath = UploadPath
+	// "config print" shows the effective config with the secrets masked,
+	// "options rotate-key" is run by main once the database is ready
+	if args := flag.Args(); len(args) > 0 {
+		Command = strings.Join(args, " ")
+		switch Command {
+		case CommandConfigPrint:
+			if err := PrintConfig(Config); err != nil {
+				log.Fatal(err)
+			}
+			os.Exit(0)
+		case CommandRotateKey:
+		default:
+			flag.Usage()
+			os.Exit(2)
+		}
 	}
-	if *LogDir != "" {
-		var err error
-		*LogDir, err = filepath.Abs(*LogDir)
+	Config.apply()
+
+	if Config.Log.Dir != "" {
+		Config.Log.Dir, err = filepath.Abs(Config.Log.Dir)
 		if err != nil {
 			log.Fatal(err)
 		}
-		if _, err := os.Stat(*LogDir); os.IsNotExist(err) {
-			err = os.Mkdir(*LogDir, 0777)
+		if _, err := os.Stat(Config.Log.Dir); os.IsNotExist(err) {
+			err = os.Mkdir(Config.Log.Dir, 0777)
 			if err != nil {
 				log.Fatal(err)
 			}
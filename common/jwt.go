@@ -0,0 +1,133 @@
+package common
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"math/big"
+	"strings"
+	"sync"
+	"time"
+)
+
+// The signing key is persisted in the OIDCSigningKey option, so every instance shares it.
+
+var oidcSigningKey *rsa.PrivateKey
+var oidcSigningKeyID string
+var oidcSigningKeyMutex sync.RWMutex
+
+// LoadOIDCSigningKey loads the PEM encoded RSA private key, a new key will be generated when the given one is empty.
+// The returned PEM should be persisted by the caller.
+func LoadOIDCSigningKey(keyPEM string) (string, error) {
+	var key *rsa.PrivateKey
+	var err error
+	if keyPEM == "" {
+		key, err = rsa.GenerateKey(rand.Reader, 2048)
+		if err != nil {
+			return "", err
+		}
+		keyPEM = string(pem.EncodeToMemory(&pem.Block{
+			Type:  "RSA PRIVATE KEY",
+			Bytes: x509.MarshalPKCS1PrivateKey(key),
+		}))
+	} else {
+		block, _ := pem.Decode([]byte(keyPEM))
+		if block == nil {
+			return "", errors.New("invalid OIDC signing key")
+		}
+		key, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+		if err != nil {
+			return "", err
+		}
+	}
+	hash := sha256.Sum256(x509.MarshalPKCS1PublicKey(&key.PublicKey))
+	oidcSigningKeyMutex.Lock()
+	oidcSigningKey = key
+	oidcSigningKeyID = hex.EncodeToString(hash[:8])
+	oidcSigningKeyMutex.Unlock()
+	return keyPEM, nil
+}
+
+func GetOIDCPublicJWK() map[string]string {
+	oidcSigningKeyMutex.RLock()
+	defer oidcSigningKeyMutex.RUnlock()
+	if oidcSigningKey == nil {
+		return nil
+	}
+	return map[string]string{
+		"kty": "RSA",
+		"use": "sig",
+		"alg": "RS256",
+		"kid": oidcSigningKeyID,
+		"n":   base64.RawURLEncoding.EncodeToString(oidcSigningKey.PublicKey.N.Bytes()),
+		"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(oidcSigningKey.PublicKey.E)).Bytes()),
+	}
+}
+
+// SignJWT signs the claims with RS256
+func SignJWT(claims map[string]interface{}) (string, error) {
+	oidcSigningKeyMutex.RLock()
+	key := oidcSigningKey
+	kid := oidcSigningKeyID
+	oidcSigningKeyMutex.RUnlock()
+	if key == nil {
+		return "", errors.New("OIDC signing key not loaded")
+	}
+	header, err := json.Marshal(map[string]string{"alg": "RS256", "typ": "JWT", "kid": kid})
+	if err != nil {
+		return "", err
+	}
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+	signingInput := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	digest := sha256.Sum256([]byte(signingInput))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, digest[:])
+	if err != nil {
+		return "", err
+	}
+	return signingInput + "." + base64.RawURLEncoding.EncodeToString(signature), nil
+}
+
+// VerifyJWT checks the signature and the exp claim, then returns the claims
+func VerifyJWT(token string) (map[string]interface{}, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, errors.New("malformed token")
+	}
+	oidcSigningKeyMutex.RLock()
+	key := oidcSigningKey
+	oidcSigningKeyMutex.RUnlock()
+	if key == nil {
+		return nil, errors.New("OIDC signing key not loaded")
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, errors.New("malformed token")
+	}
+	digest := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	if err := rsa.VerifyPKCS1v15(&key.PublicKey, crypto.SHA256, digest[:], signature); err != nil {
+		return nil, errors.New("invalid token signature")
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return nil, errors.New("malformed token")
+	}
+	var claims map[string]interface{}
+	if err := json.Unmarshal(payload, &claims); err != nil {
+		return nil, errors.New("malformed token")
+	}
+	exp, ok := claims["exp"].(float64)
+	if !ok || int64(exp) < time.Now().Unix() {
+		return nil, errors.New("token expired")
+	}
+	return claims, nil
+}
@@ -0,0 +1,81 @@
+package common
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// Background workers get a context which is canceled on shutdown, and async tasks started by requests,
+// like updating the last seen time, are waited for, so they are not lost when the database is closed.
+
+var (
+	workerCtx, cancelWorkers = context.WithCancel(context.Background())
+	workerGroup              sync.WaitGroup
+	asyncGroup               sync.WaitGroup
+	stopping                 = make(chan struct{})
+	stoppingOnce             sync.Once
+)
+
+// ShutdownTimeout is how long the shutdown waits for requests, workers and async tasks to finish
+var ShutdownTimeout = 30 * time.Second
+
+// GoWorker runs a long running worker, it must return soon after ctx is done
+func GoWorker(worker func(ctx context.Context)) {
+	workerGroup.Add(1)
+	go func() {
+		defer workerGroup.Done()
+		worker(workerCtx)
+	}()
+}
+
+// GoAsync runs a short task in the background, StopWorkers waits for the pending ones
+func GoAsync(task func()) {
+	asyncGroup.Add(1)
+	go func() {
+		defer asyncGroup.Done()
+		task()
+	}()
+}
+
+// Stopping is closed once the shutdown begins, requests held open like streams should end then
+func Stopping() <-chan struct{} {
+	return stopping
+}
+
+func BeginShutdown() {
+	stoppingOnce.Do(func() {
+		close(stopping)
+	})
+}
+
+// StopWorkers cancels the workers and waits for them and the async tasks until ctx is done,
+// call it after the HTTP server is shut down so no more async tasks are started
+func StopWorkers(ctx context.Context) error {
+	BeginShutdown()
+	cancelWorkers()
+	done := make(chan struct{})
+	go func() {
+		workerGroup.Wait()
+		asyncGroup.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// sleepContext returns false if ctx is done before d has elapsed
+func sleepContext(ctx context.Context, d time.Duration) bool {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
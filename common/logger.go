@@ -1,44 +1,219 @@
 package common
 
 import (
+	"context"
+	"encoding/json"
 	"fmt"
 	"github.com/gin-gonic/gin"
+	"gopkg.in/natefinch/lumberjack.v2"
 	"io"
 	"log"
 	"os"
 	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
 	"time"
 )
 
+type LogLevel int
+
+const (
+	LogLevelDebug LogLevel = iota
+	LogLevelInfo
+	LogLevelWarn
+	LogLevelError
+	LogLevelFatal
+)
+
+var logLevelNames = []string{"debug", "info", "warn", "error", "fatal"}
+
+func (l LogLevel) String() string {
+	return logLevelNames[l]
+}
+
+// ParseLogLevel accepts the names of the levels below fatal
+func ParseLogLevel(name string) (LogLevel, error) {
+	for i, n := range logLevelNames[:LogLevelFatal] {
+		if strings.EqualFold(name, n) {
+			return LogLevel(i), nil
+		}
+	}
+	return LogLevelInfo, fmt.Errorf("unknown log level: %s", name)
+}
+
+// Fields are the structured fields of a log entry
+type Fields map[string]interface{}
+
+var (
+	logLevel      = LogLevelInfo
+	logFormatJSON = false
+)
+
+func SetLogLevel(level LogLevel) {
+	logLevel = level
+}
+
+func SetLogFormat(format string) error {
+	switch format {
+	case "text":
+		logFormatJSON = false
+	case "json":
+		logFormatJSON = true
+	default:
+		return fmt.Errorf("unknown log format: %s", format)
+	}
+	return nil
+}
+
+func newRotatingLogFile(name string) *lumberjack.Logger {
+	return &lumberjack.Logger{
+		Filename:   filepath.Join(Config.Log.Dir, name),
+		MaxSize:    Config.Log.MaxSize,
+		MaxAge:     Config.Log.MaxAge,
+		MaxBackups: Config.Log.MaxBackups,
+		LocalTime:  true,
+	}
+}
+
 func SetupGinLog() {
-	if *LogDir != "" {
-		commonLogPath := filepath.Join(*LogDir, "common.log")
-		errorLogPath := filepath.Join(*LogDir, "error.log")
-		commonFd, err := os.OpenFile(commonLogPath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
-		if err != nil {
-			log.Fatal("failed to open log file")
+	if Config.Log.Dir != "" {
+		commonLog := newRotatingLogFile("common.log")
+		errorLog := newRotatingLogFile("error.log")
+		// Fail early if the directory isn't writable, lumberjack only opens the files on the first write
+		if _, err := commonLog.Write(nil); err != nil {
+			log.Fatal("failed to open log file: " + err.Error())
+		}
+		if _, err := errorLog.Write(nil); err != nil {
+			log.Fatal("failed to open log file: " + err.Error())
+		}
+		gin.DefaultWriter = io.MultiWriter(os.Stdout, commonLog)
+		gin.DefaultErrorWriter = io.MultiWriter(os.Stderr, errorLog)
+		GoWorker(func(ctx context.Context) {
+			rotateLogsDaily(ctx, commonLog, errorLog)
+		})
+	}
+}
+
+// rotateLogsDaily starts new files at midnight, besides the rotation by size done by lumberjack
+func rotateLogsDaily(ctx context.Context, loggers ...*lumberjack.Logger) {
+	for {
+		now := time.Now()
+		midnight := time.Date(now.Year(), now.Month(), now.Day()+1, 0, 0, 0, 0, now.Location())
+		if !sleepContext(ctx, midnight.Sub(now)) {
+			return
+		}
+		for _, l := range loggers {
+			if err := l.Rotate(); err != nil {
+				SysError("failed to rotate log file: " + err.Error())
+			}
 		}
-		errorFd, err := os.OpenFile(errorLogPath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	}
+}
+
+var (
+	openIDPattern         = regexp.MustCompile(`\bo[0-9A-Za-z_-]{27}\b`)
+	secretParamPattern    = regexp.MustCompile(`(?i)\b(access_token|refresh_token|id_token|token|ticket|secret|password|code)=([^\s,&"]+)`)
+	secretJSONPattern     = regexp.MustCompile(`(?i)"(access_token|refresh_token|id_token|token|ticket|secret|password|code)"\s*:\s*"[^"]*"`)
+	bearerPattern         = regexp.MustCompile(`(?i)\bBearer\s+\S+`)
+	secretFieldKeyPattern = regexp.MustCompile(`(?i)(token|ticket|secret|password|^code$)`)
+)
+
+// redact masks WeChat openids and credentials, so log files can be shared without leaking them
+func redact(s string) string {
+	s = openIDPattern.ReplaceAllStringFunc(s, func(openID string) string {
+		return openID[:6] + "***"
+	})
+	s = secretParamPattern.ReplaceAllString(s, "$1=***")
+	s = secretJSONPattern.ReplaceAllString(s, `"$1":"***"`)
+	s = bearerPattern.ReplaceAllString(s, "Bearer ***")
+	return s
+}
+
+func formatLogEntry(t time.Time, level LogLevel, msg string, fields Fields) []byte {
+	keys := make([]string, 0, len(fields))
+	for k := range fields {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		if secretFieldKeyPattern.MatchString(k) {
+			fields[k] = "***"
+		} else if s, ok := fields[k].(string); ok {
+			fields[k] = redact(s)
+		}
+	}
+	msg = redact(msg)
+	if logFormatJSON {
+		entry := make(map[string]interface{}, len(fields)+3)
+		for k, v := range fields {
+			entry[k] = v
+		}
+		entry["time"] = t.Format(time.RFC3339Nano)
+		entry["level"] = level.String()
+		entry["msg"] = msg
+		data, err := json.Marshal(entry)
 		if err != nil {
-			log.Fatal("failed to open log file")
+			data, _ = json.Marshal(map[string]string{"time": entry["time"].(string), "level": level.String(), "msg": msg})
 		}
-		gin.DefaultWriter = io.MultiWriter(os.Stdout, commonFd)
-		gin.DefaultErrorWriter = io.MultiWriter(os.Stderr, errorFd)
+		return append(data, '\n')
 	}
+	var b strings.Builder
+	_, _ = fmt.Fprintf(&b, "[%s] %v | %s", strings.ToUpper(level.String()), t.Format("2006/01/02 - 15:04:05"), msg)
+	for _, k := range keys {
+		_, _ = fmt.Fprintf(&b, " %s=%v", k, fields[k])
+	}
+	b.WriteString("\n")
+	return []byte(b.String())
+}
+
+// LogWithFields writes an entry of the level, errors and above go to error.log, the others to common.log
+func LogWithFields(level LogLevel, msg string, fields Fields) {
+	if level < logLevel {
+		return
+	}
+	if fields == nil {
+		fields = Fields{}
+	}
+	writer := gin.DefaultWriter
+	if level >= LogLevelError {
+		writer = gin.DefaultErrorWriter
+	}
+	_, _ = writer.Write(formatLogEntry(time.Now(), level, msg, fields))
+}
+
+// requestFields carries the request id, so the entries of a request can be correlated with its access log
+func requestFields(c *gin.Context) Fields {
+	return Fields{"request_id": c.GetString(RequestIdKey)}
+}
+
+// RequestLog is SysLog with the request id of c, only use it while the request is being handled
+func RequestLog(c *gin.Context, s string) {
+	LogWithFields(LogLevelInfo, s, requestFields(c))
+}
+
+// RequestError is SysError with the request id of c, only use it while the request is being handled
+func RequestError(c *gin.Context, s string) {
+	LogWithFields(LogLevelError, s, requestFields(c))
+}
+
+func SysDebug(s string) {
+	LogWithFields(LogLevelDebug, s, nil)
 }
 
 func SysLog(s string) {
-	t := time.Now()
-	_, _ = fmt.Fprintf(gin.DefaultWriter, "[SYS] %v | %s \n", t.Format("2006/01/02 - 15:04:05"), s)
+	LogWithFields(LogLevelInfo, s, nil)
+}
+
+func SysWarn(s string) {
+	LogWithFields(LogLevelWarn, s, nil)
 }
 
 func SysError(s string) {
-	t := time.Now()
-	_, _ = fmt.Fprintf(gin.DefaultErrorWriter, "[SYS] %v | %s \n", t.Format("2006/01/02 - 15:04:05"), s)
+	LogWithFields(LogLevelError, s, nil)
 }
 
 func FatalLog(v ...any) {
-	t := time.Now()
-	_, _ = fmt.Fprintf(gin.DefaultErrorWriter, "[FATAL] %v | %v \n", t.Format("2006/01/02 - 15:04:05"), v)
+	LogWithFields(LogLevelFatal, fmt.Sprint(v...), nil)
 	os.Exit(1)
 }
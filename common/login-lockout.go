@@ -0,0 +1,123 @@
+package common
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// Password login attempts are counted per username until one succeeds, so attacks distributed over many IPs are
+// slowed down as well. After loginFreeFailures attempts the next one is delayed exponentially, and the username is
+// locked out for LoginLockoutMinutes after LoginMaxFailures failures, or until an administrator unlocks it.
+var LoginMaxFailures = 10
+var LoginLockoutMinutes = 30
+
+const (
+	loginFailurePurpose  = "l"
+	loginDelayPurpose    = "ld"
+	loginLockPurpose     = "ll"
+	loginFreeFailures    = 3
+	loginMaxDelaySeconds = 60
+)
+
+var ErrLoginLocked = errors.New("登录失败次数过多，账户已被临时锁定，请稍后再试或联系管理员解锁")
+
+type LoginDelayError struct {
+	Seconds int64
+}
+
+func (e *LoginDelayError) Error() string {
+	return fmt.Sprintf("登录失败次数过多，请 %d 秒后再试", e.Seconds)
+}
+
+// ReserveLoginAttempt counts the attempt before the password is checked, so a burst of concurrent attempts can't
+// all pass before their failures are recorded. It returns the number of the attempt since the last successful login,
+// or ErrLoginLocked or a *LoginDelayError if the username can't try to log in now.
+func ReserveLoginAttempt(username string) (int, error) {
+	if LoginMaxFailures <= 0 {
+		return 0, nil
+	}
+	if IsLoginLocked(username) {
+		return 0, ErrLoginLocked
+	}
+	if err := checkLoginDelay(username); err != nil {
+		return 0, err
+	}
+	lockout := time.Duration(LoginLockoutMinutes) * time.Minute
+	attempt, err := verificationStore.IncrFailures(loginFailurePurpose, username, lockout)
+	if err != nil {
+		SysError("failed to record login attempt: " + err.Error())
+		return 0, nil
+	}
+	if attempt > LoginMaxFailures {
+		return attempt, ErrLoginLocked
+	}
+	if attempt > loginFreeFailures {
+		// The delay starts with the attempt, only one of the concurrent attempts gets through
+		delay := loginDelay(attempt)
+		until := strconv.FormatInt(time.Now().Add(delay).Unix(), 10)
+		ok, err := verificationStore.Add(loginDelayPurpose, username, until, delay)
+		if err != nil {
+			SysError("failed to set login delay: " + err.Error())
+		} else if !ok {
+			return attempt, &LoginDelayError{Seconds: int64(delay.Seconds())}
+		}
+	}
+	return attempt, nil
+}
+
+func checkLoginDelay(username string) error {
+	until, err := verificationStore.Get(loginDelayPurpose, username)
+	if err != nil {
+		SysError("failed to get login delay: " + err.Error())
+		return nil
+	}
+	untilTime, _ := strconv.ParseInt(until, 10, 64)
+	if remaining := untilTime - time.Now().Unix(); remaining > 0 {
+		return &LoginDelayError{Seconds: remaining}
+	}
+	return nil
+}
+
+func IsLoginLocked(username string) bool {
+	locked, err := verificationStore.Get(loginLockPurpose, username)
+	if err != nil {
+		SysError("failed to get login lockout: " + err.Error())
+		return false
+	}
+	return locked != ""
+}
+
+func loginDelay(attempt int) time.Duration {
+	n := attempt - loginFreeFailures - 1
+	seconds := loginMaxDelaySeconds
+	if n < 6 {
+		seconds = Min(1<<n, loginMaxDelaySeconds)
+	}
+	return time.Duration(seconds) * time.Second
+}
+
+// RecordLoginFailure is called when the reserved attempt failed, it returns true if the username is locked out by it
+func RecordLoginFailure(username string, attempt int) bool {
+	if LoginMaxFailures <= 0 || attempt < LoginMaxFailures {
+		return false
+	}
+	lockout := time.Duration(LoginLockoutMinutes) * time.Minute
+	locked, err := verificationStore.Add(loginLockPurpose, username, strconv.FormatInt(time.Now().Unix(), 10), lockout)
+	if err != nil {
+		SysError("failed to lock out login: " + err.Error())
+		return false
+	}
+	return locked
+}
+
+// ResetLoginFailures is called on successful logins, and by administrators to unlock the username
+func ResetLoginFailures(username string) {
+	if err := verificationStore.ResetFailures(loginFailurePurpose, username); err != nil {
+		SysError("failed to reset login failures: " + err.Error())
+	}
+	for _, purpose := range []string{loginDelayPurpose, loginLockPurpose} {
+		DeleteKey(username, purpose)
+	}
+}
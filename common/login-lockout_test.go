@@ -0,0 +1,151 @@
+package common
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+// setTestVerificationStore replaces the verification store by an empty memory store for the test
+func setTestVerificationStore(t *testing.T) {
+	t.Helper()
+	previous := verificationStore
+	t.Cleanup(func() {
+		verificationStore = previous
+	})
+	verificationStore = newMemoryVerificationStore()
+}
+
+// skipLoginDelay removes the delay as if the user waited for it
+func skipLoginDelay(username string) {
+	DeleteKey(username, loginDelayPurpose)
+}
+
+// failLogin reserves an attempt and records it as failed, it returns true if the username got locked out
+func failLogin(t *testing.T, username string) bool {
+	t.Helper()
+	skipLoginDelay(username)
+	attempt, err := ReserveLoginAttempt(username)
+	if err != nil {
+		t.Fatalf("ReserveLoginAttempt: %v", err)
+	}
+	return RecordLoginFailure(username, attempt)
+}
+
+func TestLoginDelay(t *testing.T) {
+	tests := []struct {
+		attempt int
+		delay   time.Duration
+	}{
+		{loginFreeFailures + 1, time.Second},
+		{loginFreeFailures + 2, 2 * time.Second},
+		{loginFreeFailures + 3, 4 * time.Second},
+		{loginFreeFailures + 6, 32 * time.Second},
+		{loginFreeFailures + 7, loginMaxDelaySeconds * time.Second},
+		{loginFreeFailures + 100, loginMaxDelaySeconds * time.Second},
+	}
+	for _, test := range tests {
+		if got := loginDelay(test.attempt); got != test.delay {
+			t.Errorf("loginDelay(%d) = %v, want %v", test.attempt, got, test.delay)
+		}
+	}
+}
+
+func TestReserveLoginAttemptDelay(t *testing.T) {
+	setTestVerificationStore(t)
+	for i := 1; i <= loginFreeFailures+1; i++ {
+		attempt, err := ReserveLoginAttempt("alice")
+		if err != nil || attempt != i {
+			t.Fatalf("attempt %d: got %d, %v", i, attempt, err)
+		}
+	}
+	// The attempt after the free ones started the delay
+	_, err := ReserveLoginAttempt("alice")
+	if delayErr, ok := err.(*LoginDelayError); !ok || delayErr.Seconds <= 0 {
+		t.Fatalf("got %v, want a *LoginDelayError", err)
+	}
+	// Attempts rejected by the delay aren't counted
+	skipLoginDelay("alice")
+	if attempt, err := ReserveLoginAttempt("alice"); err != nil || attempt != loginFreeFailures+2 {
+		t.Errorf("after the delay: got %d, %v", attempt, err)
+	}
+	// Other usernames aren't affected
+	if attempt, err := ReserveLoginAttempt("bob"); err != nil || attempt != 1 {
+		t.Errorf("other username: got %d, %v", attempt, err)
+	}
+}
+
+func TestReserveLoginAttemptConcurrent(t *testing.T) {
+	setTestVerificationStore(t)
+	var wg sync.WaitGroup
+	var mutex sync.Mutex
+	passed := 0
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := ReserveLoginAttempt("alice"); err == nil {
+				mutex.Lock()
+				passed++
+				mutex.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+	// The free attempts and the one which started the delay
+	if passed != loginFreeFailures+1 {
+		t.Errorf("%d concurrent attempts passed, want %d", passed, loginFreeFailures+1)
+	}
+}
+
+func TestLoginLockout(t *testing.T) {
+	setTestVerificationStore(t)
+	for i := 1; i < LoginMaxFailures; i++ {
+		if failLogin(t, "alice") {
+			t.Fatalf("locked out after %d failures, want %d", i, LoginMaxFailures)
+		}
+	}
+	if !failLogin(t, "alice") {
+		t.Fatalf("not locked out after %d failures", LoginMaxFailures)
+	}
+	skipLoginDelay("alice")
+	if _, err := ReserveLoginAttempt("alice"); err != ErrLoginLocked {
+		t.Errorf("got %v, want ErrLoginLocked", err)
+	}
+	if !IsLoginLocked("alice") {
+		t.Error("IsLoginLocked = false")
+	}
+}
+
+func TestLoginLockoutWithoutRecordedFailure(t *testing.T) {
+	setTestVerificationStore(t)
+	// Attempts over the limit are rejected even if their failures weren't recorded
+	for i := 1; i <= LoginMaxFailures; i++ {
+		skipLoginDelay("alice")
+		if _, err := ReserveLoginAttempt("alice"); err != nil {
+			t.Fatalf("attempt %d: %v", i, err)
+		}
+	}
+	skipLoginDelay("alice")
+	if _, err := ReserveLoginAttempt("alice"); err != ErrLoginLocked {
+		t.Errorf("got %v, want ErrLoginLocked", err)
+	}
+}
+
+func TestResetLoginFailures(t *testing.T) {
+	setTestVerificationStore(t)
+	for i := 0; i < LoginMaxFailures; i++ {
+		failLogin(t, "alice")
+	}
+	if !IsLoginLocked("alice") {
+		t.Fatal("not locked out")
+	}
+	// An administrator unlocks the username
+	ResetLoginFailures("alice")
+	if IsLoginLocked("alice") {
+		t.Error("still locked out")
+	}
+	if attempt, err := ReserveLoginAttempt("alice"); err != nil || attempt != 1 {
+		t.Errorf("after unlock: got %d, %v, want 1, nil", attempt, err)
+	}
+}
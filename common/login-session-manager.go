@@ -1,53 +1,78 @@
 package common
 
 import (
+	"context"
 	"crypto/rand"
 	"encoding/hex"
 	"fmt"
-	"sync"
 	"time"
-
-	"github.com/gin-gonic/gin"
 )
 
 type LoginSessionStatus string
 
 const (
-	SessionStatusPending LoginSessionStatus = "pending"
-	SessionStatusSuccess LoginSessionStatus = "success"
-	SessionStatusExpired LoginSessionStatus = "expired"
+	SessionStatusPending  LoginSessionStatus = "pending"
+	SessionStatusScanned  LoginSessionStatus = "scanned" // 已扫码，等待用户在微信中确认
+	SessionStatusSuccess  LoginSessionStatus = "success"
+	SessionStatusRejected LoginSessionStatus = "rejected"
+	SessionStatusExpired  LoginSessionStatus = "expired"
 )
 
+// IsFinal 处于最终状态的会话不会再改变
+func (s LoginSessionStatus) IsFinal() bool {
+	return s == SessionStatusSuccess || s == SessionStatusRejected || s == SessionStatusExpired
+}
+
 type WeChatUserInfo struct {
-	OpenID string `json:"openid"`
+	OpenID     string `json:"openid"`
+	UnionID    string `json:"unionid,omitempty"`
+	Nickname   string `json:"nickname,omitempty"`
+	HeadImgURL string `json:"headimgurl,omitempty"`
 }
 
 type LoginSession struct {
-	LoginToken string             `json:"login_token"` // 前端查询令牌
-	SceneID    string             `json:"scene_id"`    // 微信场景值
-	Status     LoginSessionStatus `json:"status"`      // 会话状态
-	WeChatID   string             `json:"wechat_id"`   // 微信用户ID
-	AuthCode   string             `json:"auth_code"`   // 授权给clinx的代码
-	UserInfo   *WeChatUserInfo    `json:"user_info"`   // 用户详细信息
-	CreatedAt  time.Time          `json:"created_at"`  // 创建时间
-	ExpiredAt  time.Time          `json:"expired_at"`  // 过期时间
+	LoginToken    string             `json:"login_token"`    // 前端查询令牌
+	SceneID       string             `json:"scene_id"`       // 微信场景值
+	Status        LoginSessionStatus `json:"status"`         // 会话状态
+	WeChatID      string             `json:"wechat_id"`      // 微信用户ID
+	AuthCode      string             `json:"auth_code"`      // 登录成功后签发给客户端的授权码
+	OAuthScope    string             `json:"oauth_scope"`    // 网页授权作用域，为空表示扫码登录
+	RedirectURI   string             `json:"redirect_uri"`   // 登录成功后携带授权码跳转的地址
+	ClientID      string             `json:"client_id"`      // 发起登录的客户端
+	State         string             `json:"state"`          // 客户端透传的 state
+	Nonce         string             `json:"nonce"`          // OIDC nonce
+	Scope         string             `json:"scope"`          // OIDC scope
+	AppName       string             `json:"app_name"`       // 发起登录的应用名称，展示给确认登录的用户
+	ClientIP      string             `json:"client_ip"`      // 发起登录的 IP
+	Location      string             `json:"location"`       // 发起登录的地理位置
+	ConfirmTicket string             `json:"confirm_ticket"` // 确认登录链接的凭证，仅发送给扫码用户
+	QRCodeURL     string             `json:"qrcode_url"`     // 托管登录页展示的二维码
+	UserInfo      *WeChatUserInfo    `json:"user_info"`      // 用户详细信息
+	CreatedAt     time.Time          `json:"created_at"`     // 创建时间
+	ExpiredAt     time.Time          `json:"expired_at"`     // 过期时间
 }
 
+// LoginSessionManager 登录会话管理，会话的存储由 LoginSessionStore 负责，
+// 启用 Redis 时会话保存在 Redis 中，多实例之间共享且重启后不丢失
 type LoginSessionManager struct {
-	sessions map[string]*LoginSession // key: login_token
-	scenes   map[string]string        // key: scene_id, value: login_token
-	mutex    sync.RWMutex
-	cleanup  *time.Ticker
+	store LoginSessionStore
 }
 
 var sessionManager = &LoginSessionManager{
-	sessions: make(map[string]*LoginSession),
-	scenes:   make(map[string]string),
-	cleanup:  time.NewTicker(1 * time.Minute),
+	store: newMemoryLoginSessionStore(),
 }
 
-func init() {
-	go sessionManager.startCleanup()
+// InitLoginSessionManager 需在 InitRedisClient() 之后调用
+func InitLoginSessionManager() {
+	if RedisEnabled {
+		sessionManager.store = newRedisLoginSessionStore(RDB)
+		GoWorker(sessionNotifier.listenRedis)
+		SysLog("login sessions are stored in Redis")
+		return
+	}
+	if store, ok := sessionManager.store.(*memoryLoginSessionStore); ok {
+		GoWorker(store.startCleanup)
+	}
 }
 
 func (m *LoginSessionManager) generateLoginToken() string {
@@ -64,138 +89,268 @@ func (m *LoginSessionManager) generateSceneID() string {
 	return fmt.Sprintf("login_%d_%s", timestamp, randomStr)
 }
 
+// LoginSessionOptions 创建会话时的可选参数，均为空时即普通扫码登录会话
+type LoginSessionOptions struct {
+	OAuthScope  string // 微信网页授权作用域
+	RedirectURI string // 登录成功后携带授权码跳转的地址
+	ClientID    string // 发起登录的客户端
+	State       string // 客户端透传的 state
+	Nonce       string // OIDC nonce
+	Scope       string // OIDC scope
+	AppName     string // 发起登录的应用名称
+	ClientIP    string // 发起登录的 IP
+	Location    string // 发起登录的地理位置
+}
+
 func (m *LoginSessionManager) CreateSession() *LoginSession {
-	m.mutex.Lock()
-	defer m.mutex.Unlock()
+	return m.CreateSessionWithOptions(LoginSessionOptions{})
+}
 
+func (m *LoginSessionManager) CreateSessionWithOptions(opts LoginSessionOptions) *LoginSession {
 	loginToken := m.generateLoginToken()
 	sceneID := m.generateSceneID()
-	authCode := m.generateLoginToken() // 生成授权码
 
 	session := &LoginSession{
-		LoginToken: loginToken,
-		SceneID:    sceneID,
-		Status:     SessionStatusPending,
-		AuthCode:   authCode,
-		CreatedAt:  time.Now(),
-		ExpiredAt:  time.Now().Add(10 * time.Minute), // 10分钟过期
+		LoginToken:  loginToken,
+		SceneID:     sceneID,
+		Status:      SessionStatusPending,
+		OAuthScope:  opts.OAuthScope,
+		RedirectURI: opts.RedirectURI,
+		ClientID:    opts.ClientID,
+		State:       opts.State,
+		Nonce:       opts.Nonce,
+		Scope:       opts.Scope,
+		AppName:     opts.AppName,
+		ClientIP:    opts.ClientIP,
+		Location:    opts.Location,
+		CreatedAt:   time.Now(),
+		ExpiredAt:   time.Now().Add(10 * time.Minute), // 10分钟过期
 	}
 
-	m.sessions[loginToken] = session
-	m.scenes[sceneID] = loginToken
+	if err := m.store.Create(session); err != nil {
+		SysError("failed to create login session: " + err.Error())
+		return nil
+	}
 
 	SysLog(fmt.Sprintf("Created login session: token=%s, scene=%s", loginToken, sceneID))
 	return session
 }
 
 func (m *LoginSessionManager) GetSession(loginToken string) *LoginSession {
-	m.mutex.RLock()
-	defer m.mutex.RUnlock()
-
-	session, exists := m.sessions[loginToken]
-	if !exists {
+	if loginToken == "" {
 		return nil
 	}
-
-	if session.ExpiredAt.Before(time.Now()) {
-		delete(m.sessions, loginToken)
-		delete(m.scenes, session.SceneID)
+	session, err := m.store.Get(loginToken)
+	if err != nil {
+		SysError("failed to get login session: " + err.Error())
 		return nil
 	}
-
 	return session
 }
 
 func (m *LoginSessionManager) GetSessionByScene(sceneID string) *LoginSession {
-	m.mutex.RLock()
-	defer m.mutex.RUnlock()
-
-	loginToken, exists := m.scenes[sceneID]
-	if !exists {
+	if sceneID == "" {
 		return nil
 	}
-
-	session, exists := m.sessions[loginToken]
-	if !exists || session.ExpiredAt.Before(time.Now()) {
-		delete(m.scenes, sceneID)
-		if exists {
-			delete(m.sessions, loginToken)
-		}
+	session, err := m.store.GetByScene(sceneID)
+	if err != nil {
+		SysError("failed to get login session by scene: " + err.Error())
 		return nil
 	}
-
 	return session
 }
 
-func (m *LoginSessionManager) UpdateSessionByScene(sceneID, wechatID string, userInfo *WeChatUserInfo) bool {
-	m.mutex.Lock()
-	defer m.mutex.Unlock()
-
-	loginToken, exists := m.scenes[sceneID]
-	if !exists {
+// SetSessionQRCode 保存托管登录页展示的二维码
+func (m *LoginSessionManager) SetSessionQRCode(loginToken string, qrCodeURL string) bool {
+	_, err := m.store.Update(loginToken, func(session *LoginSession) bool {
+		session.QRCodeURL = qrCodeURL
+		return true
+	})
+	if err != nil {
+		SysError("failed to update login session: " + err.Error())
 		return false
 	}
+	return true
+}
 
-	session, sessionExists := m.sessions[loginToken]
-	if !sessionExists {
-		return false
+// UpdateSessionByScene 将会话标记为登录成功并签发授权码，返回更新后的会话，nil 表示失败
+func (m *LoginSessionManager) UpdateSessionByScene(sceneID, wechatID string, userInfo *WeChatUserInfo) *LoginSession {
+	session := m.GetSessionByScene(sceneID)
+	if session == nil {
+		return nil
 	}
+	return m.completeSession(session, wechatID, userInfo, func(session *LoginSession) bool {
+		return !session.Status.IsFinal()
+	})
+}
 
+// completeSession 签发授权码，allowed 返回 true 时将会话置为登录成功
+func (m *LoginSessionManager) completeSession(session *LoginSession, wechatID string, userInfo *WeChatUserInfo, allowed func(session *LoginSession) bool) *LoginSession {
 	session.WeChatID = wechatID
 	session.UserInfo = userInfo
-	session.Status = SessionStatusSuccess
+	grant, err := m.issueAuthCode(session)
+	if err != nil {
+		SysError("failed to issue auth code: " + err.Error())
+		return nil
+	}
+	session, err = m.store.Update(session.LoginToken, func(session *LoginSession) bool {
+		if !allowed(session) {
+			return false
+		}
+		session.WeChatID = wechatID
+		session.UserInfo = userInfo
+		session.AuthCode = grant.Code
+		session.Status = SessionStatusSuccess
+		return true
+	})
+	if err != nil {
+		SysError("failed to update login session: " + err.Error())
+		return nil
+	}
+	if session.Status != SessionStatusSuccess || session.AuthCode != grant.Code {
+		return nil
+	}
+	sessionNotifier.Notify(session.LoginToken)
 
 	SysLog(fmt.Sprintf("Updated login session: wechat_id=%s, status=success", wechatID))
-	return true
+	return session
 }
 
-func (m *LoginSessionManager) startCleanup() {
-	for range m.cleanup.C {
-		m.mutex.Lock()
-		now := time.Now()
-
-		for loginToken, session := range m.sessions {
-			if session.ExpiredAt.Before(now) {
-				session.Status = SessionStatusExpired
-				delete(m.sessions, loginToken)
-				delete(m.scenes, session.SceneID)
-			}
+// MarkSessionScanned 记录扫码用户并等待其通过 ConfirmSession 或 RejectSession 确认，nil 表示失败
+func (m *LoginSessionManager) MarkSessionScanned(sceneID, wechatID string, userInfo *WeChatUserInfo) *LoginSession {
+	session := m.GetSessionByScene(sceneID)
+	if session == nil {
+		return nil
+	}
+	ticket := m.generateLoginToken()
+	session, err := m.store.Update(session.LoginToken, func(session *LoginSession) bool {
+		if session.Status != SessionStatusPending {
+			return false
 		}
+		session.WeChatID = wechatID
+		session.UserInfo = userInfo
+		session.ConfirmTicket = ticket
+		session.Status = SessionStatusScanned
+		return true
+	})
+	if err != nil {
+		SysError("failed to update login session: " + err.Error())
+		return nil
+	}
+	if session.Status != SessionStatusScanned || session.ConfirmTicket != ticket {
+		return nil
+	}
+	if err := m.store.SetPendingConfirmation(wechatID, session.LoginToken, session.ExpiredAt); err != nil {
+		SysError("failed to save pending confirmation: " + err.Error())
+	}
+	sessionNotifier.Notify(session.LoginToken)
+
+	SysLog(fmt.Sprintf("Updated login session: wechat_id=%s, status=scanned", wechatID))
+	return session
+}
 
-		SysLog(fmt.Sprintf("Cleanup completed, active sessions: %d", len(m.sessions)))
-		m.mutex.Unlock()
+// GetPendingConfirmation 返回微信用户最近扫码且尚未确认的会话
+func (m *LoginSessionManager) GetPendingConfirmation(wechatID string) *LoginSession {
+	loginToken, err := m.store.GetPendingConfirmation(wechatID)
+	if err != nil {
+		SysError("failed to get pending confirmation: " + err.Error())
+		return nil
+	}
+	session := m.GetSession(loginToken)
+	if session == nil || session.Status != SessionStatusScanned || session.WeChatID != wechatID {
+		return nil
 	}
+	return session
 }
 
-func (m *LoginSessionManager) GetActiveSessionCount() int {
-	m.mutex.RLock()
-	defer m.mutex.RUnlock()
-	return len(m.sessions)
+func (m *LoginSessionManager) clearPendingConfirmation(wechatID string, loginToken string) {
+	if err := m.store.DeletePendingConfirmation(wechatID, loginToken); err != nil {
+		SysError("failed to delete pending confirmation: " + err.Error())
+	}
 }
 
-func FindWeChatIDByAuthCode(c *gin.Context) (string, error) {
-	code := c.Query("code")
-	if code == "" {
-		return "", fmt.Errorf("无效的参数")
+// ConfirmSession 确认已扫码的会话，仅扫码用户本人可以确认
+func (m *LoginSessionManager) ConfirmSession(loginToken string, wechatID string) *LoginSession {
+	session := m.GetSession(loginToken)
+	if session == nil || session.Status != SessionStatusScanned || session.WeChatID != wechatID {
+		return nil
 	}
-	weChatAppID := sessionManager.findWeChatIDByAuthCode(code)
-	if weChatAppID == "" {
-		return "", fmt.Errorf("授权码无效或已过期")
+	m.clearPendingConfirmation(wechatID, loginToken)
+	return m.completeSession(session, wechatID, session.UserInfo, func(session *LoginSession) bool {
+		return session.Status == SessionStatusScanned && session.WeChatID == wechatID
+	})
+}
+
+// RejectSession 拒绝已扫码的会话，仅扫码用户本人可以拒绝
+func (m *LoginSessionManager) RejectSession(loginToken string, wechatID string) *LoginSession {
+	session, err := m.store.Update(loginToken, func(session *LoginSession) bool {
+		if session.Status != SessionStatusScanned || session.WeChatID != wechatID {
+			return false
+		}
+		session.Status = SessionStatusRejected
+		return true
+	})
+	if err != nil {
+		if err != ErrLoginSessionNotFound {
+			SysError("failed to update login session: " + err.Error())
+		}
+		return nil
 	}
-	return weChatAppID, nil
+	if session.Status != SessionStatusRejected || session.WeChatID != wechatID {
+		return nil
+	}
+	m.clearPendingConfirmation(wechatID, loginToken)
+	sessionNotifier.Notify(loginToken)
+
+	SysLog(fmt.Sprintf("Updated login session: wechat_id=%s, status=rejected", wechatID))
+	return session
 }
 
-func (m *LoginSessionManager) findWeChatIDByAuthCode(authCode string) string {
-	m.mutex.RLock()
-	defer m.mutex.RUnlock()
+// Watch 订阅会话的变化，见 loginSessionNotifier.Subscribe
+func (m *LoginSessionManager) Watch(loginToken string) (<-chan struct{}, func()) {
+	return sessionNotifier.Subscribe(loginToken)
+}
 
-	for _, session := range m.sessions {
-		if session.AuthCode == authCode && session.Status == SessionStatusSuccess {
-			return session.WeChatID
+// WaitForChange 阻塞直到会话离开 lastStatus、过期或超时，返回最新的会话，nil 表示会话已过期或不存在
+func (m *LoginSessionManager) WaitForChange(ctx context.Context, loginToken string, lastStatus LoginSessionStatus, timeout time.Duration) *LoginSession {
+	changed, cancel := m.Watch(loginToken)
+	defer cancel()
+	deadline := time.NewTimer(timeout)
+	defer deadline.Stop()
+	for {
+		session := m.GetSession(loginToken)
+		if session == nil || session.Status != lastStatus {
+			return session
+		}
+		expiration := time.NewTimer(time.Until(session.ExpiredAt))
+		select {
+		case <-changed:
+			expiration.Stop()
+		case <-expiration.C:
+		case <-deadline.C:
+			expiration.Stop()
+			return session
+		case <-ctx.Done():
+			expiration.Stop()
+			return session
 		}
 	}
+}
+
+func (m *LoginSessionManager) DeleteSession(loginToken string) {
+	if err := m.store.Delete(loginToken); err != nil {
+		SysError("failed to delete login session: " + err.Error())
+		return
+	}
+	sessionNotifier.Notify(loginToken)
+}
 
-	return ""
+func (m *LoginSessionManager) GetActiveSessionCount() int {
+	count, err := m.store.Count()
+	if err != nil {
+		SysError("failed to count login sessions: " + err.Error())
+	}
+	return count
 }
 
 // 导出全局访问器
@@ -0,0 +1,79 @@
+package common
+
+import (
+	"context"
+	"sync"
+)
+
+// 登录会话的变化会通知本实例的监听者，启用 Redis 时先经过 Redis 发布订阅，
+// 因此无论哪个实例处理了微信回调，监听者都会被唤醒
+
+const loginSessionEventChannel = "loginSession:events"
+
+type loginSessionNotifier struct {
+	watchers map[string]map[chan struct{}]struct{} // 键为 login_token
+	mutex    sync.Mutex
+}
+
+var sessionNotifier = &loginSessionNotifier{
+	watchers: make(map[string]map[chan struct{}]struct{}),
+}
+
+// Subscribe 会话可能变化时返回的通道会收到信号，调用方应重新读取会话，用完后务必调用返回的取消函数
+func (n *loginSessionNotifier) Subscribe(loginToken string) (<-chan struct{}, func()) {
+	ch := make(chan struct{}, 1)
+	n.mutex.Lock()
+	if n.watchers[loginToken] == nil {
+		n.watchers[loginToken] = make(map[chan struct{}]struct{})
+	}
+	n.watchers[loginToken][ch] = struct{}{}
+	n.mutex.Unlock()
+	return ch, func() {
+		n.mutex.Lock()
+		defer n.mutex.Unlock()
+		delete(n.watchers[loginToken], ch)
+		if len(n.watchers[loginToken]) == 0 {
+			delete(n.watchers, loginToken)
+		}
+	}
+}
+
+func (n *loginSessionNotifier) notifyLocal(loginToken string) {
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+	for ch := range n.watchers[loginToken] {
+		select {
+		case ch <- struct{}{}:
+		default:
+			// 已有未读取的信号
+		}
+	}
+}
+
+func (n *loginSessionNotifier) Notify(loginToken string) {
+	if RedisEnabled {
+		err := RDB.Publish(context.Background(), loginSessionEventChannel, loginToken).Err()
+		if err == nil {
+			return
+		}
+		SysError("failed to publish login session event: " + err.Error())
+	}
+	n.notifyLocal(loginToken)
+}
+
+func (n *loginSessionNotifier) listenRedis(ctx context.Context) {
+	pubsub := RDB.Subscribe(ctx, loginSessionEventChannel)
+	defer pubsub.Close()
+	messages := pubsub.Channel()
+	for {
+		select {
+		case msg, ok := <-messages:
+			if !ok {
+				return
+			}
+			n.notifyLocal(msg.Payload)
+		case <-ctx.Done():
+			return
+		}
+	}
+}
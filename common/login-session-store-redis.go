@@ -0,0 +1,257 @@
+package common
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strconv"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// 所有键与会话一同过期，无需清理；活跃会话集合仅用于计数，其中过期的成员在 Count() 时移除
+const (
+	loginSessionKeyPrefix        = "loginSession:token:"
+	loginSessionSceneKeyPrefix   = "loginSession:scene:"
+	loginSessionCodeKeyPrefix    = "loginSession:code:"
+	loginSessionConfirmKeyPrefix = "loginSession:confirm:"
+	loginSessionActiveKey        = "loginSession:active"
+	loginSessionUpdateMaxRetries = 3
+)
+
+type redisLoginSessionStore struct {
+	rdb *redis.Client
+}
+
+func newRedisLoginSessionStore(rdb *redis.Client) *redisLoginSessionStore {
+	return &redisLoginSessionStore{rdb: rdb}
+}
+
+func (s *redisLoginSessionStore) write(ctx context.Context, pipe redis.Pipeliner, session *LoginSession) error {
+	ttl := time.Until(session.ExpiredAt)
+	if ttl <= 0 {
+		return ErrLoginSessionNotFound
+	}
+	data, err := json.Marshal(session)
+	if err != nil {
+		return err
+	}
+	pipe.Set(ctx, loginSessionKeyPrefix+session.LoginToken, data, ttl)
+	pipe.Set(ctx, loginSessionSceneKeyPrefix+session.SceneID, session.LoginToken, ttl)
+	pipe.ZAdd(ctx, loginSessionActiveKey, &redis.Z{
+		Score:  float64(session.ExpiredAt.Unix()),
+		Member: session.LoginToken,
+	})
+	return nil
+}
+
+func (s *redisLoginSessionStore) Create(session *LoginSession) error {
+	ctx := context.Background()
+	var writeErr error
+	_, err := s.rdb.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		writeErr = s.write(ctx, pipe, session)
+		return writeErr
+	})
+	if writeErr != nil {
+		return writeErr
+	}
+	return err
+}
+
+func (s *redisLoginSessionStore) get(ctx context.Context, getter redis.Cmdable, loginToken string) (*LoginSession, error) {
+	data, err := getter.Get(ctx, loginSessionKeyPrefix+loginToken).Bytes()
+	if err == redis.Nil {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var session LoginSession
+	if err := json.Unmarshal(data, &session); err != nil {
+		return nil, err
+	}
+	if session.ExpiredAt.Before(time.Now()) {
+		return nil, nil
+	}
+	return &session, nil
+}
+
+func (s *redisLoginSessionStore) Get(loginToken string) (*LoginSession, error) {
+	return s.get(context.Background(), s.rdb, loginToken)
+}
+
+func (s *redisLoginSessionStore) getByIndex(key string) (*LoginSession, error) {
+	ctx := context.Background()
+	loginToken, err := s.rdb.Get(ctx, key).Result()
+	if err == redis.Nil {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return s.get(ctx, s.rdb, loginToken)
+}
+
+func (s *redisLoginSessionStore) GetByScene(sceneID string) (*LoginSession, error) {
+	return s.getByIndex(loginSessionSceneKeyPrefix + sceneID)
+}
+
+func (s *redisLoginSessionStore) Update(loginToken string, fn func(session *LoginSession) bool) (*LoginSession, error) {
+	ctx := context.Background()
+	key := loginSessionKeyPrefix + loginToken
+	var result *LoginSession
+	txf := func(tx *redis.Tx) error {
+		session, err := s.get(ctx, tx, loginToken)
+		if err != nil {
+			return err
+		}
+		if session == nil {
+			return ErrLoginSessionNotFound
+		}
+		// 与内存存储一致，fn 返回 false 时返回未修改的会话
+		updated := copyLoginSession(session)
+		if !fn(updated) {
+			result = session
+			return nil
+		}
+		_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			return s.write(ctx, pipe, updated)
+		})
+		result = updated
+		return err
+	}
+	if err := s.watch(ctx, txf, key); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// watch 以乐观事务执行 txf，键被并发修改时重试
+func (s *redisLoginSessionStore) watch(ctx context.Context, txf func(tx *redis.Tx) error, key string) error {
+	for i := 0; i < loginSessionUpdateMaxRetries; i++ {
+		err := s.rdb.Watch(ctx, txf, key)
+		if err == redis.TxFailedErr {
+			continue
+		}
+		return err
+	}
+	return errors.New("login session update conflict")
+}
+
+func (s *redisLoginSessionStore) Delete(loginToken string) error {
+	ctx := context.Background()
+	session, err := s.get(ctx, s.rdb, loginToken)
+	if err != nil {
+		return err
+	}
+	_, err = s.rdb.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.Del(ctx, loginSessionKeyPrefix+loginToken)
+		if session != nil {
+			pipe.Del(ctx, loginSessionSceneKeyPrefix+session.SceneID)
+		}
+		pipe.ZRem(ctx, loginSessionActiveKey, loginToken)
+		return nil
+	})
+	return err
+}
+
+func (s *redisLoginSessionStore) Count() (int, error) {
+	ctx := context.Background()
+	now := strconv.FormatInt(time.Now().Unix(), 10)
+	if err := s.rdb.ZRemRangeByScore(ctx, loginSessionActiveKey, "-inf", "("+now).Err(); err != nil {
+		return 0, err
+	}
+	count, err := s.rdb.ZCard(ctx, loginSessionActiveKey).Result()
+	return int(count), err
+}
+
+func (s *redisLoginSessionStore) writeAuthCode(ctx context.Context, pipe redis.Pipeliner, grant *AuthCodeGrant) error {
+	ttl := time.Until(grant.ExpiredAt.Add(AuthCodeRetentionDuration))
+	if ttl <= 0 {
+		return nil
+	}
+	data, err := json.Marshal(grant)
+	if err != nil {
+		return err
+	}
+	pipe.Set(ctx, loginSessionCodeKeyPrefix+grant.Code, data, ttl)
+	return nil
+}
+
+func (s *redisLoginSessionStore) SaveAuthCode(grant *AuthCodeGrant) error {
+	ctx := context.Background()
+	var writeErr error
+	_, err := s.rdb.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		writeErr = s.writeAuthCode(ctx, pipe, grant)
+		return writeErr
+	})
+	if writeErr != nil {
+		return writeErr
+	}
+	return err
+}
+
+func (s *redisLoginSessionStore) ConsumeAuthCode(code string, check func(grant *AuthCodeGrant) error) (*AuthCodeGrant, error) {
+	ctx := context.Background()
+	key := loginSessionCodeKeyPrefix + code
+	var result *AuthCodeGrant
+	var checkErr error
+	txf := func(tx *redis.Tx) error {
+		data, err := tx.Get(ctx, key).Bytes()
+		if err != nil && err != redis.Nil {
+			return err
+		}
+		var grant *AuthCodeGrant
+		if err == nil {
+			grant = &AuthCodeGrant{}
+			if err := json.Unmarshal(data, grant); err != nil {
+				return err
+			}
+		}
+		if checkErr = check(grant); checkErr != nil || grant == nil {
+			return nil
+		}
+		grant.Used = true
+		_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			return s.writeAuthCode(ctx, pipe, grant)
+		})
+		result = grant
+		return err
+	}
+	if err := s.watch(ctx, txf, key); err != nil {
+		return nil, err
+	}
+	if checkErr != nil {
+		return nil, checkErr
+	}
+	return result, nil
+}
+
+func (s *redisLoginSessionStore) SetPendingConfirmation(wechatID string, loginToken string, expiredAt time.Time) error {
+	ttl := time.Until(expiredAt)
+	if ttl <= 0 {
+		return nil
+	}
+	return s.rdb.Set(context.Background(), loginSessionConfirmKeyPrefix+wechatID, loginToken, ttl).Err()
+}
+
+func (s *redisLoginSessionStore) GetPendingConfirmation(wechatID string) (string, error) {
+	loginToken, err := s.rdb.Get(context.Background(), loginSessionConfirmKeyPrefix+wechatID).Result()
+	if err == redis.Nil {
+		return "", nil
+	}
+	return loginToken, err
+}
+
+// deleteIfEqualScript 仅当 KEYS[1] 的值为 ARGV[1] 时删除
+var deleteIfEqualScript = redis.NewScript(`
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("DEL", KEYS[1])
+end
+return 0
+`)
+
+func (s *redisLoginSessionStore) DeletePendingConfirmation(wechatID string, loginToken string) error {
+	return deleteIfEqualScript.Run(context.Background(), s.rdb, []string{loginSessionConfirmKeyPrefix + wechatID}, loginToken).Err()
+}
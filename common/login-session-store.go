@@ -0,0 +1,234 @@
+package common
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+var ErrLoginSessionNotFound = errors.New("login session not found")
+
+// LoginSessionStore 登录会话的存储，返回的会话均为副本，修改需通过 Update，已过期的会话不会被返回
+type LoginSessionStore interface {
+	Create(session *LoginSession) error
+	Get(loginToken string) (*LoginSession, error)
+	GetByScene(sceneID string) (*LoginSession, error)
+	// Update 原子地对会话执行 fn，fn 返回 false 时不写入
+	Update(loginToken string, fn func(session *LoginSession) bool) (*LoginSession, error)
+	Delete(loginToken string) error
+	Count() (int, error)
+	// SaveAuthCode 授权码保存至过期后 AuthCodeRetentionDuration
+	SaveAuthCode(grant *AuthCodeGrant) error
+	// ConsumeAuthCode 原子地校验授权码（不存在时为 nil）并标记为已使用
+	ConsumeAuthCode(code string, check func(grant *AuthCodeGrant) error) (*AuthCodeGrant, error)
+	// SetPendingConfirmation 记录微信用户已扫码、待确认的会话，直到 expiredAt
+	SetPendingConfirmation(wechatID string, loginToken string, expiredAt time.Time) error
+	// GetPendingConfirmation 没有待确认的会话时返回空的 login token
+	GetPendingConfirmation(wechatID string) (string, error)
+	// DeletePendingConfirmation 仅当记录仍指向 loginToken 时删除
+	DeletePendingConfirmation(wechatID string, loginToken string) error
+}
+
+type pendingConfirmation struct {
+	loginToken string
+	expiredAt  time.Time
+}
+
+type memoryLoginSessionStore struct {
+	sessions  map[string]*LoginSession       // 键为 login_token
+	scenes    map[string]string              // 键为 scene_id，值为 login_token
+	authCodes map[string]*AuthCodeGrant      // 键为授权码
+	confirms  map[string]pendingConfirmation // 键为 wechat_id
+	mutex     sync.RWMutex
+}
+
+func newMemoryLoginSessionStore() *memoryLoginSessionStore {
+	return &memoryLoginSessionStore{
+		sessions:  make(map[string]*LoginSession),
+		scenes:    make(map[string]string),
+		authCodes: make(map[string]*AuthCodeGrant),
+		confirms:  make(map[string]pendingConfirmation),
+	}
+}
+
+func copyLoginSession(session *LoginSession) *LoginSession {
+	sessionCopy := *session
+	if session.UserInfo != nil {
+		userInfo := *session.UserInfo
+		sessionCopy.UserInfo = &userInfo
+	}
+	return &sessionCopy
+}
+
+func (s *memoryLoginSessionStore) Create(session *LoginSession) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.sessions[session.LoginToken] = copyLoginSession(session)
+	s.scenes[session.SceneID] = session.LoginToken
+	return nil
+}
+
+// no lock inside!
+func (s *memoryLoginSessionStore) get(loginToken string) *LoginSession {
+	session, exists := s.sessions[loginToken]
+	if !exists || session.ExpiredAt.Before(time.Now()) {
+		return nil
+	}
+	return session
+}
+
+func (s *memoryLoginSessionStore) Get(loginToken string) (*LoginSession, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	session := s.get(loginToken)
+	if session == nil {
+		return nil, nil
+	}
+	return copyLoginSession(session), nil
+}
+
+func (s *memoryLoginSessionStore) GetByScene(sceneID string) (*LoginSession, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	session := s.get(s.scenes[sceneID])
+	if session == nil {
+		return nil, nil
+	}
+	return copyLoginSession(session), nil
+}
+
+func (s *memoryLoginSessionStore) Update(loginToken string, fn func(session *LoginSession) bool) (*LoginSession, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	session := s.get(loginToken)
+	if session == nil {
+		return nil, ErrLoginSessionNotFound
+	}
+	updated := copyLoginSession(session)
+	if !fn(updated) {
+		return copyLoginSession(session), nil
+	}
+	s.sessions[loginToken] = updated
+	return copyLoginSession(updated), nil
+}
+
+// no lock inside!
+func (s *memoryLoginSessionStore) remove(loginToken string) {
+	session, exists := s.sessions[loginToken]
+	if !exists {
+		return
+	}
+	delete(s.sessions, loginToken)
+	delete(s.scenes, session.SceneID)
+}
+
+func (s *memoryLoginSessionStore) Delete(loginToken string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.remove(loginToken)
+	return nil
+}
+
+func (s *memoryLoginSessionStore) Count() (int, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	return len(s.sessions), nil
+}
+
+func copyAuthCodeGrant(grant *AuthCodeGrant) *AuthCodeGrant {
+	grantCopy := *grant
+	if grant.UserInfo != nil {
+		userInfo := *grant.UserInfo
+		grantCopy.UserInfo = &userInfo
+	}
+	return &grantCopy
+}
+
+func (s *memoryLoginSessionStore) SaveAuthCode(grant *AuthCodeGrant) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.authCodes[grant.Code] = copyAuthCodeGrant(grant)
+	return nil
+}
+
+func (s *memoryLoginSessionStore) ConsumeAuthCode(code string, check func(grant *AuthCodeGrant) error) (*AuthCodeGrant, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	grant, exists := s.authCodes[code]
+	if exists && grant.ExpiredAt.Add(AuthCodeRetentionDuration).Before(time.Now()) {
+		delete(s.authCodes, code)
+		exists = false
+	}
+	if !exists {
+		return nil, check(nil)
+	}
+	if err := check(copyAuthCodeGrant(grant)); err != nil {
+		return nil, err
+	}
+	grant.Used = true
+	return copyAuthCodeGrant(grant), nil
+}
+
+func (s *memoryLoginSessionStore) SetPendingConfirmation(wechatID string, loginToken string, expiredAt time.Time) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.confirms[wechatID] = pendingConfirmation{
+		loginToken: loginToken,
+		expiredAt:  expiredAt,
+	}
+	return nil
+}
+
+func (s *memoryLoginSessionStore) GetPendingConfirmation(wechatID string) (string, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	confirm, exists := s.confirms[wechatID]
+	if !exists || confirm.expiredAt.Before(time.Now()) {
+		return "", nil
+	}
+	return confirm.loginToken, nil
+}
+
+func (s *memoryLoginSessionStore) DeletePendingConfirmation(wechatID string, loginToken string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.confirms[wechatID].loginToken == loginToken {
+		delete(s.confirms, wechatID)
+	}
+	return nil
+}
+
+func (s *memoryLoginSessionStore) startCleanup(ctx context.Context) {
+	ticker := time.NewTicker(1 * time.Minute)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+		s.mutex.Lock()
+		now := time.Now()
+
+		for loginToken, session := range s.sessions {
+			if session.ExpiredAt.Before(now) {
+				s.remove(loginToken)
+			}
+		}
+		for code, grant := range s.authCodes {
+			if grant.ExpiredAt.Add(AuthCodeRetentionDuration).Before(now) {
+				delete(s.authCodes, code)
+			}
+		}
+		for wechatID, confirm := range s.confirms {
+			if confirm.expiredAt.Before(now) {
+				delete(s.confirms, wechatID)
+			}
+		}
+
+		SysLog(fmt.Sprintf("Cleanup completed, active sessions: %d", len(s.sessions)))
+		s.mutex.Unlock()
+	}
+}
@@ -0,0 +1,188 @@
+package common
+
+import (
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-redis/redis/v8"
+)
+
+// testLoginSessionStores runs the test against the memory store and the Redis store backed by miniredis
+func testLoginSessionStores(t *testing.T, test func(t *testing.T, store LoginSessionStore)) {
+	t.Run("memory", func(t *testing.T) {
+		test(t, newMemoryLoginSessionStore())
+	})
+	t.Run("redis", func(t *testing.T) {
+		server := miniredis.RunT(t)
+		rdb := redis.NewClient(&redis.Options{Addr: server.Addr()})
+		t.Cleanup(func() {
+			rdb.Close()
+		})
+		test(t, newRedisLoginSessionStore(rdb))
+	})
+}
+
+func newTestLoginSession(loginToken string, ttl time.Duration) *LoginSession {
+	return &LoginSession{
+		LoginToken: loginToken,
+		SceneID:    "scene_" + loginToken,
+		Status:     SessionStatusPending,
+		CreatedAt:  time.Now(),
+		ExpiredAt:  time.Now().Add(ttl),
+	}
+}
+
+func mustCreateLoginSession(t *testing.T, store LoginSessionStore, session *LoginSession) {
+	t.Helper()
+	if err := store.Create(session); err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+}
+
+func TestLoginSessionStoreGet(t *testing.T) {
+	testLoginSessionStores(t, func(t *testing.T, store LoginSessionStore) {
+		mustCreateLoginSession(t, store, newTestLoginSession("t1", time.Minute))
+		session, err := store.Get("t1")
+		if err != nil || session == nil || session.SceneID != "scene_t1" {
+			t.Fatalf("Get: got %+v, %v", session, err)
+		}
+		session, err = store.GetByScene("scene_t1")
+		if err != nil || session == nil || session.LoginToken != "t1" {
+			t.Fatalf("GetByScene: got %+v, %v", session, err)
+		}
+		for _, get := range []func() (*LoginSession, error){
+			func() (*LoginSession, error) { return store.Get("unknown") },
+			func() (*LoginSession, error) { return store.GetByScene("unknown") },
+		} {
+			if session, err := get(); err != nil || session != nil {
+				t.Errorf("unknown session: got %+v, %v", session, err)
+			}
+		}
+		if count, err := store.Count(); err != nil || count != 1 {
+			t.Errorf("Count: got %d, %v, want 1", count, err)
+		}
+	})
+}
+
+func TestLoginSessionStoreExpired(t *testing.T) {
+	testLoginSessionStores(t, func(t *testing.T, store LoginSessionStore) {
+		session := newTestLoginSession("t1", time.Minute)
+		mustCreateLoginSession(t, store, session)
+		// Expired sessions are never returned, whether or not they are removed yet
+		if _, err := store.Update("t1", func(s *LoginSession) bool {
+			s.ExpiredAt = time.Now().Add(100 * time.Millisecond)
+			return true
+		}); err != nil {
+			t.Fatalf("Update: %v", err)
+		}
+		time.Sleep(150 * time.Millisecond)
+		if session, _ := store.Get("t1"); session != nil {
+			t.Error("expired session returned by Get")
+		}
+		if session, _ := store.GetByScene("scene_t1"); session != nil {
+			t.Error("expired session returned by GetByScene")
+		}
+		if _, err := store.Update("t1", func(*LoginSession) bool { return true }); err != ErrLoginSessionNotFound {
+			t.Errorf("Update: got %v, want ErrLoginSessionNotFound", err)
+		}
+	})
+}
+
+func TestLoginSessionStoreUpdate(t *testing.T) {
+	testLoginSessionStores(t, func(t *testing.T, store LoginSessionStore) {
+		mustCreateLoginSession(t, store, newTestLoginSession("t1", time.Minute))
+		updated, err := store.Update("t1", func(s *LoginSession) bool {
+			s.Status = SessionStatusSuccess
+			s.UserInfo = &WeChatUserInfo{OpenID: "wx1"}
+			return true
+		})
+		if err != nil || updated.Status != SessionStatusSuccess {
+			t.Fatalf("Update: got %+v, %v", updated, err)
+		}
+		// Nothing is written if fn returns false
+		unchanged, err := store.Update("t1", func(s *LoginSession) bool {
+			s.Status = SessionStatusRejected
+			return false
+		})
+		if err != nil || unchanged.Status != SessionStatusSuccess {
+			t.Fatalf("Update returning false: got %+v, %v", unchanged, err)
+		}
+		// Returned sessions are copies
+		session, _ := store.Get("t1")
+		session.Status = SessionStatusRejected
+		session.UserInfo.OpenID = "wx2"
+		session, _ = store.Get("t1")
+		if session.Status != SessionStatusSuccess || session.UserInfo.OpenID != "wx1" {
+			t.Errorf("stored session modified through a copy: %+v", session)
+		}
+		if _, err := store.Update("unknown", func(*LoginSession) bool { return true }); err != ErrLoginSessionNotFound {
+			t.Errorf("Update unknown: got %v, want ErrLoginSessionNotFound", err)
+		}
+	})
+}
+
+func TestLoginSessionStoreDelete(t *testing.T) {
+	testLoginSessionStores(t, func(t *testing.T, store LoginSessionStore) {
+		mustCreateLoginSession(t, store, newTestLoginSession("t1", time.Minute))
+		mustCreateLoginSession(t, store, newTestLoginSession("t2", time.Minute))
+		if err := store.Delete("t1"); err != nil {
+			t.Fatalf("Delete: %v", err)
+		}
+		if session, _ := store.Get("t1"); session != nil {
+			t.Error("deleted session returned by Get")
+		}
+		if session, _ := store.GetByScene("scene_t1"); session != nil {
+			t.Error("deleted session returned by GetByScene")
+		}
+		if count, err := store.Count(); err != nil || count != 1 {
+			t.Errorf("Count: got %d, %v, want 1", count, err)
+		}
+	})
+}
+
+func TestLoginSessionStoreAuthCode(t *testing.T) {
+	testLoginSessionStores(t, func(t *testing.T, store LoginSessionStore) {
+		grant := &AuthCodeGrant{Code: "c1", ClientID: "app", ExpiredAt: time.Now().Add(time.Minute)}
+		if err := store.SaveAuthCode(grant); err != nil {
+			t.Fatalf("SaveAuthCode: %v", err)
+		}
+		check := func(grant *AuthCodeGrant) error {
+			return checkAuthCodeGrant(grant, "app")
+		}
+		consumed, err := store.ConsumeAuthCode("c1", check)
+		if err != nil || consumed == nil || !consumed.Used {
+			t.Fatalf("ConsumeAuthCode: got %+v, %v", consumed, err)
+		}
+		if _, err := store.ConsumeAuthCode("c1", check); err != ErrAuthCodeUsed {
+			t.Errorf("ConsumeAuthCode again: got %v, want ErrAuthCodeUsed", err)
+		}
+		if _, err := store.ConsumeAuthCode("unknown", check); err != ErrAuthCodeInvalid {
+			t.Errorf("ConsumeAuthCode unknown: got %v, want ErrAuthCodeInvalid", err)
+		}
+	})
+}
+
+func TestLoginSessionStorePendingConfirmation(t *testing.T) {
+	testLoginSessionStores(t, func(t *testing.T, store LoginSessionStore) {
+		if err := store.SetPendingConfirmation("wx1", "t1", time.Now().Add(time.Minute)); err != nil {
+			t.Fatalf("SetPendingConfirmation: %v", err)
+		}
+		if loginToken, err := store.GetPendingConfirmation("wx1"); err != nil || loginToken != "t1" {
+			t.Fatalf("GetPendingConfirmation: got %q, %v", loginToken, err)
+		}
+		// Only removed if it still points to the login token
+		if err := store.DeletePendingConfirmation("wx1", "t2"); err != nil {
+			t.Fatalf("DeletePendingConfirmation: %v", err)
+		}
+		if loginToken, _ := store.GetPendingConfirmation("wx1"); loginToken != "t1" {
+			t.Errorf("removed by another login token, got %q", loginToken)
+		}
+		if err := store.DeletePendingConfirmation("wx1", "t1"); err != nil {
+			t.Fatalf("DeletePendingConfirmation: %v", err)
+		}
+		if loginToken, _ := store.GetPendingConfirmation("wx1"); loginToken != "" {
+			t.Errorf("not removed, got %q", loginToken)
+		}
+	})
+}
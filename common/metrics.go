@@ -0,0 +1,152 @@
+package common
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// Metrics are registered to the default Prometheus registry and exposed at /metrics.
+// Label values from requests are mapped to a known set, so they can't blow up the number of series.
+const metricsNamespace = "wechat_server"
+
+var (
+	httpRequestsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "http_requests_total",
+		Help:      "HTTP requests by route and status.",
+	}, []string{"method", "route", "status"})
+	httpRequestDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: metricsNamespace,
+		Name:      "http_request_duration_seconds",
+		Help:      "HTTP request latencies by route.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"method", "route"})
+	wechatCallbacksTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "wechat_callbacks_total",
+		Help:      "Messages and events pushed by WeChat.",
+	}, []string{"msg_type", "event"})
+	wechatAPICallsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "wechat_api_calls_total",
+		Help:      "Calls to the WeChat API by endpoint and errcode, errcode is \"error\" if there is no valid response.",
+	}, []string{"endpoint", "errcode"})
+	accessTokenRefreshesTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "access_token_refreshes_total",
+		Help:      "Refreshes of the WeChat access token by result.",
+	}, []string{"result"})
+	verificationCodesIssuedTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "verification_codes_issued_total",
+		Help:      "Verification codes issued by purpose.",
+	}, []string{"purpose"})
+	verificationCodesConsumedTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "verification_codes_consumed_total",
+		Help:      "Verification codes used successfully by purpose.",
+	}, []string{"purpose"})
+	rateLimitRejectionsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "rate_limit_rejections_total",
+		Help:      "Requests rejected by the rate limiters by mark.",
+	}, []string{"mark"})
+)
+
+func init() {
+	promauto.NewGaugeFunc(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Name:      "access_token_age_seconds",
+		Help:      "Seconds since the WeChat access token was refreshed successfully, -1 if it never was.",
+	}, func() float64 {
+		refreshedTime := GetAccessTokenRefreshedTime()
+		if refreshedTime.IsZero() {
+			return -1
+		}
+		return time.Since(refreshedTime).Seconds()
+	})
+	promauto.NewGaugeFunc(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Name:      "login_sessions_active",
+		Help:      "Active WeChat login sessions.",
+	}, func() float64 {
+		return float64(GetSessionManager().GetActiveSessionCount())
+	})
+}
+
+var knownHTTPMethods = []string{http.MethodGet, http.MethodHead, http.MethodPost, http.MethodPut, http.MethodPatch,
+	http.MethodDelete, http.MethodConnect, http.MethodOptions, http.MethodTrace}
+var knownWeChatMsgTypes = []string{"text", "image", "voice", "video", "shortvideo", "location", "link", "event"}
+var knownWeChatEvents = []string{"subscribe", "unsubscribe", "SCAN", "LOCATION", "CLICK", "VIEW"}
+
+var verificationPurposeNames = map[string]string{
+	EmailVerificationPurpose:  "email",
+	PasswordResetPurpose:      "password_reset",
+	WeChatVerificationPurpose: "wechat",
+}
+
+func metricLabel(value string, known []string) string {
+	for _, k := range known {
+		if value == k {
+			return value
+		}
+	}
+	if value == "" {
+		return ""
+	}
+	return "other"
+}
+
+func verificationPurposeLabel(purpose string) string {
+	if name, ok := verificationPurposeNames[purpose]; ok {
+		return name
+	}
+	return "other"
+}
+
+// ObserveHTTPRequest route is the route pattern, so requests of different ids are counted together,
+// methods other than the standard ones are counted as other
+func ObserveHTTPRequest(method string, route string, status int, duration time.Duration) {
+	if route == "" {
+		route = "unmatched"
+	}
+	method = metricLabel(method, knownHTTPMethods)
+	httpRequestsTotal.WithLabelValues(method, route, strconv.Itoa(status)).Inc()
+	httpRequestDuration.WithLabelValues(method, route).Observe(duration.Seconds())
+}
+
+func RecordWeChatCallback(msgType string, event string) {
+	wechatCallbacksTotal.WithLabelValues(metricLabel(msgType, knownWeChatMsgTypes), metricLabel(event, knownWeChatEvents)).Inc()
+}
+
+// RecordWeChatAPICall err is the error of the request itself, errcode the one in the response
+func RecordWeChatAPICall(endpoint string, errcode int, err error) {
+	code := strconv.Itoa(errcode)
+	if err != nil {
+		code = "error"
+	}
+	wechatAPICallsTotal.WithLabelValues(endpoint, code).Inc()
+}
+
+func recordAccessTokenRefresh(success bool) {
+	result := "success"
+	if !success {
+		result = "failure"
+	}
+	accessTokenRefreshesTotal.WithLabelValues(result).Inc()
+}
+
+func recordVerificationCodeIssued(purpose string) {
+	verificationCodesIssuedTotal.WithLabelValues(verificationPurposeLabel(purpose)).Inc()
+}
+
+func recordVerificationCodeConsumed(purpose string) {
+	verificationCodesConsumedTotal.WithLabelValues(verificationPurposeLabel(purpose)).Inc()
+}
+
+func RecordRateLimitRejection(mark string) {
+	rateLimitRejectionsTotal.WithLabelValues(mark).Inc()
+}
@@ -1,6 +1,7 @@
 package common
 
 import (
+	"context"
 	"sync"
 	"time"
 )
@@ -18,16 +19,18 @@ func (l *InMemoryRateLimiter) Init(expirationDuration time.Duration) {
 			l.store = make(map[string]*[]int64)
 			l.expirationDuration = expirationDuration
 			if expirationDuration > 0 {
-				go l.clearExpiredItems()
+				GoWorker(l.clearExpiredItems)
 			}
 		}
 		l.mutex.Unlock()
 	}
 }
 
-func (l *InMemoryRateLimiter) clearExpiredItems() {
+func (l *InMemoryRateLimiter) clearExpiredItems(ctx context.Context) {
 	for {
-		time.Sleep(l.expirationDuration)
+		if !sleepContext(ctx, l.expirationDuration) {
+			return
+		}
 		l.mutex.Lock()
 		now := time.Now().Unix()
 		for key := range l.store {
@@ -3,21 +3,20 @@ package common
 import (
 	"context"
 	"github.com/go-redis/redis/v8"
-	"os"
 	"time"
 )
 
 var RDB *redis.Client
 var RedisEnabled = true
 
-// InitRedisClient This function is called after init()
+// InitRedisClient This function is called after Init()
 func InitRedisClient() (err error) {
-	if os.Getenv("REDIS_CONN_STRING") == "" {
+	if Config.RedisConnString == "" {
 		RedisEnabled = false
 		SysLog("REDIS_CONN_STRING not set, Redis is not enabled")
 		return nil
 	}
-	opt, err := redis.ParseURL(os.Getenv("REDIS_CONN_STRING"))
+	opt, err := redis.ParseURL(Config.RedisConnString)
 	if err != nil {
 		panic(err)
 	}
@@ -31,9 +30,13 @@ func InitRedisClient() (err error) {
 }
 
 func ParseRedisOption() *redis.Options {
-	opt, err := redis.ParseURL(os.Getenv("REDIS_CONN_STRING"))
+	opt, err := redis.ParseURL(Config.RedisConnString)
 	if err != nil {
 		panic(err)
 	}
 	return opt
 }
+
+func PingRedis(ctx context.Context) error {
+	return RDB.Ping(ctx).Err()
+}
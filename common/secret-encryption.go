@@ -0,0 +1,183 @@
+package common
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Secrets stored in the database are encrypted with envelope encryption: each value is encrypted with AES-256-GCM
+// by a random data key, which is encrypted by the master key. The id of the master key is stored with the value,
+// so rotating the master key only re-encrypts the data keys, and values of the previous key can still be read.
+// Format: enc:v1:<master key id>:<encrypted data key>:<encrypted value>
+
+const encryptedSecretPrefix = "enc:v1:"
+
+type masterKey struct {
+	id   string
+	aead cipher.AEAD
+}
+
+// The first key encrypts, the others only decrypt
+var masterKeys []masterKey
+
+var ErrNoMasterKey = errors.New("the master key is not set, see MASTER_KEY")
+
+// ParseMasterKey the master key is 32 random bytes encoded in base64, e.g. from `openssl rand -base64 32`
+func ParseMasterKey(s string) ([]byte, error) {
+	key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(s))
+	if err != nil || len(key) != 32 {
+		return nil, errors.New("the master key must be 32 bytes encoded in base64")
+	}
+	return key, nil
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// SetMasterKeys the first key is the current one, empty keys are skipped
+func SetMasterKeys(keys ...string) error {
+	var parsed []masterKey
+	for _, s := range keys {
+		if s == "" {
+			continue
+		}
+		key, err := ParseMasterKey(s)
+		if err != nil {
+			return err
+		}
+		aead, err := newAEAD(key)
+		if err != nil {
+			return err
+		}
+		sum := sha256.Sum256(key)
+		parsed = append(parsed, masterKey{id: hex.EncodeToString(sum[:4]), aead: aead})
+	}
+	masterKeys = parsed
+	return nil
+}
+
+func SecretEncryptionEnabled() bool {
+	return len(masterKeys) > 0
+}
+
+func IsEncryptedSecret(value string) bool {
+	return strings.HasPrefix(value, encryptedSecretPrefix)
+}
+
+// sealWithNonce prepends the random nonce to the ciphertext
+func sealWithNonce(aead cipher.AEAD, plaintext []byte, additionalData []byte) []byte {
+	nonce := make([]byte, aead.NonceSize(), aead.NonceSize()+len(plaintext)+aead.Overhead())
+	_, _ = rand.Read(nonce)
+	return aead.Seal(nonce, nonce, plaintext, additionalData)
+}
+
+func openWithNonce(aead cipher.AEAD, data []byte, additionalData []byte) ([]byte, error) {
+	if len(data) < aead.NonceSize() {
+		return nil, errors.New("ciphertext too short")
+	}
+	return aead.Open(nil, data[:aead.NonceSize()], data[aead.NonceSize():], additionalData)
+}
+
+// EncryptSecret name is bound to the ciphertext, so the value can't be decrypted as the one of another name
+func EncryptSecret(name string, plaintext string) (string, error) {
+	if !SecretEncryptionEnabled() {
+		return "", ErrNoMasterKey
+	}
+	dataKey := make([]byte, 32)
+	if _, err := rand.Read(dataKey); err != nil {
+		return "", err
+	}
+	aead, err := newAEAD(dataKey)
+	if err != nil {
+		return "", err
+	}
+	ciphertext := sealWithNonce(aead, []byte(plaintext), []byte(name))
+	return encodeEncryptedSecret(masterKeys[0], name, dataKey, ciphertext), nil
+}
+
+func encodeEncryptedSecret(key masterKey, name string, dataKey []byte, ciphertext []byte) string {
+	wrappedKey := sealWithNonce(key.aead, dataKey, []byte(name))
+	return encryptedSecretPrefix + key.id + ":" + base64.RawStdEncoding.EncodeToString(wrappedKey) + ":" +
+		base64.RawStdEncoding.EncodeToString(ciphertext)
+}
+
+// decodeEncryptedSecret returns the data key and the ciphertext
+func decodeEncryptedSecret(name string, value string) ([]byte, []byte, error) {
+	parts := strings.Split(strings.TrimPrefix(value, encryptedSecretPrefix), ":")
+	if len(parts) != 3 {
+		return nil, nil, errors.New("malformed encrypted secret")
+	}
+	var key *masterKey
+	for i := range masterKeys {
+		if masterKeys[i].id == parts[0] {
+			key = &masterKeys[i]
+		}
+	}
+	if key == nil {
+		if !SecretEncryptionEnabled() {
+			return nil, nil, ErrNoMasterKey
+		}
+		return nil, nil, fmt.Errorf("the master key %s is unknown, set it as PREVIOUS_MASTER_KEY", parts[0])
+	}
+	wrappedKey, err := base64.RawStdEncoding.DecodeString(parts[1])
+	if err != nil {
+		return nil, nil, errors.New("malformed encrypted secret")
+	}
+	ciphertext, err := base64.RawStdEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, nil, errors.New("malformed encrypted secret")
+	}
+	dataKey, err := openWithNonce(key.aead, wrappedKey, []byte(name))
+	if err != nil {
+		return nil, nil, errors.New("failed to decrypt the data key, the master key may be wrong")
+	}
+	return dataKey, ciphertext, nil
+}
+
+func DecryptSecret(name string, value string) (string, error) {
+	dataKey, ciphertext, err := decodeEncryptedSecret(name, value)
+	if err != nil {
+		return "", err
+	}
+	aead, err := newAEAD(dataKey)
+	if err != nil {
+		return "", err
+	}
+	plaintext, err := openWithNonce(aead, ciphertext, []byte(name))
+	if err != nil {
+		return "", errors.New("failed to decrypt the secret")
+	}
+	return string(plaintext), nil
+}
+
+// ReencryptSecret returns the value with its data key encrypted by the current master key, plaintext values are
+// encrypted. The bool is false if the value is already encrypted by the current master key.
+func ReencryptSecret(name string, value string) (string, bool, error) {
+	if !SecretEncryptionEnabled() {
+		return "", false, ErrNoMasterKey
+	}
+	if !IsEncryptedSecret(value) {
+		encrypted, err := EncryptSecret(name, value)
+		return encrypted, err == nil, err
+	}
+	if strings.HasPrefix(value, encryptedSecretPrefix+masterKeys[0].id+":") {
+		return value, false, nil
+	}
+	dataKey, ciphertext, err := decodeEncryptedSecret(name, value)
+	if err != nil {
+		return "", false, err
+	}
+	return encodeEncryptedSecret(masterKeys[0], name, dataKey, ciphertext), true, nil
+}
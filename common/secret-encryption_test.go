@@ -0,0 +1,158 @@
+package common
+
+import (
+	"bytes"
+	"encoding/base64"
+	"strings"
+	"testing"
+)
+
+func testMasterKey(b byte) string {
+	return base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{b}, 32))
+}
+
+// setTestMasterKeys sets the keys for the test and restores the previous ones afterwards
+func setTestMasterKeys(t *testing.T, keys ...string) {
+	t.Helper()
+	previous := masterKeys
+	t.Cleanup(func() {
+		masterKeys = previous
+	})
+	if err := SetMasterKeys(keys...); err != nil {
+		t.Fatalf("SetMasterKeys: %v", err)
+	}
+}
+
+func mustEncryptSecret(t *testing.T, name string, plaintext string) string {
+	t.Helper()
+	encrypted, err := EncryptSecret(name, plaintext)
+	if err != nil {
+		t.Fatalf("EncryptSecret: %v", err)
+	}
+	return encrypted
+}
+
+func TestParseMasterKey(t *testing.T) {
+	if _, err := ParseMasterKey(testMasterKey(1)); err != nil {
+		t.Errorf("valid key rejected: %v", err)
+	}
+	for _, key := range []string{"", "not base64!", base64.StdEncoding.EncodeToString(make([]byte, 16))} {
+		if _, err := ParseMasterKey(key); err == nil {
+			t.Errorf("invalid key %q accepted", key)
+		}
+	}
+}
+
+func TestEncryptSecretRoundTrip(t *testing.T) {
+	setTestMasterKeys(t, testMasterKey(1))
+	encrypted := mustEncryptSecret(t, "WeChatAppSecret", "s3cret")
+	if !IsEncryptedSecret(encrypted) || strings.Contains(encrypted, "s3cret") {
+		t.Fatalf("value isn't encrypted: %s", encrypted)
+	}
+	if again := mustEncryptSecret(t, "WeChatAppSecret", "s3cret"); again == encrypted {
+		t.Error("encrypting the same value twice gave the same ciphertext")
+	}
+	decrypted, err := DecryptSecret("WeChatAppSecret", encrypted)
+	if err != nil {
+		t.Fatalf("DecryptSecret: %v", err)
+	}
+	if decrypted != "s3cret" {
+		t.Errorf("got %q, want %q", decrypted, "s3cret")
+	}
+}
+
+func TestEncryptSecretWithoutMasterKey(t *testing.T) {
+	setTestMasterKeys(t, testMasterKey(1))
+	encrypted := mustEncryptSecret(t, "SMTPToken", "s3cret")
+	setTestMasterKeys(t)
+	if _, err := EncryptSecret("SMTPToken", "s3cret"); err != ErrNoMasterKey {
+		t.Errorf("EncryptSecret: got %v, want ErrNoMasterKey", err)
+	}
+	if _, err := DecryptSecret("SMTPToken", encrypted); err != ErrNoMasterKey {
+		t.Errorf("DecryptSecret: got %v, want ErrNoMasterKey", err)
+	}
+}
+
+func TestDecryptSecretWrongKey(t *testing.T) {
+	setTestMasterKeys(t, testMasterKey(1))
+	encrypted := mustEncryptSecret(t, "SMTPToken", "s3cret")
+	setTestMasterKeys(t, testMasterKey(2))
+	if _, err := DecryptSecret("SMTPToken", encrypted); err == nil {
+		t.Error("decrypted with a different master key")
+	}
+}
+
+func TestDecryptSecretTampered(t *testing.T) {
+	setTestMasterKeys(t, testMasterKey(1))
+	encrypted := mustEncryptSecret(t, "SMTPToken", "s3cret")
+	// The value is bound to the name of the option
+	if _, err := DecryptSecret("GitHubClientSecret", encrypted); err == nil {
+		t.Error("decrypted as another option")
+	}
+	parts := strings.Split(encrypted, ":")
+	ciphertext, _ := base64.RawStdEncoding.DecodeString(parts[len(parts)-1])
+	ciphertext[len(ciphertext)-1] ^= 1
+	parts[len(parts)-1] = base64.RawStdEncoding.EncodeToString(ciphertext)
+	if _, err := DecryptSecret("SMTPToken", strings.Join(parts, ":")); err == nil {
+		t.Error("decrypted a modified ciphertext")
+	}
+	if _, err := DecryptSecret("SMTPToken", encryptedSecretPrefix+"malformed"); err == nil {
+		t.Error("decrypted a malformed value")
+	}
+}
+
+func TestDecryptSecretPreviousKey(t *testing.T) {
+	setTestMasterKeys(t, testMasterKey(1))
+	encrypted := mustEncryptSecret(t, "SMTPToken", "s3cret")
+	setTestMasterKeys(t, testMasterKey(2), testMasterKey(1))
+	decrypted, err := DecryptSecret("SMTPToken", encrypted)
+	if err != nil {
+		t.Fatalf("DecryptSecret: %v", err)
+	}
+	if decrypted != "s3cret" {
+		t.Errorf("got %q, want %q", decrypted, "s3cret")
+	}
+}
+
+func TestReencryptSecret(t *testing.T) {
+	setTestMasterKeys(t, testMasterKey(1))
+	encrypted := mustEncryptSecret(t, "SMTPToken", "s3cret")
+	setTestMasterKeys(t, testMasterKey(2), testMasterKey(1))
+	rotated, changed, err := ReencryptSecret("SMTPToken", encrypted)
+	if err != nil {
+		t.Fatalf("ReencryptSecret: %v", err)
+	}
+	if !changed || rotated == encrypted {
+		t.Fatal("the value encrypted by the previous key wasn't re-encrypted")
+	}
+	if _, changed, _ := ReencryptSecret("SMTPToken", rotated); changed {
+		t.Error("the value encrypted by the current key was re-encrypted")
+	}
+	// The previous key can be removed after the rotation
+	setTestMasterKeys(t, testMasterKey(2))
+	decrypted, err := DecryptSecret("SMTPToken", rotated)
+	if err != nil {
+		t.Fatalf("DecryptSecret: %v", err)
+	}
+	if decrypted != "s3cret" {
+		t.Errorf("got %q, want %q", decrypted, "s3cret")
+	}
+}
+
+func TestReencryptSecretPlaintext(t *testing.T) {
+	setTestMasterKeys(t, testMasterKey(1))
+	encrypted, changed, err := ReencryptSecret("SMTPToken", "s3cret")
+	if err != nil {
+		t.Fatalf("ReencryptSecret: %v", err)
+	}
+	if !changed || !IsEncryptedSecret(encrypted) {
+		t.Fatalf("the plaintext value wasn't encrypted: %s", encrypted)
+	}
+	if decrypted, _ := DecryptSecret("SMTPToken", encrypted); decrypted != "s3cret" {
+		t.Errorf("got %q, want %q", decrypted, "s3cret")
+	}
+	setTestMasterKeys(t)
+	if _, _, err := ReencryptSecret("SMTPToken", "s3cret"); err != ErrNoMasterKey {
+		t.Errorf("got %v, want ErrNoMasterKey", err)
+	}
+}
@@ -0,0 +1,90 @@
+package common
+
+import (
+	"testing"
+	"time"
+)
+
+// The key of the reference vectors and its base32 encoding
+var rfcTOTPKey = []byte("12345678901234567890")
+
+const rfcTOTPSecret = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+
+func TestHOTP(t *testing.T) {
+	// RFC 4226 appendix D
+	want := []string{"755224", "287082", "359152", "969429", "338314", "254676", "287922", "162583", "399871", "520489"}
+	for counter, code := range want {
+		if got := hotp(rfcTOTPKey, int64(counter)); got != code {
+			t.Errorf("hotp(%d) = %s, want %s", counter, got, code)
+		}
+	}
+}
+
+func TestValidateTOTP(t *testing.T) {
+	// RFC 6238 appendix B, SHA-1, truncated to the last 6 digits
+	tests := []struct {
+		time    int64
+		counter int64
+		code    string
+	}{
+		{59, 0x1, "287082"},
+		{1111111109, 0x23523EC, "081804"},
+		{1111111111, 0x23523ED, "050471"},
+		{1234567890, 0x273EF07, "005924"},
+		{2000000000, 0x3F940AA, "279037"},
+		{20000000000, 0x27BC86AA, "353130"},
+	}
+	for _, test := range tests {
+		counter, ok := ValidateTOTP(rfcTOTPSecret, test.code, time.Unix(test.time, 0), 0)
+		if !ok || counter != test.counter {
+			t.Errorf("ValidateTOTP(%s) at %d = %d, %v, want %d, true", test.code, test.time, counter, ok, test.counter)
+		}
+	}
+}
+
+func TestValidateTOTPSkew(t *testing.T) {
+	now := time.Unix(1111111111, 0)
+	previous := hotp(rfcTOTPKey, TOTPCounter(now)-1)
+	if _, ok := ValidateTOTP(rfcTOTPSecret, previous, now, 0); !ok {
+		t.Error("the code of the previous step was rejected")
+	}
+	tooOld := hotp(rfcTOTPKey, TOTPCounter(now)-TOTPSkew-1)
+	if _, ok := ValidateTOTP(rfcTOTPSecret, tooOld, now, 0); ok {
+		t.Error("a code outside the skew was accepted")
+	}
+}
+
+func TestValidateTOTPReplay(t *testing.T) {
+	now := time.Unix(1111111111, 0)
+	counter, ok := ValidateTOTP(rfcTOTPSecret, "050471", now, 0)
+	if !ok {
+		t.Fatal("valid code rejected")
+	}
+	if _, ok := ValidateTOTP(rfcTOTPSecret, "050471", now, counter); ok {
+		t.Error("the code was accepted twice")
+	}
+	// The code of an earlier step is rejected once a later one has been used
+	previous := hotp(rfcTOTPKey, counter-1)
+	if _, ok := ValidateTOTP(rfcTOTPSecret, previous, now, counter); ok {
+		t.Error("the code of an earlier step was accepted after a later one")
+	}
+	next := hotp(rfcTOTPKey, counter+1)
+	if c, ok := ValidateTOTP(rfcTOTPSecret, next, now, counter); !ok || c != counter+1 {
+		t.Error("the code of the next step was rejected")
+	}
+}
+
+func TestValidateTOTPInput(t *testing.T) {
+	now := time.Unix(59, 0)
+	if _, ok := ValidateTOTP("gezdgnbvgy3tqojqgezdgnbvgy3tqojq", " 287082 ", now, 0); !ok {
+		t.Error("lowercase secret or surrounding spaces rejected")
+	}
+	for _, code := range []string{"", "28708", "2870820", "abcdef"} {
+		if _, ok := ValidateTOTP(rfcTOTPSecret, code, now, 0); ok {
+			t.Errorf("invalid code %q accepted", code)
+		}
+	}
+	if _, ok := ValidateTOTP("not base32!", "287082", now, 0); ok {
+		t.Error("invalid secret accepted")
+	}
+}
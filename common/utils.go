@@ -124,3 +124,11 @@ func Max(a int, b int) int {
 		return b
 	}
 }
+
+func Min(a int, b int) int {
+	if a <= b {
+		return a
+	} else {
+		return b
+	}
+}
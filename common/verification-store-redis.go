@@ -0,0 +1,102 @@
+package common
+
+import (
+	"context"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+const verificationKeyPrefix = "verification:"
+
+type redisVerificationStore struct {
+	rdb *redis.Client
+}
+
+func newRedisVerificationStore(rdb *redis.Client) *redisVerificationStore {
+	return &redisVerificationStore{rdb: rdb}
+}
+
+func (s *redisVerificationStore) Set(purpose string, key string, value string, ttl time.Duration) error {
+	return s.rdb.Set(context.Background(), verificationKeyPrefix+verificationCodeKey(purpose, key), value, ttl).Err()
+}
+
+func (s *redisVerificationStore) Add(purpose string, key string, value string, ttl time.Duration) (bool, error) {
+	return s.rdb.SetNX(context.Background(), verificationKeyPrefix+verificationCodeKey(purpose, key), value, ttl).Result()
+}
+
+func (s *redisVerificationStore) Get(purpose string, key string) (string, error) {
+	value, err := s.rdb.Get(context.Background(), verificationKeyPrefix+verificationCodeKey(purpose, key)).Result()
+	if err == redis.Nil {
+		return "", nil
+	}
+	return value, err
+}
+
+func (s *redisVerificationStore) Take(purpose string, key string) (string, error) {
+	ctx := context.Background()
+	k := verificationKeyPrefix + verificationCodeKey(purpose, key)
+	var get *redis.StringCmd
+	_, err := s.rdb.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		get = pipe.Get(ctx, k)
+		pipe.Del(ctx, k)
+		return nil
+	})
+	if err != nil && err != redis.Nil {
+		return "", err
+	}
+	value, err := get.Result()
+	if err == redis.Nil {
+		return "", nil
+	}
+	return value, err
+}
+
+func (s *redisVerificationStore) Delete(purpose string, key string) error {
+	return s.rdb.Del(context.Background(), verificationKeyPrefix+verificationCodeKey(purpose, key)).Err()
+}
+
+// incrWithExpiryScript increments KEYS[1] and sets its expiry to ARGV[1] milliseconds if it has none,
+// in one step, so a counter can't be left without expiry
+var incrWithExpiryScript = redis.NewScript(`
+local count = redis.call("INCR", KEYS[1])
+if redis.call("PTTL", KEYS[1]) < 0 then
+	redis.call("PEXPIRE", KEYS[1], ARGV[1])
+end
+return count
+`)
+
+func (s *redisVerificationStore) IncrFailures(purpose string, subject string, window time.Duration) (int, error) {
+	k := verificationKeyPrefix + verificationFailureKey(purpose, subject)
+	count, err := incrWithExpiryScript.Run(context.Background(), s.rdb, []string{k}, window.Milliseconds()).Int()
+	if err != nil {
+		return 0, err
+	}
+	return count, nil
+}
+
+// decrIfPositiveScript decrements KEYS[1] only if it exists and is positive, so it can't be left without expiry
+var decrIfPositiveScript = redis.NewScript(`
+local count = tonumber(redis.call("GET", KEYS[1]))
+if count and count > 0 then
+	return redis.call("DECR", KEYS[1])
+end
+return 0
+`)
+
+func (s *redisVerificationStore) DecrFailures(purpose string, subject string) error {
+	k := verificationKeyPrefix + verificationFailureKey(purpose, subject)
+	return decrIfPositiveScript.Run(context.Background(), s.rdb, []string{k}).Err()
+}
+
+func (s *redisVerificationStore) GetFailures(purpose string, subject string) (int, error) {
+	count, err := s.rdb.Get(context.Background(), verificationKeyPrefix+verificationFailureKey(purpose, subject)).Int()
+	if err == redis.Nil {
+		return 0, nil
+	}
+	return count, err
+}
+
+func (s *redisVerificationStore) ResetFailures(purpose string, subject string) error {
+	return s.rdb.Del(context.Background(), verificationKeyPrefix+verificationFailureKey(purpose, subject)).Err()
+}
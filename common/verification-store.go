@@ -0,0 +1,179 @@
+package common
+
+import (
+	"sync"
+	"time"
+)
+
+// VerificationStore keeps verification codes and failed attempt counters, keys are namespaced by purpose.
+type VerificationStore interface {
+	// Set saves the value, replacing the existing one
+	Set(purpose string, key string, value string, ttl time.Duration) error
+	// Add saves the value only if the key doesn't exist, false means the key is taken
+	Add(purpose string, key string, value string, ttl time.Duration) (bool, error)
+	// Get returns an empty string if the key doesn't exist or is expired
+	Get(purpose string, key string) (string, error)
+	// Take is Get and Delete in one step, so a value can be taken only once
+	Take(purpose string, key string) (string, error)
+	Delete(purpose string, key string) error
+	// IncrFailures counts a failed attempt of subject, the counter expires window after the first failure
+	IncrFailures(purpose string, subject string, window time.Duration) (int, error)
+	// DecrFailures takes back an attempt counted by IncrFailures, e.g. once it succeeded
+	DecrFailures(purpose string, subject string) error
+	GetFailures(purpose string, subject string) (int, error)
+	ResetFailures(purpose string, subject string) error
+}
+
+type memoryVerificationEntry struct {
+	value     string
+	count     int
+	expiredAt time.Time
+}
+
+type memoryVerificationStore struct {
+	entries   map[string]*memoryVerificationEntry
+	lastPrune time.Time
+	mutex     sync.Mutex
+}
+
+const memoryVerificationPruneInterval = time.Minute
+
+func newMemoryVerificationStore() *memoryVerificationStore {
+	return &memoryVerificationStore{
+		entries:   make(map[string]*memoryVerificationEntry),
+		lastPrune: time.Now(),
+	}
+}
+
+func verificationCodeKey(purpose string, key string) string {
+	return purpose + ":" + key
+}
+
+func verificationFailureKey(purpose string, subject string) string {
+	return "fail:" + purpose + ":" + subject
+}
+
+// no lock inside!
+func (s *memoryVerificationStore) get(key string) *memoryVerificationEntry {
+	entry, exists := s.entries[key]
+	if !exists {
+		return nil
+	}
+	if entry.expiredAt.Before(time.Now()) {
+		delete(s.entries, key)
+		return nil
+	}
+	return entry
+}
+
+// no lock inside!
+func (s *memoryVerificationStore) prune() {
+	now := time.Now()
+	if now.Sub(s.lastPrune) < memoryVerificationPruneInterval {
+		return
+	}
+	s.lastPrune = now
+	for key, entry := range s.entries {
+		if entry.expiredAt.Before(now) {
+			delete(s.entries, key)
+		}
+	}
+}
+
+func (s *memoryVerificationStore) Set(purpose string, key string, value string, ttl time.Duration) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.prune()
+	s.entries[verificationCodeKey(purpose, key)] = &memoryVerificationEntry{
+		value:     value,
+		expiredAt: time.Now().Add(ttl),
+	}
+	return nil
+}
+
+func (s *memoryVerificationStore) Add(purpose string, key string, value string, ttl time.Duration) (bool, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.prune()
+	k := verificationCodeKey(purpose, key)
+	if s.get(k) != nil {
+		return false, nil
+	}
+	s.entries[k] = &memoryVerificationEntry{
+		value:     value,
+		expiredAt: time.Now().Add(ttl),
+	}
+	return true, nil
+}
+
+func (s *memoryVerificationStore) Get(purpose string, key string) (string, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	entry := s.get(verificationCodeKey(purpose, key))
+	if entry == nil {
+		return "", nil
+	}
+	return entry.value, nil
+}
+
+func (s *memoryVerificationStore) Take(purpose string, key string) (string, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	k := verificationCodeKey(purpose, key)
+	entry := s.get(k)
+	if entry == nil {
+		return "", nil
+	}
+	delete(s.entries, k)
+	return entry.value, nil
+}
+
+func (s *memoryVerificationStore) Delete(purpose string, key string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	delete(s.entries, verificationCodeKey(purpose, key))
+	return nil
+}
+
+func (s *memoryVerificationStore) IncrFailures(purpose string, subject string, window time.Duration) (int, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.prune()
+	k := verificationFailureKey(purpose, subject)
+	entry := s.get(k)
+	if entry == nil {
+		entry = &memoryVerificationEntry{
+			expiredAt: time.Now().Add(window),
+		}
+		s.entries[k] = entry
+	}
+	entry.count++
+	return entry.count, nil
+}
+
+func (s *memoryVerificationStore) DecrFailures(purpose string, subject string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	entry := s.get(verificationFailureKey(purpose, subject))
+	if entry != nil && entry.count > 0 {
+		entry.count--
+	}
+	return nil
+}
+
+func (s *memoryVerificationStore) GetFailures(purpose string, subject string) (int, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	entry := s.get(verificationFailureKey(purpose, subject))
+	if entry == nil {
+		return 0, nil
+	}
+	return entry.count, nil
+}
+
+func (s *memoryVerificationStore) ResetFailures(purpose string, subject string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	delete(s.entries, verificationFailureKey(purpose, subject))
+	return nil
+}
@@ -1,30 +1,67 @@
 package common
 
 import (
+	"crypto/rand"
+	"crypto/subtle"
+	"errors"
 	"github.com/google/uuid"
-	"math"
-	"math/rand"
-	"strconv"
+	"math/big"
 	"strings"
-	"sync"
 	"time"
 )
 
-type verificationValue struct {
-	code string
-	time time.Time
-}
-
 const (
 	EmailVerificationPurpose  = "v"
 	PasswordResetPurpose      = "r"
 	WeChatVerificationPurpose = "w"
+	TwoFactorPurpose          = "2"
 )
 
-var verificationMutex sync.Mutex
-var verificationMap map[string]verificationValue
-var verificationMapMaxSize = 20
-var VerificationValidMinutes = 3
+type VerificationPolicy struct {
+	CodeLength   int // 0 means a full length token
+	ValidMinutes int
+}
+
+var EmailVerificationPolicy = VerificationPolicy{CodeLength: 6, ValidMinutes: 3}
+var PasswordResetPolicy = VerificationPolicy{CodeLength: 0, ValidMinutes: 3}
+var WeChatVerificationPolicy = VerificationPolicy{CodeLength: 6, ValidMinutes: 3}
+
+// Failed attempts are counted per purpose and subject (the email, or the API caller with and without the end user IP for WeChat codes),
+// the subject is locked out for VerificationLockoutMinutes after VerificationMaxAttempts failures.
+var VerificationMaxAttempts = 5
+var VerificationLockoutMinutes = 15
+
+// weChatCallerAttemptsFactor The failed attempts of a caller of /api/wechat/user are limited to
+// VerificationMaxAttempts times the factor, across all of its end users
+const weChatCallerAttemptsFactor = 20
+
+var ErrVerificationLocked = errors.New("尝试次数过多，请稍后再试")
+var ErrVerificationInvalid = errors.New("验证码错误或已过期")
+
+var verificationStore VerificationStore = newMemoryVerificationStore()
+
+// InitVerificationStore This function is called after InitRedisClient()
+func InitVerificationStore() {
+	if RedisEnabled {
+		verificationStore = newRedisVerificationStore(RDB)
+		SysLog("verification codes are stored in Redis")
+	}
+}
+
+func GetVerificationPolicy(purpose string) VerificationPolicy {
+	switch purpose {
+	case PasswordResetPurpose:
+		return PasswordResetPolicy
+	case WeChatVerificationPurpose:
+		return WeChatVerificationPolicy
+	default:
+		return EmailVerificationPolicy
+	}
+}
+
+func (p VerificationPolicy) ttl() time.Duration {
+	return time.Duration(p.ValidMinutes) * time.Minute
+}
 
 func GenerateVerificationCode(length int) string {
 	code := uuid.New().String()
@@ -36,78 +73,157 @@ func GenerateVerificationCode(length int) string {
 }
 
 func GenerateAllNumberVerificationCode(length int) string {
-	min := math.Pow10(length - 1)
-	max := math.Pow10(length) - 1
-	code := strconv.Itoa(rand.Intn(int(max-min)) + int(min))
-	if GetWeChatIDByCode(code) != "" {
-		SysError("repeated verification code detected")
-		return GenerateAllNumberVerificationCode(length + 1)
+	digits := make([]byte, length)
+	for i := range digits {
+		n, err := rand.Int(rand.Reader, big.NewInt(10))
+		if err != nil {
+			panic(err)
+		}
+		digits[i] = byte('0' + n.Int64())
 	}
-	return code
+	return string(digits)
 }
 
-func RegisterWeChatCodeAndID(code string, id string) {
-	RegisterVerificationCodeWithKey(code, id, WeChatVerificationPurpose)
+// GenerateVerificationCodeFor generates a code with the length configured for the purpose
+func GenerateVerificationCodeFor(purpose string) string {
+	policy := GetVerificationPolicy(purpose)
+	if purpose == WeChatVerificationPurpose {
+		return GenerateAllNumberVerificationCode(policy.CodeLength)
+	}
+	return GenerateVerificationCode(policy.CodeLength)
 }
 
-func GetWeChatIDByCode(code string) string {
-	verificationMutex.Lock()
-	defer verificationMutex.Unlock()
-	value, okay := verificationMap[WeChatVerificationPurpose+code]
-	if okay {
-		delete(verificationMap, WeChatVerificationPurpose+code)
+// reserveVerificationAttempt counts the attempt before the code is checked, so concurrent attempts can't exceed the limit.
+// It returns the number of the attempt, and false if the subject is locked out.
+func reserveVerificationAttempt(purpose string, subject string, maxAttempts int) (int, bool) {
+	if maxAttempts <= 0 {
+		return 0, true
 	}
-	now := time.Now()
-	if !okay || int(now.Sub(value.time).Seconds()) >= VerificationValidMinutes*60 {
-		return ""
+	count, err := verificationStore.IncrFailures(purpose, subject, time.Duration(VerificationLockoutMinutes)*time.Minute)
+	if err != nil {
+		SysError("failed to record verification attempt: " + err.Error())
+		return 0, true
 	}
-	return value.code
+	return count, count <= maxAttempts
 }
 
-func RegisterVerificationCodeWithKey(key string, code string, purpose string) {
-	verificationMutex.Lock()
-	defer verificationMutex.Unlock()
-	verificationMap[purpose+key] = verificationValue{
-		code: code,
-		time: time.Now(),
+// releaseVerificationAttempt takes back an attempt which succeeded, without resetting the failures of the subject
+func releaseVerificationAttempt(purpose string, subject string) {
+	if VerificationMaxAttempts <= 0 {
+		return
 	}
-	if len(verificationMap) > verificationMapMaxSize {
-		removeExpiredPairs()
+	if err := verificationStore.DecrFailures(purpose, subject); err != nil {
+		SysError("failed to release verification attempt: " + err.Error())
 	}
 }
 
-func VerifyCodeWithKey(key string, code string, purpose string) bool {
-	verificationMutex.Lock()
-	defer verificationMutex.Unlock()
-	value, okay := verificationMap[purpose+key]
-	now := time.Now()
-	if okay {
-		delete(verificationMap, purpose+key)
+func resetVerificationFailures(purpose string, subject string) {
+	if err := verificationStore.ResetFailures(purpose, subject); err != nil {
+		SysError("failed to reset verification failures: " + err.Error())
 	}
-	if !okay || int(now.Sub(value.time).Seconds()) >= VerificationValidMinutes*60 {
-		return false
+}
+
+// IssueWeChatVerificationCode generates a unique code which resolves to the WeChat user
+func IssueWeChatVerificationCode(wechatID string) (string, error) {
+	policy := WeChatVerificationPolicy
+	length := policy.CodeLength
+	for i := 0; i < 3; i++ {
+		code := GenerateAllNumberVerificationCode(length)
+		ok, err := verificationStore.Add(WeChatVerificationPurpose, code, wechatID, policy.ttl())
+		if err != nil {
+			return "", err
+		}
+		if ok {
+			recordVerificationCodeIssued(WeChatVerificationPurpose)
+			return code, nil
+		}
+		SysError("repeated verification code detected")
+		length++
 	}
-	return code == value.code
+	return "", errors.New("failed to generate a unique verification code")
 }
 
-func DeleteKey(key string, purpose string) {
-	verificationMutex.Lock()
-	defer verificationMutex.Unlock()
-	delete(verificationMap, purpose+key)
+// GetWeChatIDByCode The code can be used only once, an empty id means the code is invalid or expired.
+// Failed attempts are limited per caller and end user, and with a higher limit per caller,
+// since the end user is reported by the caller.
+func GetWeChatIDByCode(code string, caller string, endUser string) (string, error) {
+	subject := caller + "@" + endUser
+	if _, ok := reserveVerificationAttempt(WeChatVerificationPurpose, subject, VerificationMaxAttempts); !ok {
+		return "", ErrVerificationLocked
+	}
+	if _, ok := reserveVerificationAttempt(WeChatVerificationPurpose, caller, VerificationMaxAttempts*weChatCallerAttemptsFactor); !ok {
+		releaseVerificationAttempt(WeChatVerificationPurpose, subject)
+		return "", ErrVerificationLocked
+	}
+	id, err := verificationStore.Take(WeChatVerificationPurpose, code)
+	if err != nil {
+		return "", err
+	}
+	if id != "" {
+		// The codes of other users resolved by the same caller don't reset its failures
+		releaseVerificationAttempt(WeChatVerificationPurpose, subject)
+		releaseVerificationAttempt(WeChatVerificationPurpose, caller)
+		recordVerificationCodeConsumed(WeChatVerificationPurpose)
+	}
+	return id, nil
+}
+
+func RegisterVerificationCodeWithKey(key string, code string, purpose string) {
+	err := verificationStore.Set(purpose, key, code, GetVerificationPolicy(purpose).ttl())
+	if err != nil {
+		SysError("failed to save verification code: " + err.Error())
+		return
+	}
+	recordVerificationCodeIssued(purpose)
 }
 
-// no lock inside!
-func removeExpiredPairs() {
-	now := time.Now()
-	for key := range verificationMap {
-		if int(now.Sub(verificationMap[key].time).Seconds()) >= VerificationValidMinutes*60 {
-			delete(verificationMap, key)
+// CheckCodeWithKey The code is removed once verified, or when the key gets locked out.
+func CheckCodeWithKey(key string, code string, purpose string) error {
+	attempt, ok := reserveVerificationAttempt(purpose, key, VerificationMaxAttempts)
+	if !ok {
+		return ErrVerificationLocked
+	}
+	value, err := verificationStore.Get(purpose, key)
+	if err != nil {
+		SysError("failed to get verification code: " + err.Error())
+		return ErrVerificationInvalid
+	}
+	if value != "" && subtle.ConstantTimeCompare([]byte(value), []byte(code)) == 1 {
+		DeleteKey(key, purpose)
+		resetVerificationFailures(purpose, key)
+		recordVerificationCodeConsumed(purpose)
+		return nil
+	}
+	if VerificationMaxAttempts > 0 && attempt >= VerificationMaxAttempts {
+		DeleteKey(key, purpose)
+		return ErrVerificationLocked
+	}
+	return ErrVerificationInvalid
+}
+
+// CheckWithAttemptLimit runs check unless the subject is locked out, every check counts towards the lockout until one succeeds.
+// It's meant for codes which aren't kept in the verification store, e.g. TOTP codes.
+func CheckWithAttemptLimit(purpose string, subject string, check func() error) error {
+	attempt, ok := reserveVerificationAttempt(purpose, subject, VerificationMaxAttempts)
+	if !ok {
+		return ErrVerificationLocked
+	}
+	if err := check(); err != nil {
+		if VerificationMaxAttempts > 0 && attempt >= VerificationMaxAttempts {
+			return ErrVerificationLocked
 		}
+		return err
 	}
+	resetVerificationFailures(purpose, subject)
+	return nil
 }
 
-func init() {
-	verificationMutex.Lock()
-	defer verificationMutex.Unlock()
-	verificationMap = make(map[string]verificationValue)
+func VerifyCodeWithKey(key string, code string, purpose string) bool {
+	return CheckCodeWithKey(key, code, purpose) == nil
+}
+
+func DeleteKey(key string, purpose string) {
+	if err := verificationStore.Delete(purpose, key); err != nil {
+		SysError("failed to delete verification code: " + err.Error())
+	}
 }
@@ -0,0 +1,56 @@
+package common
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+type weChatCustomMessageResponse struct {
+	ErrorCode    int    `json:"errcode"`
+	ErrorMessage string `json:"errmsg"`
+}
+
+// SendWeChatTextMessage sends a customer service message, WeChat only delivers it
+// if the user interacted with the official account in the last 48 hours.
+func SendWeChatTextMessage(openID string, content string) error {
+	// https://developers.weixin.qq.com/doc/offiaccount/Message_Management/Service_Center_messages.html
+	accessToken := GetAccessToken()
+	if accessToken == "" {
+		return errors.New("获取微信访问令牌失败")
+	}
+	message := map[string]interface{}{
+		"touser":  openID,
+		"msgtype": "text",
+		"text": map[string]string{
+			"content": content,
+		},
+	}
+	data, err := json.Marshal(message)
+	if err != nil {
+		return err
+	}
+	client := http.Client{
+		Timeout: 5 * time.Second,
+	}
+	res, err := client.Post(fmt.Sprintf("https://api.weixin.qq.com/cgi-bin/message/custom/send?access_token=%s", accessToken),
+		"application/json", bytes.NewBuffer(data))
+	if err != nil {
+		RecordWeChatAPICall("cgi-bin/message/custom/send", 0, err)
+		return err
+	}
+	defer res.Body.Close()
+	var result weChatCustomMessageResponse
+	err = json.NewDecoder(res.Body).Decode(&result)
+	RecordWeChatAPICall("cgi-bin/message/custom/send", result.ErrorCode, err)
+	if err != nil {
+		return err
+	}
+	if result.ErrorCode != 0 {
+		return fmt.Errorf("发送微信消息失败: %d %s", result.ErrorCode, result.ErrorMessage)
+	}
+	return nil
+}
@@ -3,6 +3,7 @@ package common
 import (
 	"encoding/xml"
 	"fmt"
+	"net/url"
 	"strings"
 )
 
@@ -43,6 +44,10 @@ func ProcessWeChatMessage(req *WeChatMessageRequest, res *WeChatMessageResponse)
 		switch req.Content {
 		case "验证码":
 			handleVerificationCode(req, res)
+		case "确认":
+			handleLoginConfirmReply(req, res, true)
+		case "拒绝":
+			handleLoginConfirmReply(req, res, false)
 		default:
 			res.Content = "发送「验证码」获取登录验证码"
 		}
@@ -81,8 +86,22 @@ func handleQRCodeScanEvent(req *WeChatMessageRequest, res *WeChatMessageResponse
 		OpenID: req.FromUserName,
 	}
 
-	success := GetSessionManager().UpdateSessionByScene(sceneID, req.FromUserName, userInfo)
-	if success {
+	if WeChatLoginConfirmEnabled {
+		scanned := GetSessionManager().MarkSessionScanned(sceneID, req.FromUserName, userInfo)
+		if scanned == nil {
+			SysLog(fmt.Sprintf("Failed to update login session: scene=%s", sceneID))
+			res.Content = "登录失败，请重新扫码"
+			return
+		}
+		res.Content = buildLoginConfirmMessage(scanned)
+		if req.Event == "subscribe" {
+			res.Content = "欢迎关注！" + res.Content
+		}
+		return
+	}
+
+	updated := GetSessionManager().UpdateSessionByScene(sceneID, req.FromUserName, userInfo)
+	if updated != nil {
 		SysLog(fmt.Sprintf("Successfully updated login session: scene=%s, wechat=%s",
 			sceneID, req.FromUserName))
 	} else {
@@ -91,9 +110,54 @@ func handleQRCodeScanEvent(req *WeChatMessageRequest, res *WeChatMessageResponse
 	}
 }
 
+func buildLoginConfirmMessage(session *LoginSession) string {
+	clientIP := session.ClientIP
+	if clientIP == "" {
+		clientIP = "未知"
+	}
+	content := fmt.Sprintf("您正在登录「%s」\nIP：%s\n", session.AppName, clientIP)
+	// The server doesn't resolve locations, it's only shown if the caller passed it
+	if session.Location != "" {
+		content += fmt.Sprintf("位置（由应用提供）：%s\n", session.Location)
+	}
+	content += fmt.Sprintf("时间：%s\n\n如非本人操作，请拒绝并注意二维码来源。\n", session.CreatedAt.Format("2006-01-02 15:04:05"))
+	if ServerAddress != "" {
+		confirmURL := fmt.Sprintf("%s/api/wechat/login/confirm?scene=%s&ticket=%s",
+			strings.TrimSuffix(ServerAddress, "/"), url.QueryEscape(session.SceneID), url.QueryEscape(session.ConfirmTicket))
+		content += fmt.Sprintf("<a href=\"%s\">点击此处确认或拒绝登录</a>，", confirmURL)
+	}
+	return content + "或回复「确认」/「拒绝」"
+}
+
+func handleLoginConfirmReply(req *WeChatMessageRequest, res *WeChatMessageResponse, confirm bool) {
+	manager := GetSessionManager()
+	session := manager.GetPendingConfirmation(req.FromUserName)
+	if session == nil {
+		res.Content = "没有待确认的登录请求，请重新扫码"
+		return
+	}
+	if confirm {
+		if manager.ConfirmSession(session.LoginToken, req.FromUserName) == nil {
+			res.Content = "登录失败，请重新扫码"
+			return
+		}
+		res.Content = "登录成功，请返回网页继续操作"
+		return
+	}
+	if manager.RejectSession(session.LoginToken, req.FromUserName) == nil {
+		res.Content = "操作失败，请重新扫码"
+		return
+	}
+	res.Content = "已拒绝本次登录"
+}
+
 func handleVerificationCode(req *WeChatMessageRequest, res *WeChatMessageResponse) {
-	code := GenerateAllNumberVerificationCode(6)
-	RegisterWeChatCodeAndID(code, req.FromUserName)
+	code, err := IssueWeChatVerificationCode(req.FromUserName)
+	if err != nil {
+		SysError("failed to issue verification code: " + err.Error())
+		res.Content = "获取验证码失败，请稍后重试"
+		return
+	}
 	res.Content = code
 	SysLog(fmt.Sprintf("Generated verification code: %s for user: %s", code, req.FromUserName))
 }
@@ -0,0 +1,100 @@
+package common
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+const (
+	WeChatOAuthScopeBase     = "snsapi_base"
+	WeChatOAuthScopeUserInfo = "snsapi_userinfo"
+)
+
+type WeChatOAuthToken struct {
+	AccessToken  string `json:"access_token"`
+	ExpiresIn    int    `json:"expires_in"`
+	RefreshToken string `json:"refresh_token"`
+	OpenID       string `json:"openid"`
+	Scope        string `json:"scope"`
+	UnionID      string `json:"unionid"`
+	ErrorCode    int    `json:"errcode"`
+	ErrorMessage string `json:"errmsg"`
+}
+
+type weChatOAuthUserInfoResponse struct {
+	OpenID       string `json:"openid"`
+	Nickname     string `json:"nickname"`
+	HeadImgURL   string `json:"headimgurl"`
+	UnionID      string `json:"unionid"`
+	ErrorCode    int    `json:"errcode"`
+	ErrorMessage string `json:"errmsg"`
+}
+
+func IsValidWeChatOAuthScope(scope string) bool {
+	return scope == WeChatOAuthScopeBase || scope == WeChatOAuthScopeUserInfo
+}
+
+// BuildWeChatOAuthURL 构建网页授权跳转地址，state 使用登录会话的 scene_id
+func BuildWeChatOAuthURL(redirectURI string, scope string, state string) string {
+	// https://developers.weixin.qq.com/doc/offiaccount/OA_Web_Apps/Wechat_webpage_authorization.html
+	return fmt.Sprintf("https://open.weixin.qq.com/connect/oauth2/authorize?appid=%s&redirect_uri=%s&response_type=code&scope=%s&state=%s#wechat_redirect",
+		WeChatAppID, url.QueryEscape(redirectURI), scope, url.QueryEscape(state))
+}
+
+func ExchangeWeChatOAuthCode(code string) (*WeChatOAuthToken, error) {
+	client := http.Client{
+		Timeout: 5 * time.Second,
+	}
+	res, err := client.Get(fmt.Sprintf("https://api.weixin.qq.com/sns/oauth2/access_token?appid=%s&secret=%s&code=%s&grant_type=authorization_code",
+		WeChatAppID, WeChatAppSecret, url.QueryEscape(code)))
+	if err != nil {
+		RecordWeChatAPICall("sns/oauth2/access_token", 0, err)
+		return nil, err
+	}
+	defer res.Body.Close()
+	var token WeChatOAuthToken
+	err = json.NewDecoder(res.Body).Decode(&token)
+	RecordWeChatAPICall("sns/oauth2/access_token", token.ErrorCode, err)
+	if err != nil {
+		return nil, err
+	}
+	if token.ErrorCode != 0 || token.OpenID == "" {
+		return nil, fmt.Errorf("微信网页授权失败: %d %s", token.ErrorCode, token.ErrorMessage)
+	}
+	return &token, nil
+}
+
+func GetWeChatOAuthUserInfo(token *WeChatOAuthToken) (*WeChatUserInfo, error) {
+	if token == nil || token.AccessToken == "" {
+		return nil, errors.New("无效的网页授权令牌")
+	}
+	client := http.Client{
+		Timeout: 5 * time.Second,
+	}
+	res, err := client.Get(fmt.Sprintf("https://api.weixin.qq.com/sns/userinfo?access_token=%s&openid=%s&lang=zh_CN",
+		url.QueryEscape(token.AccessToken), url.QueryEscape(token.OpenID)))
+	if err != nil {
+		RecordWeChatAPICall("sns/userinfo", 0, err)
+		return nil, err
+	}
+	defer res.Body.Close()
+	var info weChatOAuthUserInfoResponse
+	err = json.NewDecoder(res.Body).Decode(&info)
+	RecordWeChatAPICall("sns/userinfo", info.ErrorCode, err)
+	if err != nil {
+		return nil, err
+	}
+	if info.ErrorCode != 0 {
+		return nil, fmt.Errorf("获取微信用户信息失败: %d %s", info.ErrorCode, info.ErrorMessage)
+	}
+	return &WeChatUserInfo{
+		OpenID:     token.OpenID,
+		UnionID:    info.UnionID,
+		Nickname:   info.Nickname,
+		HeadImgURL: info.HeadImgURL,
+	}, nil
+}
@@ -0,0 +1,129 @@
+package controller
+
+import (
+	"encoding/json"
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+	"wechat-server/common"
+	"wechat-server/model"
+)
+
+type CreateAPITokenRequest struct {
+	Name        string `json:"name"`
+	Scopes      string `json:"scopes"`
+	ExpiredTime int64  `json:"expired_time"` // unix timestamp, 0 means never
+}
+
+func validateTokenScopes(scopes string) (string, bool) {
+	var valid []string
+	for _, scope := range strings.Fields(scopes) {
+		known := false
+		for _, s := range common.TokenScopes {
+			if s == scope {
+				known = true
+				break
+			}
+		}
+		if !known {
+			return "", false
+		}
+		valid = append(valid, scope)
+	}
+	return strings.Join(valid, " "), true
+}
+
+func GetSelfAPITokens(c *gin.Context) {
+	tokens, err := model.GetUserAPITokens(c.GetInt("id"))
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data":    tokens,
+	})
+	return
+}
+
+// CreateSelfAPIToken The token is only returned here, we only store its hash
+func CreateSelfAPIToken(c *gin.Context) {
+	var req CreateAPITokenRequest
+	err := json.NewDecoder(c.Request.Body).Decode(&req)
+	if err != nil || req.Name == "" || len(req.Name) > 64 {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": "无效的参数",
+		})
+		return
+	}
+	scopes, ok := validateTokenScopes(req.Scopes)
+	if !ok {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": "无效的权限范围，可选：" + strings.Join(common.TokenScopes, " "),
+		})
+		return
+	}
+	if req.ExpiredTime != 0 && req.ExpiredTime <= time.Now().Unix() {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": "过期时间必须晚于当前时间",
+		})
+		return
+	}
+	key := common.GenerateVerificationCode(0)
+	token := model.APIToken{
+		UserId:      c.GetInt("id"),
+		Name:        req.Name,
+		Scopes:      scopes,
+		ExpiredTime: req.ExpiredTime,
+	}
+	if err := token.Insert(key); err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	recordAudit(c, "token.create", "token", token.Id, nil, map[string]interface{}{"name": token.Name, "scopes": token.Scopes, "expired_time": token.ExpiredTime})
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data": gin.H{
+			"token": token,
+			"key":   key,
+		},
+	})
+	return
+}
+
+func DeleteSelfAPIToken(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	if err := model.DeleteUserAPIToken(c.GetInt("id"), id); err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	recordAudit(c, "token.delete", "token", id, nil, nil)
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+	})
+	return
+}
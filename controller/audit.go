@@ -0,0 +1,153 @@
+package controller
+
+import (
+	"encoding/csv"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+	"wechat-server/common"
+	"wechat-server/model"
+)
+
+const auditExportLimit = 10000
+
+// recordAudit records an action of the current user
+func recordAudit(c *gin.Context, action string, targetType string, targetId interface{}, before map[string]interface{}, after map[string]interface{}) {
+	recordAuditAs(c, c.GetInt("id"), c.GetString("username"), action, targetType, targetId, before, after)
+}
+
+// recordAuditAs records an action of an actor which isn't authenticated yet, e.g. on login
+func recordAuditAs(c *gin.Context, actorId int, actorName string, action string, targetType string, targetId interface{}, before map[string]interface{}, after map[string]interface{}) {
+	recordAuditDiffAs(c, actorId, actorName, action, targetType, targetId, model.AuditDiff(before, after))
+}
+
+// recordAuditDiff records an action of the current user with a diff built by the caller, e.g. by model.AuditOptionDiff
+func recordAuditDiff(c *gin.Context, action string, targetType string, targetId interface{}, diff string) {
+	recordAuditDiffAs(c, c.GetInt("id"), c.GetString("username"), action, targetType, targetId, diff)
+}
+
+func recordAuditDiffAs(c *gin.Context, actorId int, actorName string, action string, targetType string, targetId interface{}, diff string) {
+	target := ""
+	if targetId != nil {
+		target = fmt.Sprint(targetId)
+	}
+	model.RecordAuditEvent(&model.AuditEvent{
+		ActorId:    actorId,
+		ActorName:  actorName,
+		Action:     action,
+		TargetType: targetType,
+		TargetId:   target,
+		Diff:       diff,
+		IP:         c.ClientIP(),
+		UserAgent:  c.Request.UserAgent(),
+	})
+}
+
+// auditUserFields returns the audited fields of a user, the password is only recorded as changed
+func auditUserFields(user *model.User) map[string]interface{} {
+	return map[string]interface{}{
+		"username":     user.Username,
+		"display_name": user.DisplayName,
+		"email":        user.Email,
+		"role":         user.Role,
+		"status":       user.Status,
+		"password":     model.AuditSecret(user.Password),
+	}
+}
+
+// recordUserUpdate records the changes of a user, before is the user loaded with all fields before the update
+func recordUserUpdate(c *gin.Context, action string, before *model.User) {
+	after, err := model.GetUserById(before.Id, true)
+	if err != nil {
+		return
+	}
+	recordAudit(c, action, "user", before.Id, auditUserFields(before), auditUserFields(after))
+}
+
+func auditClientFields(client *model.Client) map[string]interface{} {
+	return map[string]interface{}{
+		"name":          client.Name,
+		"redirect_uris": client.RedirectURIs,
+		"scopes":        client.Scopes,
+		"allowed_ips":   client.AllowedIPs,
+		"status":        client.Status,
+	}
+}
+
+func parseAuditEventFilter(c *gin.Context) *model.AuditEventFilter {
+	filter := &model.AuditEventFilter{
+		ActorName:  c.Query("actor_name"),
+		Action:     c.Query("action"),
+		TargetType: c.Query("target_type"),
+		TargetId:   c.Query("target_id"),
+		IP:         c.Query("ip"),
+	}
+	filter.ActorId, _ = strconv.Atoi(c.Query("actor_id"))
+	filter.StartTime, _ = strconv.ParseInt(c.Query("start_time"), 10, 64)
+	filter.EndTime, _ = strconv.ParseInt(c.Query("end_time"), 10, 64)
+	return filter
+}
+
+// csvSafe prevents user supplied values from being evaluated as formulas by spreadsheets
+func csvSafe(value string) string {
+	if value != "" && strings.ContainsRune("=+-@\t\r", rune(value[0])) {
+		return "'" + value
+	}
+	return value
+}
+
+func GetAuditEvents(c *gin.Context) {
+	p, _ := strconv.Atoi(c.Query("p"))
+	if p < 0 {
+		p = 0
+	}
+	events, err := model.QueryAuditEvents(parseAuditEventFilter(c), p*common.ItemsPerPage, common.ItemsPerPage)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data":    events,
+	})
+	return
+}
+
+// ExportAuditEvents exports at most auditExportLimit events matching the filters as CSV
+func ExportAuditEvents(c *gin.Context) {
+	events, err := model.QueryAuditEvents(parseAuditEventFilter(c), 0, auditExportLimit)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	filename := fmt.Sprintf("audit-%s.csv", time.Now().Format("20060102150405"))
+	c.Header("Content-Type", "text/csv; charset=utf-8")
+	c.Header("Content-Disposition", "attachment; filename="+filename)
+	writer := csv.NewWriter(c.Writer)
+	_ = writer.Write([]string{"id", "time", "actor_id", "actor_name", "action", "target_type", "target_id", "diff", "ip", "user_agent"})
+	for _, event := range events {
+		_ = writer.Write([]string{
+			strconv.Itoa(event.Id),
+			time.Unix(event.CreatedTime, 0).Format(time.RFC3339),
+			strconv.Itoa(event.ActorId),
+			csvSafe(event.ActorName),
+			event.Action,
+			event.TargetType,
+			csvSafe(event.TargetId),
+			csvSafe(event.Diff),
+			event.IP,
+			csvSafe(event.UserAgent),
+		})
+	}
+	writer.Flush()
+}
@@ -0,0 +1,299 @@
+package controller
+
+import (
+	"encoding/json"
+	"github.com/gin-gonic/gin"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+	"wechat-server/common"
+	"wechat-server/model"
+)
+
+func validateRedirectURIs(redirectURIs string) (string, bool) {
+	var uris []string
+	for _, uri := range strings.Split(redirectURIs, "\n") {
+		uri = strings.TrimSpace(uri)
+		if uri == "" {
+			continue
+		}
+		if !isValidRedirectURI(uri) {
+			return "", false
+		}
+		uris = append(uris, uri)
+	}
+	return strings.Join(uris, "\n"), true
+}
+
+func validateClientScopes(scopes string) (string, bool) {
+	var valid []string
+	for _, scope := range strings.Fields(scopes) {
+		known := false
+		for _, s := range common.ClientScopes {
+			if s == scope {
+				known = true
+				break
+			}
+		}
+		if !known {
+			return "", false
+		}
+		valid = append(valid, scope)
+	}
+	return strings.Join(valid, " "), true
+}
+
+func validateAllowedIPs(allowedIPs string) (string, bool) {
+	var ips []string
+	for _, ip := range strings.Split(allowedIPs, "\n") {
+		ip = strings.TrimSpace(ip)
+		if ip == "" {
+			continue
+		}
+		if _, _, err := net.ParseCIDR(ip); err != nil && net.ParseIP(ip) == nil {
+			return "", false
+		}
+		ips = append(ips, ip)
+	}
+	return strings.Join(ips, "\n"), true
+}
+
+// validateClient checks the user supplied fields of client and normalizes them in place
+func validateClient(client *model.Client) string {
+	var ok bool
+	if client.RedirectURIs, ok = validateRedirectURIs(client.RedirectURIs); !ok {
+		return "回调地址必须为合法的 http(s) 地址，每行一个"
+	}
+	if client.Scopes, ok = validateClientScopes(client.Scopes); !ok {
+		return "无效的权限范围，可选：" + strings.Join(common.ClientScopes, " ")
+	}
+	if client.AllowedIPs, ok = validateAllowedIPs(client.AllowedIPs); !ok {
+		return "IP 白名单必须为合法的 IP 或 CIDR，每行一个"
+	}
+	return ""
+}
+
+func GetAllClients(c *gin.Context) {
+	clients, err := model.GetAllClients()
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data":    clients,
+	})
+	return
+}
+
+func GetClient(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	client, err := model.GetClientById(id)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data":    client,
+	})
+	return
+}
+
+// CreateClient The client secret is only returned here, we only store its hash
+func CreateClient(c *gin.Context) {
+	var client model.Client
+	err := json.NewDecoder(c.Request.Body).Decode(&client)
+	if err != nil || client.Name == "" {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": "无效的参数",
+		})
+		return
+	}
+	if message := validateClient(&client); message != "" {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": message,
+		})
+		return
+	}
+	secret := common.GenerateVerificationCode(0)
+	cleanClient := model.Client{
+		ClientId:     common.GenerateVerificationCode(16),
+		Name:         client.Name,
+		Secret:       common.Secret2Hash(secret),
+		RedirectURIs: client.RedirectURIs,
+		Scopes:       client.Scopes,
+		AllowedIPs:   client.AllowedIPs,
+		Status:       common.ClientStatusEnabled,
+		CreatedTime:  time.Now().Unix(),
+	}
+	if err := cleanClient.Insert(); err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	recordAudit(c, "client.create", "client", cleanClient.ClientId, nil, auditClientFields(&cleanClient))
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data": gin.H{
+			"client":        cleanClient,
+			"client_secret": secret,
+		},
+	})
+	return
+}
+
+// UpdateClientRequest Fields not in the request are left unchanged
+type UpdateClientRequest struct {
+	Id           int     `json:"id"`
+	Name         string  `json:"name"`
+	RedirectURIs *string `json:"redirect_uris"`
+	Scopes       *string `json:"scopes"`
+	AllowedIPs   *string `json:"allowed_ips"`
+	Status       int     `json:"status"`
+}
+
+func UpdateClient(c *gin.Context) {
+	var req UpdateClientRequest
+	err := json.NewDecoder(c.Request.Body).Decode(&req)
+	if err != nil || req.Id == 0 {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": "无效的参数",
+		})
+		return
+	}
+	client, err := model.GetClientById(req.Id)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	before := auditClientFields(client)
+	updatedClient := *client
+	if req.Name != "" {
+		updatedClient.Name = req.Name
+	}
+	if req.Status == common.ClientStatusEnabled || req.Status == common.ClientStatusDisabled {
+		updatedClient.Status = req.Status
+	}
+	if req.RedirectURIs != nil {
+		updatedClient.RedirectURIs = *req.RedirectURIs
+	}
+	if req.Scopes != nil {
+		updatedClient.Scopes = *req.Scopes
+	}
+	if req.AllowedIPs != nil {
+		updatedClient.AllowedIPs = *req.AllowedIPs
+	}
+	if message := validateClient(&updatedClient); message != "" {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": message,
+		})
+		return
+	}
+	if err := updatedClient.Update(); err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	recordAudit(c, "client.update", "client", client.ClientId, before, auditClientFields(&updatedClient))
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+	})
+	return
+}
+
+func ResetClientSecret(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	client, err := model.GetClientById(id)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	secret := common.GenerateVerificationCode(0)
+	if err := client.UpdateSecret(secret); err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	recordAudit(c, "client.reset_secret", "client", client.ClientId, nil, nil)
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data":    secret,
+	})
+	return
+}
+
+func DeleteClient(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	client, err := model.GetClientById(id)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	if err := client.Delete(); err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	recordAudit(c, "client.delete", "client", client.ClientId, auditClientFields(client), nil)
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+	})
+	return
+}
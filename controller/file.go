@@ -125,7 +125,7 @@ func DownloadFile(c *gin.Context) {
 	}
 	c.File(fullPath)
 	// Update download counter
-	go func() {
+	common.GoAsync(func() {
 		model.UpdateDownloadCounter(path)
-	}()
+	})
 }
@@ -0,0 +1,88 @@
+package controller
+
+import (
+	"context"
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"time"
+	"wechat-server/common"
+	"wechat-server/model"
+)
+
+const (
+	healthStatusOK        = "ok"
+	healthStatusError     = "error"
+	healthStatusDisabled  = "disabled"
+	readinessTimeout      = 2 * time.Second
+	accessTokenNearExpiry = time.Minute
+)
+
+type ComponentStatus struct {
+	Status    string `json:"status"`
+	Error     string `json:"error,omitempty"`
+	ExpiresIn int64  `json:"expires_in,omitempty"`
+	ErrorTime int64  `json:"error_time,omitempty"`
+}
+
+func pingStatus(err error) ComponentStatus {
+	if err != nil {
+		return ComponentStatus{Status: healthStatusError, Error: err.Error()}
+	}
+	return ComponentStatus{Status: healthStatusOK}
+}
+
+// accessTokenComponentStatus the access token is only checked once the WeChat app is configured
+func accessTokenComponentStatus() ComponentStatus {
+	if common.WeChatAppID == "" || common.WeChatAppSecret == "" {
+		return ComponentStatus{Status: healthStatusDisabled}
+	}
+	tokenStatus := common.GetAccessTokenStatus()
+	status := ComponentStatus{Status: healthStatusOK, Error: tokenStatus.LastError}
+	if !tokenStatus.LastErrorTime.IsZero() {
+		status.ErrorTime = tokenStatus.LastErrorTime.Unix()
+	}
+	remaining := time.Until(tokenStatus.ExpiresAt)
+	if tokenStatus.Present {
+		status.ExpiresIn = int64(remaining.Seconds())
+	}
+	if !tokenStatus.Present || remaining < accessTokenNearExpiry {
+		status.Status = healthStatusError
+		if status.Error == "" {
+			status.Error = "access token is missing or about to expire"
+		}
+	}
+	return status
+}
+
+// Healthz only tells the process is alive, the dependencies are checked by Readyz
+func Healthz(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{
+		"status": healthStatusOK,
+	})
+}
+
+// Readyz returns 503 if any enabled component is unavailable, so the load balancer stops routing to this instance
+func Readyz(c *gin.Context) {
+	ctx, cancel := context.WithTimeout(c.Request.Context(), readinessTimeout)
+	defer cancel()
+	components := map[string]ComponentStatus{
+		"database":     pingStatus(model.PingDB(ctx)),
+		"redis":        {Status: healthStatusDisabled},
+		"access_token": accessTokenComponentStatus(),
+	}
+	if common.RedisEnabled {
+		components["redis"] = pingStatus(common.PingRedis(ctx))
+	}
+	status := healthStatusOK
+	httpStatus := http.StatusOK
+	for _, component := range components {
+		if component.Status == healthStatusError {
+			status = healthStatusError
+			httpStatus = http.StatusServiceUnavailable
+		}
+	}
+	c.JSON(httpStatus, gin.H{
+		"status":     status,
+		"components": components,
+	})
+}
@@ -0,0 +1,94 @@
+package controller
+
+import (
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"wechat-server/common"
+	"wechat-server/model"
+)
+
+func GetSelfIdentities(c *gin.Context) {
+	identities, err := model.GetUserIdentities(c.GetInt("id"))
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data":    identities,
+	})
+	return
+}
+
+func UnlinkSelfIdentity(c *gin.Context) {
+	provider := c.Param("provider")
+	if provider != common.IdentityProviderGitHub && provider != common.IdentityProviderWeChat &&
+		provider != common.IdentityProviderEmail {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": "无效的参数",
+		})
+		return
+	}
+	if err := model.UnlinkUserIdentity(c.GetInt("id"), provider); err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	recordAudit(c, "identity.unlink", "user", c.GetInt("id"), map[string]interface{}{"provider": provider}, nil)
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+	})
+	return
+}
+
+// EmailBind The code is sent by SendEmailVerification
+func EmailBind(c *gin.Context) {
+	email := c.Query("email")
+	code := c.Query("code")
+	if err := common.Validate.Var(email, "required,email"); err != nil || code == "" {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": "无效的参数",
+		})
+		return
+	}
+	if err := common.CheckCodeWithKey(email, code, common.EmailVerificationPurpose); err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	user := model.User{
+		Id: c.GetInt("id"),
+	}
+	if err := model.LinkUserIdentity(user.Id, common.IdentityProviderEmail, email, ""); err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	user.Email = model.NormalizeIdentitySubject(common.IdentityProviderEmail, email)
+	if err := user.Update(false); err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	recordAudit(c, "identity.link", "user", user.Id, nil, map[string]interface{}{"provider": common.IdentityProviderEmail, "subject": user.Email})
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+	})
+	return
+}
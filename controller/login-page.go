@@ -0,0 +1,147 @@
+package controller
+
+import (
+	"html/template"
+	"net/http"
+	"net/url"
+	"wechat-server/common"
+
+	"github.com/gin-gonic/gin"
+)
+
+// The hosted login page is rendered by the server so that it works without the web frontend,
+// it shows the QR code and polls StatusURL until the login session is finished.
+
+var loginPageTemplate = template.Must(template.New("login").Parse(`<!DOCTYPE html>
+<html lang="zh-CN">
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>{{.SystemName}} - 微信登录</title>
+<style>
+body { font-family: -apple-system, BlinkMacSystemFont, "Segoe UI", "PingFang SC", sans-serif; background: #f5f5f5; margin: 0; }
+.card { max-width: 360px; margin: 64px auto; background: #fff; border-radius: 8px; padding: 32px; text-align: center; box-shadow: 0 1px 4px rgba(0,0,0,.1); }
+.card img { width: 240px; height: 240px; }
+.hint { color: #666; margin-top: 16px; }
+.error { color: #d33; }
+</style>
+</head>
+<body>
+<div class="card">
+  <h2>{{.SystemName}}</h2>
+  {{if .ClientName}}<p>登录到 <strong>{{.ClientName}}</strong></p>{{end}}
+  {{if .Message}}
+  <p class="error">{{.Message}}</p>
+  {{else}}
+  <img src="{{.QRCodeURL}}" alt="微信登录二维码">
+  <p class="hint" id="hint">请使用微信扫描二维码登录</p>
+  {{end}}
+</div>
+{{if not .Message}}
+<script>
+(function () {
+  var statusURL = {{.StatusURL}};
+  var hint = document.getElementById("hint");
+  var messages = {
+    expired: "二维码已过期，请刷新页面重试",
+    rejected: "登录已被拒绝"
+  };
+  var hints = {
+    scanned: "已扫码，请在微信中确认登录"
+  };
+  function poll() {
+    fetch(statusURL, { credentials: "same-origin" }).then(function (res) {
+      return res.json();
+    }).then(function (res) {
+      if (!res.success) {
+        hint.textContent = res.message;
+        hint.className = "hint error";
+        return;
+      }
+      if (res.data.redirect) {
+        hint.textContent = "登录成功，正在跳转...";
+        window.location.replace(res.data.redirect);
+        return;
+      }
+      if (messages[res.data.status]) {
+        hint.textContent = messages[res.data.status];
+        hint.className = "hint error";
+        return;
+      }
+      if (hints[res.data.status]) {
+        hint.textContent = hints[res.data.status];
+      }
+      setTimeout(poll, 1500);
+    }).catch(function () {
+      setTimeout(poll, 3000);
+    });
+  }
+  setTimeout(poll, 1500);
+})();
+</script>
+{{end}}
+</body>
+</html>`))
+
+type loginPageData struct {
+	SystemName string
+	ClientName string
+	QRCodeURL  string
+	StatusURL  string
+	Message    string
+}
+
+func renderLoginPage(c *gin.Context, status int, data loginPageData) {
+	data.SystemName = common.SystemName
+	c.Status(status)
+	c.Header("Content-Type", "text/html; charset=utf-8")
+	c.Header("Cache-Control", "no-store")
+	if err := loginPageTemplate.Execute(c.Writer, data); err != nil {
+		common.RequestError(c, "failed to render login page: "+err.Error())
+	}
+}
+
+// HostedLogin shows the QR code of a session created with a redirect_uri,
+// the login token is the credential, so it must only be handed to the browser which is logging in.
+func HostedLogin(c *gin.Context) {
+	session := common.GetSessionManager().GetSession(c.Query("login_token"))
+	if session == nil || session.RedirectURI == "" || session.QRCodeURL == "" {
+		renderLoginError(c, "登录会话无效或已过期，请重新发起登录")
+		return
+	}
+	renderLoginPage(c, http.StatusOK, loginPageData{
+		ClientName: session.AppName,
+		QRCodeURL:  session.QRCodeURL,
+		StatusURL:  "/api/wechat/login/status?login_token=" + url.QueryEscape(session.LoginToken),
+	})
+}
+
+// GetHostedLoginStatus is polled by the hosted login page, it reports where to redirect once the session succeeds
+func GetHostedLoginStatus(c *gin.Context) {
+	session := common.GetSessionManager().GetSession(c.Query("login_token"))
+	if session == nil || session.ClientID == "" || session.RedirectURI == "" {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": "登录会话无效或已过期，请刷新页面重试",
+		})
+		return
+	}
+	data := gin.H{
+		"status": session.Status,
+	}
+	if session.Status == common.SessionStatusSuccess {
+		data["redirect"] = appendQuery(session.RedirectURI, map[string]string{
+			"code":  session.AuthCode,
+			"state": session.State,
+		})
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data":    data,
+	})
+}
+
+func renderLoginError(c *gin.Context, message string) {
+	renderLoginPage(c, http.StatusBadRequest, loginPageData{Message: message})
+}
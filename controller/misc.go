@@ -18,6 +18,7 @@ func GetStatus(c *gin.Context) {
 			"email_verification": common.EmailVerificationEnabled,
 			"github_oauth":       common.GitHubOAuthEnabled,
 			"github_client_id":   common.GitHubClientId,
+			"wechat_login":       common.WeChatAuthEnabled,
 			"system_name":        common.SystemName,
 			"footer_html":        common.FooterHTML,
 		},
@@ -52,12 +53,12 @@ func SendEmailVerification(c *gin.Context) {
 		})
 		return
 	}
-	code := common.GenerateVerificationCode(6)
+	code := common.GenerateVerificationCodeFor(common.EmailVerificationPurpose)
 	common.RegisterVerificationCodeWithKey(email, code, common.EmailVerificationPurpose)
 	subject := fmt.Sprintf("%s邮箱验证邮件", common.SystemName)
 	content := fmt.Sprintf("<p>您好，你正在进行%s邮箱验证。</p>"+
 		"<p>您的验证码为: <strong>%s</strong></p>"+
-		"<p>验证码 %d 分钟内有效，如果不是本人操作，请忽略。</p>", common.SystemName, code, common.VerificationValidMinutes)
+		"<p>验证码 %d 分钟内有效，如果不是本人操作，请忽略。</p>", common.SystemName, code, common.EmailVerificationPolicy.ValidMinutes)
 	err := common.SendEmail(subject, email, content)
 	if err != nil {
 		c.JSON(http.StatusOK, gin.H{
@@ -89,13 +90,13 @@ func SendPasswordResetEmail(c *gin.Context) {
 		})
 		return
 	}
-	code := common.GenerateVerificationCode(0)
+	code := common.GenerateVerificationCodeFor(common.PasswordResetPurpose)
 	common.RegisterVerificationCodeWithKey(email, code, common.PasswordResetPurpose)
 	link := fmt.Sprintf("%s/api/user/reset?email=%s&token=%s", common.ServerAddress, email, code)
 	subject := fmt.Sprintf("%s密码重置", common.SystemName)
 	content := fmt.Sprintf("<p>您好，你正在进行%s密码重置。</p>"+
 		"<p>点击<a href='%s'>此处</a>系统后系统将为你生成一个新的密码，如不需要请勿点击。</p>"+
-		"<p>重置链接 %d 分钟内有效，如果不是本人操作，请忽略。</p>", common.SystemName, link, common.VerificationValidMinutes)
+		"<p>重置链接 %d 分钟内有效，如果不是本人操作，请忽略。</p>", common.SystemName, link, common.PasswordResetPolicy.ValidMinutes)
 	err := common.SendEmail(subject, email, content)
 	if err != nil {
 		c.JSON(http.StatusOK, gin.H{
@@ -150,6 +151,7 @@ func SendNewPasswordEmail(c *gin.Context) {
 		return
 	}
 	common.DeleteKey(email, common.PasswordResetPurpose)
+	recordAuditAs(c, 0, email, "user.password_reset", "user", email, nil, nil)
 	c.Redirect(http.StatusSeeOther, "/")
 	return
 }
@@ -3,9 +3,11 @@ package controller
 import (
 	"bytes"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"github.com/gin-gonic/gin"
 	"net/http"
+	"strconv"
 	"time"
 	"wechat-server/common"
 	"wechat-server/model"
@@ -18,36 +20,24 @@ type GitHubOAuthResponse struct {
 }
 
 type GitHubUser struct {
+	Id    int64  `json:"id"`
 	Login string `json:"login"`
 	Name  string `json:"name"`
 	Email string `json:"email"`
 }
 
-func GitHubOAuth(c *gin.Context) {
-	code := c.Query("code")
+func getGitHubUserInfoByCode(code string) (*GitHubUser, error) {
 	if code == "" {
-		c.JSON(http.StatusOK, gin.H{
-			"success": false,
-			"message": "非法的参数",
-		})
-		return
+		return nil, errors.New("非法的参数")
 	}
 	values := map[string]string{"client_id": common.GitHubClientId, "client_secret": common.GitHubClientSecret, "code": code}
 	jsonData, err := json.Marshal(values)
 	if err != nil {
-		c.JSON(http.StatusOK, gin.H{
-			"success": false,
-			"message": err.Error(),
-		})
-		return
+		return nil, err
 	}
 	req, err := http.NewRequest("POST", "https://github.com/login/oauth/access_token", bytes.NewBuffer(jsonData))
 	if err != nil {
-		c.JSON(http.StatusOK, gin.H{
-			"success": false,
-			"message": err.Error(),
-		})
-		return
+		return nil, err
 	}
 	req.Header.Set("Content-Type", "application/json")
 	req.Header.Set("Accept", "application/json")
@@ -56,39 +46,73 @@ func GitHubOAuth(c *gin.Context) {
 	}
 	res, err := client.Do(req)
 	if err != nil {
-		c.JSON(http.StatusOK, gin.H{
-			"success": false,
-			"message": err.Error(),
-		})
-		return
+		return nil, err
 	}
 	defer res.Body.Close()
 	var oAuthResponse GitHubOAuthResponse
 	err = json.NewDecoder(res.Body).Decode(&oAuthResponse)
 	if err != nil {
-		c.JSON(http.StatusOK, gin.H{
-			"success": false,
-			"message": err.Error(),
-		})
-		return
+		return nil, err
 	}
 	req, err = http.NewRequest("GET", "https://api.github.com/user", nil)
 	if err != nil {
-		c.JSON(http.StatusOK, gin.H{
-			"success": false,
-			"message": err.Error(),
-		})
-		return
+		return nil, err
 	}
 	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", oAuthResponse.AccessToken))
+	res2, err := client.Do(req)
 	if err != nil {
-		c.JSON(http.StatusOK, gin.H{
-			"success": false,
-			"message": err.Error(),
-		})
-		return
+		return nil, err
 	}
-	res2, err := client.Do(req)
+	defer res2.Body.Close()
+	var githubUser GitHubUser
+	err = json.NewDecoder(res2.Body).Decode(&githubUser)
+	if err != nil {
+		return nil, err
+	}
+	if githubUser.Id == 0 || githubUser.Login == "" {
+		return nil, errors.New("返回值非法，用户字段为空")
+	}
+	return &githubUser, nil
+}
+
+func (githubUser *GitHubUser) subject() string {
+	return strconv.FormatInt(githubUser.Id, 10)
+}
+
+func (githubUser *GitHubUser) metadata() string {
+	return model.EncodeIdentityMetadata(map[string]string{
+		"login": githubUser.Login,
+		"name":  githubUser.Name,
+	})
+}
+
+// findGitHubUser Users created by earlier versions are named github_<login> and have no identity. They are never
+// linked by the name, since GitHub logins can be reused after a rename, the owner has to link the account explicitly.
+func findGitHubUser(githubUser *GitHubUser, user *model.User) (bool, error) {
+	found, err := user.FillUserByIdentity(common.IdentityProviderGitHub, githubUser.subject())
+	if err != nil || found {
+		return found, err
+	}
+	legacyUser := model.User{
+		Username: "github_" + githubUser.Login,
+	}
+	if !model.IsUsernameAlreadyTaken(legacyUser.Username) {
+		return false, nil
+	}
+	legacyUser.FillUserByUsername()
+	identity, err := model.GetUserIdentityByProvider(legacyUser.Id, common.IdentityProviderGitHub)
+	if err != nil {
+		return false, err
+	}
+	if identity == nil {
+		return false, model.ErrIdentityLegacyUser
+	}
+	// The name is taken by another GitHub account which was renamed
+	return false, nil
+}
+
+func GitHubOAuth(c *gin.Context) {
+	githubUser, err := getGitHubUserInfoByCode(c.Query("code"))
 	if err != nil {
 		c.JSON(http.StatusOK, gin.H{
 			"success": false,
@@ -96,9 +120,8 @@ func GitHubOAuth(c *gin.Context) {
 		})
 		return
 	}
-	defer res2.Body.Close()
-	var githubUser GitHubUser
-	err = json.NewDecoder(res2.Body).Decode(&githubUser)
+	user := model.User{}
+	found, err := findGitHubUser(githubUser, &user)
 	if err != nil {
 		c.JSON(http.StatusOK, gin.H{
 			"success": false,
@@ -106,43 +129,44 @@ func GitHubOAuth(c *gin.Context) {
 		})
 		return
 	}
-	user := model.User{
-		Email: githubUser.Email,
-	}
-	if githubUser.Email != "" && model.IsEmailAlreadyTaken(githubUser.Email) {
-		user.FillUserByEmail()
-	} else {
-		if githubUser.Login == "" {
+	if !found {
+		if !common.RegisterEnabled {
 			c.JSON(http.StatusOK, gin.H{
 				"success": false,
-				"message": "返回值非法，用户字段为空",
+				"message": "管理员关闭了新用户注册",
+			})
+			return
+		}
+		// Never merge into an existing account by email, the owner has to link it explicitly
+		if githubUser.Email != "" && model.IsEmailAlreadyTaken(githubUser.Email) {
+			c.JSON(http.StatusOK, gin.H{
+				"success": false,
+				"message": model.ErrIdentityEmailTaken.Error(),
 			})
 			return
 		}
 		user.Username = "github_" + githubUser.Login
 		if model.IsUsernameAlreadyTaken(user.Username) {
-			user.FillUserByUsername()
-		} else {
-			user.DisplayName = githubUser.Name
-			user.Role = common.RoleCommonUser
-			user.Status = common.UserStatusEnabled
-
-			if !common.RegisterEnabled {
-				c.JSON(http.StatusOK, gin.H{
-					"success": false,
-					"message": "管理员关闭了新用户注册",
-				})
-				return
-			}
-
-			if err := user.Insert(); err != nil {
-				c.JSON(http.StatusOK, gin.H{
-					"success": false,
-					"message": err.Error(),
-				})
-				return
+			user.Username = "github_" + githubUser.Login + "_" + common.GenerateVerificationCode(4)
+		}
+		user.DisplayName = githubUser.Name
+		user.Email = githubUser.Email
+		user.Role = common.RoleCommonUser
+		user.Status = common.UserStatusEnabled
+		if err := user.InsertWithIdentity(common.IdentityProviderGitHub, githubUser.subject(), githubUser.metadata()); err != nil {
+			c.JSON(http.StatusOK, gin.H{
+				"success": false,
+				"message": err.Error(),
+			})
+			return
+		}
+		if user.Email != "" {
+			if err := model.LinkUserIdentity(user.Id, common.IdentityProviderEmail, user.Email, ""); err != nil {
+				common.RequestError(c, "failed to link email identity: "+err.Error())
 			}
 		}
+		recordAuditAs(c, user.Id, user.Username, "user.register", "user", user.Id, nil,
+			map[string]interface{}{"provider": common.IdentityProviderGitHub, "subject": githubUser.subject()})
 	}
 	if user.Status != common.UserStatusEnabled {
 		c.JSON(http.StatusOK, gin.H{
@@ -153,3 +177,28 @@ func GitHubOAuth(c *gin.Context) {
 	}
 	setupLogin(&user, c)
 }
+
+func GitHubBind(c *gin.Context) {
+	githubUser, err := getGitHubUserInfoByCode(c.Query("code"))
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	err = model.LinkUserIdentity(c.GetInt("id"), common.IdentityProviderGitHub, githubUser.subject(), githubUser.metadata())
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	recordAudit(c, "identity.link", "user", c.GetInt("id"), nil,
+		map[string]interface{}{"provider": common.IdentityProviderGitHub, "subject": githubUser.subject()})
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+	})
+}
@@ -0,0 +1,253 @@
+package controller
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"wechat-server/common"
+	"wechat-server/model"
+
+	"github.com/gin-gonic/gin"
+)
+
+// https://openid.net/specs/openid-connect-core-1_0.html
+
+func oidcIssuer() string {
+	return strings.TrimSuffix(common.ServerAddress, "/")
+}
+
+func hasScope(scope string, target string) bool {
+	for _, s := range strings.Fields(scope) {
+		if s == target {
+			return true
+		}
+	}
+	return false
+}
+
+func oidcError(c *gin.Context, status int, code string, description string) {
+	c.Header("Cache-Control", "no-store")
+	c.JSON(status, gin.H{
+		"error":             code,
+		"error_description": description,
+	})
+}
+
+func OIDCDiscovery(c *gin.Context) {
+	issuer := oidcIssuer()
+	c.JSON(http.StatusOK, gin.H{
+		"issuer":                                issuer,
+		"authorization_endpoint":                issuer + "/oidc/authorize",
+		"token_endpoint":                        issuer + "/oidc/token",
+		"userinfo_endpoint":                     issuer + "/oidc/userinfo",
+		"jwks_uri":                              issuer + "/oidc/jwks",
+		"response_types_supported":              []string{"code"},
+		"grant_types_supported":                 []string{"authorization_code"},
+		"subject_types_supported":               []string{"public"},
+		"id_token_signing_alg_values_supported": []string{"RS256"},
+		"scopes_supported":                      []string{"openid", "profile"},
+		"token_endpoint_auth_methods_supported": []string{"client_secret_basic", "client_secret_post"},
+		"claims_supported":                      []string{"iss", "sub", "aud", "exp", "iat", "auth_time", "nonce", "name", "picture", "unionid"},
+	})
+}
+
+func OIDCJWKS(c *gin.Context) {
+	keys := make([]map[string]string, 0, 1)
+	if jwk := common.GetOIDCPublicJWK(); jwk != nil {
+		keys = append(keys, jwk)
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"keys": keys,
+	})
+}
+
+func OIDCAuthorize(c *gin.Context) {
+	clientId := c.Query("client_id")
+	redirectURI := c.Query("redirect_uri")
+	state := c.Query("state")
+	scope := c.Query("scope")
+	client, err := model.GetClientByClientId(clientId)
+	if clientId == "" || err != nil || client.Status != common.ClientStatusEnabled {
+		renderLoginError(c, "无效的 client_id")
+		return
+	}
+	// Never redirect to an unregistered redirect_uri, even to report errors
+	if !client.AllowsRedirectURI(redirectURI) {
+		renderLoginError(c, "redirect_uri 未在该客户端中注册")
+		return
+	}
+	if c.Query("response_type") != "code" {
+		c.Redirect(http.StatusFound, appendQuery(redirectURI, map[string]string{
+			"error": "unsupported_response_type",
+			"state": state,
+		}))
+		return
+	}
+	if !hasScope(scope, "openid") {
+		c.Redirect(http.StatusFound, appendQuery(redirectURI, map[string]string{
+			"error":             "invalid_scope",
+			"error_description": "scope must contain openid",
+			"state":             state,
+		}))
+		return
+	}
+	opts := common.LoginSessionOptions{
+		RedirectURI: redirectURI,
+		ClientID:    client.ClientId,
+		State:       state,
+		Nonce:       c.Query("nonce"),
+		Scope:       scope,
+		AppName:     client.Name,
+		ClientIP:    c.ClientIP(),
+	}
+	// Users inside WeChat's browser can't scan, use web page authorization instead
+	inWeChat := strings.Contains(c.Request.UserAgent(), "MicroMessenger")
+	if inWeChat {
+		opts.OAuthScope = common.WeChatOAuthScopeBase
+		if hasScope(scope, "profile") {
+			opts.OAuthScope = common.WeChatOAuthScopeUserInfo
+		}
+	}
+	session := common.GetSessionManager().CreateSessionWithOptions(opts)
+	if session == nil {
+		renderLoginError(c, "创建登录会话失败")
+		return
+	}
+	if inWeChat {
+		callbackURL := oidcIssuer() + "/api/wechat/oauth/callback"
+		c.Redirect(http.StatusFound, common.BuildWeChatOAuthURL(callbackURL, opts.OAuthScope, session.SceneID))
+		return
+	}
+	qrResp, err := requestWeChatQRCode(session.SceneID)
+	if err != nil {
+		common.RequestError(c, "failed to create OIDC login QRCode: "+err.Error())
+		renderLoginError(c, err.Error())
+		return
+	}
+	renderLoginPage(c, http.StatusOK, loginPageData{
+		ClientName: client.Name,
+		QRCodeURL:  qrResp.ImageURL(),
+		StatusURL:  "/api/wechat/login/status?login_token=" + url.QueryEscape(session.LoginToken),
+	})
+}
+
+func OIDCToken(c *gin.Context) {
+	clientId, clientSecret, ok := c.Request.BasicAuth()
+	if !ok {
+		clientId = c.PostForm("client_id")
+		clientSecret = c.PostForm("client_secret")
+	}
+	client := model.ValidateClientCredentials(clientId, clientSecret)
+	if client == nil {
+		c.Header("WWW-Authenticate", `Basic realm="oidc"`)
+		oidcError(c, http.StatusUnauthorized, "invalid_client", "client authentication failed")
+		return
+	}
+	if c.PostForm("grant_type") != "authorization_code" {
+		oidcError(c, http.StatusBadRequest, "unsupported_grant_type", "only authorization_code is supported")
+		return
+	}
+	code := c.PostForm("code")
+	if code == "" {
+		oidcError(c, http.StatusBadRequest, "invalid_request", "code is required")
+		return
+	}
+	grant, err := common.GetSessionManager().ExchangeAuthCode(code, client.ClientId)
+	if err != nil {
+		if _, ok := err.(*common.AuthCodeError); !ok {
+			common.RequestError(c, "failed to exchange auth code: "+err.Error())
+			oidcError(c, http.StatusInternalServerError, "server_error", "failed to exchange code")
+			return
+		}
+		oidcError(c, http.StatusBadRequest, "invalid_grant", "code is invalid, expired or already used")
+		return
+	}
+	if c.PostForm("redirect_uri") != grant.RedirectURI {
+		oidcError(c, http.StatusBadRequest, "invalid_grant", "redirect_uri mismatch")
+		return
+	}
+
+	issuer := oidcIssuer()
+	now := time.Now().Unix()
+	expiresAt := now + int64(common.OIDCTokenValidSeconds)
+	profile := gin.H{}
+	if hasScope(grant.Scope, "profile") && grant.UserInfo != nil {
+		if grant.UserInfo.Nickname != "" {
+			profile["name"] = grant.UserInfo.Nickname
+		}
+		if grant.UserInfo.HeadImgURL != "" {
+			profile["picture"] = grant.UserInfo.HeadImgURL
+		}
+		if grant.UserInfo.UnionID != "" {
+			profile["unionid"] = grant.UserInfo.UnionID
+		}
+	}
+	idClaims := map[string]interface{}{
+		"iss":       issuer,
+		"sub":       grant.WeChatID,
+		"aud":       client.ClientId,
+		"iat":       now,
+		"exp":       expiresAt,
+		"auth_time": grant.CreatedAt.Unix(),
+	}
+	if grant.Nonce != "" {
+		idClaims["nonce"] = grant.Nonce
+	}
+	accessClaims := map[string]interface{}{
+		"iss":       issuer,
+		"sub":       grant.WeChatID,
+		"aud":       issuer + "/oidc/userinfo",
+		"client_id": client.ClientId,
+		"scope":     grant.Scope,
+		"token_use": "access",
+		"iat":       now,
+		"exp":       expiresAt,
+	}
+	for k, v := range profile {
+		idClaims[k] = v
+		accessClaims[k] = v
+	}
+	idToken, err := common.SignJWT(idClaims)
+	if err != nil {
+		common.RequestError(c, "failed to sign id token: "+err.Error())
+		oidcError(c, http.StatusInternalServerError, "server_error", "failed to issue token")
+		return
+	}
+	accessToken, err := common.SignJWT(accessClaims)
+	if err != nil {
+		common.RequestError(c, "failed to sign access token: "+err.Error())
+		oidcError(c, http.StatusInternalServerError, "server_error", "failed to issue token")
+		return
+	}
+	c.Header("Cache-Control", "no-store")
+	c.JSON(http.StatusOK, gin.H{
+		"access_token": accessToken,
+		"token_type":   "Bearer",
+		"expires_in":   common.OIDCTokenValidSeconds,
+		"id_token":     idToken,
+		"scope":        grant.Scope,
+	})
+	common.RequestLog(c, fmt.Sprintf("OIDC token issued: client=%s, wechat=%s", client.ClientId, grant.WeChatID))
+}
+
+func OIDCUserInfo(c *gin.Context) {
+	token := strings.TrimSpace(strings.TrimPrefix(c.Request.Header.Get("Authorization"), "Bearer "))
+	claims, err := common.VerifyJWT(token)
+	if token == "" || err != nil || claims["token_use"] != "access" {
+		c.Header("WWW-Authenticate", `Bearer error="invalid_token"`)
+		oidcError(c, http.StatusUnauthorized, "invalid_token", "access token is invalid or expired")
+		return
+	}
+	info := gin.H{
+		"sub": claims["sub"],
+	}
+	for _, k := range []string{"name", "picture", "unionid"} {
+		if v, ok := claims[k]; ok {
+			info[k] = v
+		}
+	}
+	c.JSON(http.StatusOK, info)
+}
@@ -6,24 +6,24 @@ import (
 	"fmt"
 	"github.com/gin-gonic/gin"
 	"net/http"
-	"strings"
 	"wechat-server/common"
 	"wechat-server/model"
 )
 
+// GetOptions the values of secret options are never returned
 func GetOptions(c *gin.Context) {
 	var options []*model.Option
-	common.OptionMapRWMutex.Lock()
-	for k, v := range common.OptionMap {
-		if strings.Contains(k, "Token") || strings.Contains(k, "Secret") || strings.Contains(k, "Key") {
+	common.OptionMapRWMutex.RLock()
+	for _, definition := range model.GetOptionDefinitions() {
+		if definition.Secret {
 			continue
 		}
 		options = append(options, &model.Option{
-			Key:   k,
-			Value: common.Interface2String(v),
+			Key:   definition.Key,
+			Value: common.OptionMap[definition.Key],
 		})
 	}
-	common.OptionMapRWMutex.Unlock()
+	common.OptionMapRWMutex.RUnlock()
 	c.JSON(http.StatusOK, gin.H{
 		"success": true,
 		"message": "",
@@ -32,6 +32,16 @@ func GetOptions(c *gin.Context) {
 	return
 }
 
+// GetOptionSchema describes the options, so the settings page can be rendered from it
+func GetOptionSchema(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data":    model.GetOptionDefinitions(),
+	})
+	return
+}
+
 func UpdateOption(c *gin.Context) {
 	var option model.Option
 	err := json.NewDecoder(c.Request.Body).Decode(&option)
@@ -42,13 +52,16 @@ func UpdateOption(c *gin.Context) {
 		})
 		return
 	}
-	if option.Key == "GitHubOAuthEnabled" && option.Value == "true" && common.GitHubClientId == "" {
+	if definition := model.GetOptionDefinition(option.Key); definition == nil || definition.Internal {
 		c.JSON(http.StatusOK, gin.H{
 			"success": false,
-			"message": "无法启用 GitHub OAuth，请先填入 GitHub Client ID 以及 GitHub Client Secret！",
+			"message": "未知的配置项：" + option.Key,
 		})
 		return
 	}
+	common.OptionMapRWMutex.RLock()
+	before := common.OptionMap[option.Key]
+	common.OptionMapRWMutex.RUnlock()
 	err = model.UpdateOption(option.Key, option.Value)
 	if err != nil {
 		c.JSON(http.StatusOK, gin.H{
@@ -57,9 +70,11 @@ func UpdateOption(c *gin.Context) {
 		})
 		return
 	}
+	recordAuditDiff(c, "option.update", "option", option.Key, model.AuditOptionDiff(option.Key, before, option.Value))
 	if option.Key == "WeChatMenu" {
 		httpResponse, err := http.Post(fmt.Sprintf("https://api.weixin.qq.com/cgi-bin/menu/create?access_token=%s", common.GetAccessToken()), "application/json", bytes.NewBuffer([]byte(option.Value)))
 		if err != nil {
+			common.RecordWeChatAPICall("cgi-bin/menu/create", 0, err)
 			c.JSON(http.StatusOK, gin.H{
 				"success": false,
 				"message": err.Error(),
@@ -69,6 +84,7 @@ func UpdateOption(c *gin.Context) {
 		defer httpResponse.Body.Close()
 		var res wechatResponse
 		err = json.NewDecoder(httpResponse.Body).Decode(&res)
+		common.RecordWeChatAPICall("cgi-bin/menu/create", res.ErrCode, err)
 		if err != nil {
 			c.JSON(http.StatusOK, gin.H{
 				"success": false,
@@ -0,0 +1,303 @@
+package controller
+
+import (
+	"encoding/json"
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"strconv"
+	"strings"
+	"wechat-server/common"
+	"wechat-server/model"
+)
+
+type UserRolesRequest struct {
+	RoleIds []int `json:"role_ids"`
+}
+
+// validatePermissions checks the permissions of a role, a user can only grant permissions it has itself
+func validatePermissions(c *gin.Context, permissions string) (string, string) {
+	granted, err := model.GetUserPermissions(c.GetInt("id"), c.GetInt("role"))
+	if err != nil {
+		return "", err.Error()
+	}
+	var valid []string
+	for _, permission := range strings.Fields(permissions) {
+		known := false
+		for _, p := range common.Permissions {
+			if p == permission {
+				known = true
+				break
+			}
+		}
+		if !known {
+			return "", "无效的权限：" + permission
+		}
+		if !model.HasPermission(granted, permission) {
+			return "", "无法授予自己没有的权限：" + permission
+		}
+		valid = append(valid, permission)
+	}
+	return strings.Join(valid, " "), ""
+}
+
+func GetAllRoles(c *gin.Context) {
+	roles, err := model.GetAllRoles()
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data":    roles,
+	})
+	return
+}
+
+func GetPermissions(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data":    common.Permissions,
+	})
+	return
+}
+
+func CreateRole(c *gin.Context) {
+	var role model.Role
+	err := json.NewDecoder(c.Request.Body).Decode(&role)
+	if err != nil || role.Name == "" {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": "无效的参数",
+		})
+		return
+	}
+	permissions, message := validatePermissions(c, role.Permissions)
+	if message != "" {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": message,
+		})
+		return
+	}
+	cleanRole := model.Role{
+		Name:        role.Name,
+		Description: role.Description,
+		Permissions: permissions,
+	}
+	if err := cleanRole.Insert(); err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	recordAudit(c, "role.create", "role", cleanRole.Id, nil, map[string]interface{}{"name": cleanRole.Name, "permissions": cleanRole.Permissions})
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data":    cleanRole,
+	})
+	return
+}
+
+func UpdateRole(c *gin.Context) {
+	var role model.Role
+	err := json.NewDecoder(c.Request.Body).Decode(&role)
+	if err != nil || role.Id == 0 || role.Name == "" {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": "无效的参数",
+		})
+		return
+	}
+	permissions, message := validatePermissions(c, role.Permissions)
+	if message != "" {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": message,
+		})
+		return
+	}
+	originRole, err := model.GetRoleById(role.Id)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	cleanRole := model.Role{
+		Id:          role.Id,
+		Name:        role.Name,
+		Description: role.Description,
+		Permissions: permissions,
+	}
+	if err := cleanRole.Update(); err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	recordAudit(c, "role.update", "role", role.Id,
+		map[string]interface{}{"name": originRole.Name, "description": originRole.Description, "permissions": originRole.Permissions},
+		map[string]interface{}{"name": cleanRole.Name, "description": cleanRole.Description, "permissions": cleanRole.Permissions})
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+	})
+	return
+}
+
+func DeleteRole(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	role := model.Role{Id: id}
+	if err := role.Delete(); err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	recordAudit(c, "role.delete", "role", id, nil, nil)
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+	})
+	return
+}
+
+func GetUserRoles(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	roles, err := model.GetUserRoles(id)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data":    roles,
+	})
+	return
+}
+
+func UpdateUserRoles(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	var req UserRolesRequest
+	if err := json.NewDecoder(c.Request.Body).Decode(&req); err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": "无效的参数",
+		})
+		return
+	}
+	user, err := model.GetUserById(id, false)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	if c.GetInt("role") <= user.Role {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": "无权更新同权限等级或更高权限等级的用户的角色",
+		})
+		return
+	}
+	// The roles assigned can't grant more than the permissions of the current user
+	for _, roleId := range req.RoleIds {
+		role, err := model.GetRoleById(roleId)
+		if err != nil {
+			c.JSON(http.StatusOK, gin.H{
+				"success": false,
+				"message": model.ErrRoleInvalid.Error(),
+			})
+			return
+		}
+		if _, message := validatePermissions(c, role.Permissions); message != "" {
+			c.JSON(http.StatusOK, gin.H{
+				"success": false,
+				"message": message,
+			})
+			return
+		}
+	}
+	originRoles, err := model.GetUserRoles(id)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	if err := model.SetUserRoles(id, req.RoleIds); err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	var originRoleIds []int
+	for _, role := range originRoles {
+		originRoleIds = append(originRoleIds, role.Id)
+	}
+	recordAudit(c, "user.roles_update", "user", id,
+		map[string]interface{}{"role_ids": originRoleIds}, map[string]interface{}{"role_ids": req.RoleIds})
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+	})
+	return
+}
+
+func GetSelfPermissions(c *gin.Context) {
+	permissions, err := model.GetUserPermissions(c.GetInt("id"), c.GetInt("role"))
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	if permissions == nil {
+		permissions = []string{}
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data":    permissions,
+	})
+	return
+}
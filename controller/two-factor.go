@@ -0,0 +1,301 @@
+package controller
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"github.com/gin-contrib/sessions"
+	"github.com/gin-gonic/gin"
+	"github.com/skip2/go-qrcode"
+	"net/http"
+	"strconv"
+	"time"
+	"wechat-server/common"
+	"wechat-server/model"
+)
+
+const (
+	twoFactorPendingIdKey   = "2fa_pending_id"
+	twoFactorPendingTimeKey = "2fa_pending_time"
+	twoFactorPendingMinutes = 5
+)
+
+type TwoFactorCodeRequest struct {
+	Code string `json:"code"`
+}
+
+func decodeTwoFactorCode(c *gin.Context) (string, bool) {
+	var req TwoFactorCodeRequest
+	err := json.NewDecoder(c.Request.Body).Decode(&req)
+	if err != nil || req.Code == "" {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": "无效的参数",
+		})
+		return "", false
+	}
+	return req.Code, true
+}
+
+// checkTwoFactorCode verifies the code with the attempts limited per user
+func checkTwoFactorCode(userId int, code string) error {
+	return common.CheckWithAttemptLimit(common.TwoFactorPurpose, strconv.Itoa(userId), func() error {
+		return model.VerifyTwoFactor(userId, code)
+	})
+}
+
+// setupTwoFactorLogin remembers the user who passed the first step, the session isn't logged in yet
+func setupTwoFactorLogin(user *model.User, c *gin.Context) {
+	session := sessions.Default(c)
+	session.Clear()
+	session.Set(twoFactorPendingIdKey, user.Id)
+	session.Set(twoFactorPendingTimeKey, time.Now().Unix())
+	err := session.Save()
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"message": "无法保存会话信息，请重试",
+			"success": false,
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"message": "",
+		"success": true,
+		"data": gin.H{
+			"require_2fa": true,
+		},
+	})
+}
+
+// LoginTwoFactor is the second login step of users with two-factor authentication enabled
+func LoginTwoFactor(c *gin.Context) {
+	session := sessions.Default(c)
+	id, ok1 := session.Get(twoFactorPendingIdKey).(int)
+	pendingTime, ok2 := session.Get(twoFactorPendingTimeKey).(int64)
+	if !ok1 || !ok2 || time.Now().Unix()-pendingTime > twoFactorPendingMinutes*60 {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": "登录已过期，请重新登录",
+		})
+		return
+	}
+	code, ok := decodeTwoFactorCode(c)
+	if !ok {
+		return
+	}
+	user, err := model.GetUserById(id, false)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	if user.Status != common.UserStatusEnabled {
+		c.JSON(http.StatusOK, gin.H{
+			"message": "用户已被封禁",
+			"success": false,
+		})
+		return
+	}
+	err = checkTwoFactorCode(user.Id, code)
+	if err != nil {
+		recordAuditAs(c, user.Id, user.Username, "user.2fa_failed", "user", user.Id, nil, nil)
+		if errors.Is(err, common.ErrVerificationLocked) {
+			session.Clear()
+			_ = session.Save()
+		}
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	completeLogin(user, c)
+}
+
+func GetSelfTwoFactor(c *gin.Context) {
+	twoFactor, err := model.GetTwoFactor(c.GetInt("id"))
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	enabled := twoFactor != nil && twoFactor.Enabled
+	recoveryCodesLeft := 0
+	if enabled {
+		recoveryCodesLeft = twoFactor.CountRecoveryCodes()
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data": gin.H{
+			"enabled":             enabled,
+			"required":            model.IsTwoFactorRequired(c.GetInt("role")),
+			"recovery_codes_left": recoveryCodesLeft,
+		},
+	})
+	return
+}
+
+// SetupSelfTwoFactor generates a new secret, it's only in effect once confirmed by EnableSelfTwoFactor
+func SetupSelfTwoFactor(c *gin.Context) {
+	twoFactor, err := model.SetupTwoFactor(c.GetInt("id"))
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	uri := common.TOTPProvisioningURI(common.SystemName, c.GetString("username"), twoFactor.Secret)
+	png, err := qrcode.Encode(uri, qrcode.Medium, 256)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data": gin.H{
+			"secret": twoFactor.Secret,
+			"uri":    uri,
+			"qrcode": "data:image/png;base64," + base64.StdEncoding.EncodeToString(png),
+		},
+	})
+	return
+}
+
+// EnableSelfTwoFactor The recovery codes are only returned here, we only store their hashes
+func EnableSelfTwoFactor(c *gin.Context) {
+	code, ok := decodeTwoFactorCode(c)
+	if !ok {
+		return
+	}
+	codes, err := model.EnableTwoFactor(c.GetInt("id"), code)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	recordAudit(c, "2fa.enable", "user", c.GetInt("id"), nil, nil)
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data":    codes,
+	})
+	return
+}
+
+func DisableSelfTwoFactor(c *gin.Context) {
+	if model.IsTwoFactorRequired(c.GetInt("role")) {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": "管理员要求你的账户启用两步验证，无法关闭",
+		})
+		return
+	}
+	code, ok := decodeTwoFactorCode(c)
+	if !ok {
+		return
+	}
+	id := c.GetInt("id")
+	if err := checkTwoFactorCode(id, code); err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	if err := model.DisableTwoFactor(id); err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	recordAudit(c, "2fa.disable", "user", id, nil, nil)
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+	})
+	return
+}
+
+// RegenerateSelfRecoveryCodes replaces the recovery codes, the old ones can't be used anymore
+func RegenerateSelfRecoveryCodes(c *gin.Context) {
+	code, ok := decodeTwoFactorCode(c)
+	if !ok {
+		return
+	}
+	id := c.GetInt("id")
+	if err := checkTwoFactorCode(id, code); err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	codes, err := model.RegenerateRecoveryCodes(id)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	recordAudit(c, "2fa.recovery_codes", "user", id, nil, nil)
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data":    codes,
+	})
+	return
+}
+
+// ResetUserTwoFactor disables two-factor authentication of a user who lost the device and the recovery codes
+func ResetUserTwoFactor(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	user, err := model.GetUserById(id, false)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	if c.GetInt("role") <= user.Role {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": "无权重置同权限等级或更高权限等级用户的两步验证",
+		})
+		return
+	}
+	if err := model.DisableTwoFactor(id); err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	recordAudit(c, "2fa.reset", "user", id, nil, nil)
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+	})
+	return
+}
@@ -2,12 +2,11 @@ package controller
 
 import (
 	"encoding/json"
+	"fmt"
 	"github.com/gin-contrib/sessions"
 	"github.com/gin-gonic/gin"
-	"github.com/google/uuid"
 	"net/http"
 	"strconv"
-	"strings"
 	"wechat-server/common"
 	"wechat-server/model"
 )
@@ -43,29 +42,93 @@ func Login(c *gin.Context) {
 		})
 		return
 	}
+	attempt, err := common.ReserveLoginAttempt(username)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"message": err.Error(),
+			"success": false,
+		})
+		return
+	}
 	user := model.User{
 		Username: username,
 		Password: password,
 	}
 	err = user.ValidateAndFill()
 	if err != nil {
+		recordAuditAs(c, 0, username, "user.login_failed", "user", nil, nil, nil)
+		if common.RecordLoginFailure(username, attempt) {
+			recordAuditAs(c, 0, username, "user.lockout", "user", nil, nil, nil)
+			ip := c.ClientIP()
+			common.GoAsync(func() {
+				notifyLoginLockout(username, ip)
+			})
+			err = common.ErrLoginLocked
+		}
 		c.JSON(http.StatusOK, gin.H{
 			"message": err.Error(),
 			"success": false,
 		})
 		return
 	}
+	common.ResetLoginFailures(username)
 	setupLogin(&user, c)
 }
 
-// setup session & cookies and then return user info
+// notifyLoginLockout tells the owner of the account by email, or by WeChat if there is no email
+func notifyLoginLockout(username string, ip string) {
+	user := model.User{
+		Username: username,
+	}
+	user.FillUserByUsername()
+	if user.Id == 0 {
+		return
+	}
+	content := fmt.Sprintf("你的账户 %s 因多次登录失败已被临时锁定 %d 分钟，最后一次尝试来自 IP %s。如非本人操作，请及时修改密码。",
+		username, common.LoginLockoutMinutes, ip)
+	if user.Email != "" && common.SMTPServer != "" {
+		if err := common.SendEmail(fmt.Sprintf("%s 账户锁定通知", common.SystemName), user.Email, content); err != nil {
+			common.SysError("failed to send lockout email: " + err.Error())
+		}
+		return
+	}
+	identity, err := model.GetUserIdentityByProvider(user.Id, common.IdentityProviderWeChat)
+	if err != nil || identity == nil {
+		return
+	}
+	if err := common.SendWeChatTextMessage(identity.Subject, content); err != nil {
+		common.SysError("failed to send lockout WeChat message: " + err.Error())
+	}
+}
+
+// setup session & cookies and then return user info,
+// users with two-factor authentication enabled have to pass LoginTwoFactor first
 func setupLogin(user *model.User, c *gin.Context) {
+	if model.IsTwoFactorEnabled(user.Id) {
+		setupTwoFactorLogin(user, c)
+		return
+	}
+	completeLogin(user, c)
+}
+
+func completeLogin(user *model.User, c *gin.Context) {
+	key, err := model.CreateUserSession(user.Id, c.ClientIP(), c.Request.UserAgent())
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"message": "无法保存会话信息，请重试",
+			"success": false,
+		})
+		return
+	}
 	session := sessions.Default(c)
-	session.Set("id", user.Id)
-	session.Set("username", user.Username)
-	session.Set("role", user.Role)
-	session.Set("status", user.Status)
-	err := session.Save()
+	if oldKey, ok := session.Get(common.UserSessionKey).(string); ok {
+		if err := model.DeleteUserSessionByKey(oldKey); err != nil {
+			common.RequestError(c, "failed to delete replaced session: "+err.Error())
+		}
+	}
+	session.Clear()
+	session.Set(common.UserSessionKey, key)
+	err = session.Save()
 	if err != nil {
 		c.JSON(http.StatusOK, gin.H{
 			"message": "无法保存会话信息，请重试",
@@ -73,17 +136,27 @@ func setupLogin(user *model.User, c *gin.Context) {
 		})
 		return
 	}
+	recordAuditAs(c, user.Id, user.Username, "user.login", "user", user.Id, nil, nil)
 	user.Password = ""
-	user.Token = ""
 	c.JSON(http.StatusOK, gin.H{
-		"message": "",
-		"success": true,
-		"data":    user,
+		"message":           "",
+		"success":           true,
+		"data":              user,
+		"require_2fa_setup": model.IsTwoFactorRequired(user.Role) && !model.IsTwoFactorEnabled(user.Id),
 	})
 }
 
 func Logout(c *gin.Context) {
 	session := sessions.Default(c)
+	if key, ok := session.Get(common.UserSessionKey).(string); ok {
+		if err := model.DeleteUserSessionByKey(key); err != nil {
+			c.JSON(http.StatusOK, gin.H{
+				"message": err.Error(),
+				"success": false,
+			})
+			return
+		}
+	}
 	session.Options(sessions.Options{MaxAge: -1})
 	err := session.Save()
 	if err != nil {
@@ -131,10 +204,14 @@ func Register(c *gin.Context) {
 			})
 			return
 		}
-		if !common.VerifyCodeWithKey(user.Email, user.VerificationCode, common.EmailVerificationPurpose) {
+		if err := common.CheckCodeWithKey(user.Email, user.VerificationCode, common.EmailVerificationPurpose); err != nil {
+			message := "验证码错误！"
+			if err == common.ErrVerificationLocked {
+				message = err.Error()
+			}
 			c.JSON(http.StatusOK, gin.H{
 				"success": false,
-				"message": "验证码错误！",
+				"message": message,
 			})
 			return
 		}
@@ -147,13 +224,19 @@ func Register(c *gin.Context) {
 	if common.EmailVerificationEnabled {
 		cleanUser.Email = user.Email
 	}
-	if err := cleanUser.Insert(); err != nil {
+	if cleanUser.Email != "" {
+		err = cleanUser.InsertWithIdentity(common.IdentityProviderEmail, cleanUser.Email, "")
+	} else {
+		err = cleanUser.Insert()
+	}
+	if err != nil {
 		c.JSON(http.StatusOK, gin.H{
 			"success": false,
 			"message": err.Error(),
 		})
 		return
 	}
+	recordAuditAs(c, cleanUser.Id, cleanUser.Username, "user.register", "user", cleanUser.Id, nil, auditUserFields(&cleanUser))
 
 	c.JSON(http.StatusOK, gin.H{
 		"success": true,
@@ -171,6 +254,9 @@ func GetAllUsers(c *gin.Context) {
 		})
 		return
 	}
+	for _, user := range users {
+		user.Locked = common.IsLoginLocked(user.Username)
+	}
 	c.JSON(http.StatusOK, gin.H{
 		"success": true,
 		"message": "",
@@ -212,43 +298,6 @@ func GetUser(c *gin.Context) {
 	return
 }
 
-func GenerateToken(c *gin.Context) {
-	id := c.GetInt("id")
-	user, err := model.GetUserById(id, true)
-	if err != nil {
-		c.JSON(http.StatusOK, gin.H{
-			"success": false,
-			"message": err.Error(),
-		})
-		return
-	}
-	user.Token = uuid.New().String()
-	user.Token = strings.Replace(user.Token, "-", "", -1)
-
-	if model.DB.Where("token = ?", user.Token).First(user).RowsAffected != 0 {
-		c.JSON(http.StatusOK, gin.H{
-			"success": false,
-			"message": "请重试，系统生成的 UUID 竟然重复了！",
-		})
-		return
-	}
-
-	if err := user.Update(false); err != nil {
-		c.JSON(http.StatusOK, gin.H{
-			"success": false,
-			"message": err.Error(),
-		})
-		return
-	}
-
-	c.JSON(http.StatusOK, gin.H{
-		"success": true,
-		"message": "",
-		"data":    user.Token,
-	})
-	return
-}
-
 func GetSelf(c *gin.Context) {
 	id := c.GetInt("id")
 	user, err := model.GetUserById(id, true)
@@ -277,7 +326,7 @@ func UpdateUser(c *gin.Context) {
 		})
 		return
 	}
-	originUser, err := model.GetUserById(updatedUser.Id, false)
+	originUser, err := model.GetUserById(updatedUser.Id, true)
 	if err != nil {
 		c.JSON(http.StatusOK, gin.H{
 			"success": false,
@@ -308,6 +357,7 @@ func UpdateUser(c *gin.Context) {
 		})
 		return
 	}
+	recordUserUpdate(c, "user.update", originUser)
 	c.JSON(http.StatusOK, gin.H{
 		"success": true,
 		"message": "",
@@ -330,6 +380,14 @@ func UpdateSelf(c *gin.Context) {
 	user.Role = c.GetInt("role")
 	user.Status = c.GetInt("status")
 
+	originUser, err := model.GetUserById(user.Id, true)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
 	updatePassword := user.Password != ""
 	// TODO: check Display Name to avoid XSS attack
 	if err := user.Update(updatePassword); err != nil {
@@ -339,6 +397,7 @@ func UpdateSelf(c *gin.Context) {
 		})
 		return
 	}
+	recordUserUpdate(c, "user.update", originUser)
 
 	c.JSON(http.StatusOK, gin.H{
 		"success": true,
@@ -375,11 +434,16 @@ func DeleteUser(c *gin.Context) {
 	err = model.DeleteUserById(id)
 	if err != nil {
 		c.JSON(http.StatusOK, gin.H{
-			"success": true,
-			"message": "",
+			"success": false,
+			"message": err.Error(),
 		})
 		return
 	}
+	recordAudit(c, "user.delete", "user", id, auditUserFields(originUser), nil)
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+	})
 }
 
 func DeleteSelf(c *gin.Context) {
@@ -392,6 +456,7 @@ func DeleteSelf(c *gin.Context) {
 		})
 		return
 	}
+	recordAudit(c, "user.delete", "user", id, nil, nil)
 	c.JSON(http.StatusOK, gin.H{
 		"success": true,
 		"message": "",
@@ -429,6 +494,7 @@ func CreateUser(c *gin.Context) {
 		})
 		return
 	}
+	recordAudit(c, "user.create", "user", user.Id, nil, auditUserFields(&user))
 
 	c.JSON(http.StatusOK, gin.H{
 		"success": true,
@@ -474,6 +540,7 @@ func ManageUser(c *gin.Context) {
 		})
 		return
 	}
+	originUser := user
 	switch req.Action {
 	case "disable":
 		user.Status = common.UserStatusDisabled
@@ -487,6 +554,7 @@ func ManageUser(c *gin.Context) {
 			})
 			return
 		}
+		recordAudit(c, "user.delete", "user", user.Id, auditUserFields(&originUser), nil)
 	case "promote":
 		if myRole != common.RoleRootUser {
 			c.JSON(http.StatusOK, gin.H{
@@ -498,6 +566,20 @@ func ManageUser(c *gin.Context) {
 		user.Role = common.RoleAdminUser
 	case "demote":
 		user.Role = common.RoleCommonUser
+	case "unlock":
+		common.ResetLoginFailures(user.Username)
+		recordAudit(c, "user.unlock", "user", user.Id, nil, nil)
+		c.JSON(http.StatusOK, gin.H{
+			"success": true,
+			"message": "",
+		})
+		return
+	default:
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": "无效的参数",
+		})
+		return
 	}
 
 	if err := user.Update(false); err != nil {
@@ -507,6 +589,9 @@ func ManageUser(c *gin.Context) {
 		})
 		return
 	}
+	if req.Action != "delete" {
+		recordUserUpdate(c, "user."+req.Action, &originUser)
+	}
 
 	c.JSON(http.StatusOK, gin.H{
 		"success": true,
@@ -0,0 +1,205 @@
+package controller
+
+import (
+	"fmt"
+	"net/http"
+
+	"wechat-server/common"
+	"wechat-server/model"
+
+	"github.com/gin-contrib/sessions"
+	"github.com/gin-gonic/gin"
+)
+
+// The admin console logs in with the QR login session like any API client does, the login token is
+// kept in the browser's session so only the browser which requested the QR code can poll it.
+
+const (
+	consoleClientID        = "console"
+	consoleLoginTokenField = "wechat_login_token"
+)
+
+// exchangeConsoleAuthCode returns the WeChat user of an auth code issued to the console
+func exchangeConsoleAuthCode(c *gin.Context) (*common.AuthCodeGrant, bool) {
+	if !common.WeChatAuthEnabled {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": "管理员未开启通过微信登录以及注册",
+		})
+		return nil, false
+	}
+	code := c.Query("code")
+	if code == "" {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": "非法的参数",
+		})
+		return nil, false
+	}
+	grant, err := common.GetSessionManager().ExchangeAuthCode(code, consoleClientID)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return nil, false
+	}
+	session := sessions.Default(c)
+	session.Delete(consoleLoginTokenField)
+	_ = session.Save()
+	return grant, true
+}
+
+func wechatIdentityMetadata(grant *common.AuthCodeGrant) string {
+	if grant.UserInfo == nil {
+		return ""
+	}
+	return model.EncodeIdentityMetadata(map[string]string{
+		"nickname": grant.UserInfo.Nickname,
+		"unionid":  grant.UserInfo.UnionID,
+	})
+}
+
+func GetWeChatAuthQRCode(c *gin.Context) {
+	if !common.WeChatAuthEnabled {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": "管理员未开启通过微信登录以及注册",
+		})
+		return
+	}
+	loginSession := common.GetSessionManager().CreateSessionWithOptions(common.LoginSessionOptions{
+		ClientID: consoleClientID,
+		AppName:  common.SystemName,
+		ClientIP: c.ClientIP(),
+	})
+	if loginSession == nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": "创建登录会话失败",
+		})
+		return
+	}
+	qrResp, err := requestWeChatQRCode(loginSession.SceneID)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	session := sessions.Default(c)
+	session.Set(consoleLoginTokenField, loginSession.LoginToken)
+	if err := session.Save(); err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": "无法保存会话信息，请重试",
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data": gin.H{
+			"qrcode_url":     qrResp.ImageURL(),
+			"expire_seconds": qrResp.ExpireSeconds,
+		},
+	})
+}
+
+// GetWeChatAuthStatus returns the auth code once the QR code is scanned, which is then sent to WeChatAuth or BindWeChat
+func GetWeChatAuthStatus(c *gin.Context) {
+	loginToken, _ := sessions.Default(c).Get(consoleLoginTokenField).(string)
+	loginSession := common.GetSessionManager().GetSession(loginToken)
+	if loginSession == nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": true,
+			"message": "",
+			"data": gin.H{
+				"status": common.SessionStatusExpired,
+			},
+		})
+		return
+	}
+	data := gin.H{
+		"status": loginSession.Status,
+	}
+	if loginSession.Status == common.SessionStatusSuccess {
+		data["code"] = loginSession.AuthCode
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data":    data,
+	})
+}
+
+func WeChatAuth(c *gin.Context) {
+	grant, ok := exchangeConsoleAuthCode(c)
+	if !ok {
+		return
+	}
+	user := model.User{}
+	found, err := user.FillUserByIdentity(common.IdentityProviderWeChat, grant.WeChatID)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	if !found {
+		if !common.RegisterEnabled {
+			c.JSON(http.StatusOK, gin.H{
+				"success": false,
+				"message": "管理员关闭了新用户注册",
+			})
+			return
+		}
+		user.Username = "wechat_" + common.GenerateVerificationCode(8)
+		user.DisplayName = "微信用户"
+		if grant.UserInfo != nil && grant.UserInfo.Nickname != "" {
+			user.DisplayName = grant.UserInfo.Nickname
+		}
+		user.Role = common.RoleCommonUser
+		user.Status = common.UserStatusEnabled
+		if err := user.InsertWithIdentity(common.IdentityProviderWeChat, grant.WeChatID, wechatIdentityMetadata(grant)); err != nil {
+			c.JSON(http.StatusOK, gin.H{
+				"success": false,
+				"message": err.Error(),
+			})
+			return
+		}
+		recordAuditAs(c, user.Id, user.Username, "user.register", "user", user.Id, nil,
+			map[string]interface{}{"provider": common.IdentityProviderWeChat, "subject": grant.WeChatID})
+	}
+	if user.Status != common.UserStatusEnabled {
+		c.JSON(http.StatusOK, gin.H{
+			"message": "用户已被封禁",
+			"success": false,
+		})
+		return
+	}
+	setupLogin(&user, c)
+}
+
+func BindWeChat(c *gin.Context) {
+	grant, ok := exchangeConsoleAuthCode(c)
+	if !ok {
+		return
+	}
+	id := c.GetInt("id")
+	if err := model.LinkUserIdentity(id, common.IdentityProviderWeChat, grant.WeChatID, wechatIdentityMetadata(grant)); err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	recordAudit(c, "identity.link", "user", id, nil, map[string]interface{}{"provider": common.IdentityProviderWeChat, "subject": grant.WeChatID})
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+	})
+	common.RequestLog(c, fmt.Sprintf("WeChat bound: user=%d, wechat=%s", id, grant.WeChatID))
+}
@@ -0,0 +1,176 @@
+package controller
+
+import (
+	"crypto/subtle"
+	"html/template"
+	"net/http"
+
+	"wechat-server/common"
+
+	"github.com/gin-gonic/gin"
+)
+
+// The confirm link in the scan reply opens this page inside WeChat. GET only shows the request,
+// the decision is submitted with POST so that previewing the link never confirms a login.
+
+var loginConfirmPageTemplate = template.Must(template.New("confirm").Parse(`<!DOCTYPE html>
+<html lang="zh-CN">
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>{{.SystemName}} - 确认登录</title>
+<style>
+body { font-family: -apple-system, BlinkMacSystemFont, "Segoe UI", "PingFang SC", sans-serif; background: #f5f5f5; margin: 0; }
+.card { max-width: 360px; margin: 64px auto; background: #fff; border-radius: 8px; padding: 32px; text-align: center; box-shadow: 0 1px 4px rgba(0,0,0,.1); }
+.info { text-align: left; color: #333; line-height: 1.8; }
+.hint { color: #666; margin-top: 16px; }
+.error { color: #d33; }
+button { width: 100%; padding: 12px; margin-top: 12px; border: 0; border-radius: 4px; font-size: 16px; }
+.confirm { background: #07c160; color: #fff; }
+.reject { background: #f2f2f2; color: #d33; }
+</style>
+</head>
+<body>
+<div class="card">
+  <h2>{{.SystemName}}</h2>
+  {{if .Message}}
+  <p class="{{if .Failed}}error{{else}}hint{{end}}">{{.Message}}</p>
+  {{else}}
+  <p>您正在登录 <strong>{{.AppName}}</strong></p>
+  <div class="info">
+    <div>IP：{{.ClientIP}}</div>
+    {{if .Location}}<div>位置（由应用提供）：{{.Location}}</div>{{end}}
+    <div>时间：{{.CreatedAt}}</div>
+  </div>
+  <p class="hint">如非本人操作，请拒绝并注意二维码来源</p>
+  <form method="post">
+    <input type="hidden" name="scene" value="{{.SceneID}}">
+    <input type="hidden" name="ticket" value="{{.Ticket}}">
+    <button class="confirm" name="action" value="confirm" type="submit">确认登录</button>
+    <button class="reject" name="action" value="reject" type="submit">拒绝</button>
+  </form>
+  {{end}}
+</div>
+</body>
+</html>`))
+
+type loginConfirmPageData struct {
+	SystemName string
+	AppName    string
+	ClientIP   string
+	Location   string
+	CreatedAt  string
+	SceneID    string
+	Ticket     string
+	Message    string
+	Failed     bool
+}
+
+func renderLoginConfirmPage(c *gin.Context, status int, data loginConfirmPageData) {
+	data.SystemName = common.SystemName
+	c.Status(status)
+	c.Header("Content-Type", "text/html; charset=utf-8")
+	c.Header("Cache-Control", "no-store")
+	if err := loginConfirmPageTemplate.Execute(c.Writer, data); err != nil {
+		common.RequestError(c, "failed to render login confirm page: "+err.Error())
+	}
+}
+
+// getConfirmingSession returns the session only if the ticket is the one sent to the scanning user
+func getConfirmingSession(sceneID string, ticket string) *common.LoginSession {
+	session := common.GetSessionManager().GetSessionByScene(sceneID)
+	if session == nil || session.ConfirmTicket == "" ||
+		subtle.ConstantTimeCompare([]byte(session.ConfirmTicket), []byte(ticket)) != 1 {
+		return nil
+	}
+	return session
+}
+
+func loginConfirmStatusMessage(status common.LoginSessionStatus) string {
+	switch status {
+	case common.SessionStatusSuccess:
+		return "已确认登录，请返回网页继续操作"
+	case common.SessionStatusRejected:
+		return "已拒绝本次登录"
+	default:
+		return "登录请求已失效，请重新扫码"
+	}
+}
+
+func orUnknown(value string) string {
+	if value == "" {
+		return "未知"
+	}
+	return value
+}
+
+func GetLoginConfirm(c *gin.Context) {
+	session := getConfirmingSession(c.Query("scene"), c.Query("ticket"))
+	if session == nil {
+		renderLoginConfirmPage(c, http.StatusBadRequest, loginConfirmPageData{
+			Message: "登录请求无效或已过期，请重新扫码",
+			Failed:  true,
+		})
+		return
+	}
+	if session.Status != common.SessionStatusScanned {
+		renderLoginConfirmPage(c, http.StatusOK, loginConfirmPageData{
+			Message: loginConfirmStatusMessage(session.Status),
+		})
+		return
+	}
+	renderLoginConfirmPage(c, http.StatusOK, loginConfirmPageData{
+		AppName:   session.AppName,
+		ClientIP:  orUnknown(session.ClientIP),
+		Location:  session.Location,
+		CreatedAt: session.CreatedAt.Format("2006-01-02 15:04:05"),
+		SceneID:   session.SceneID,
+		Ticket:    session.ConfirmTicket,
+	})
+}
+
+func PostLoginConfirm(c *gin.Context) {
+	session := getConfirmingSession(c.PostForm("scene"), c.PostForm("ticket"))
+	if session == nil {
+		renderLoginConfirmPage(c, http.StatusBadRequest, loginConfirmPageData{
+			Message: "登录请求无效或已过期，请重新扫码",
+			Failed:  true,
+		})
+		return
+	}
+	if session.Status != common.SessionStatusScanned {
+		renderLoginConfirmPage(c, http.StatusOK, loginConfirmPageData{
+			Message: loginConfirmStatusMessage(session.Status),
+		})
+		return
+	}
+	manager := common.GetSessionManager()
+	var updated *common.LoginSession
+	switch c.PostForm("action") {
+	case "confirm":
+		updated = manager.ConfirmSession(session.LoginToken, session.WeChatID)
+	case "reject":
+		updated = manager.RejectSession(session.LoginToken, session.WeChatID)
+	default:
+		renderLoginConfirmPage(c, http.StatusBadRequest, loginConfirmPageData{
+			Message: "无效的操作",
+			Failed:  true,
+		})
+		return
+	}
+	if updated == nil {
+		renderLoginConfirmPage(c, http.StatusOK, loginConfirmPageData{
+			Message: "操作失败，请重新扫码",
+			Failed:  true,
+		})
+		return
+	}
+	// Logins through WeChat OAuth are confirmed in the browser which logs in, so it goes back to the client right away
+	if updated.Status == common.SessionStatusSuccess && updated.OAuthScope != "" && updated.RedirectURI != "" {
+		redirectWithAuthCode(c, http.StatusSeeOther, updated)
+		return
+	}
+	renderLoginConfirmPage(c, http.StatusOK, loginConfirmPageData{
+		Message: loginConfirmStatusMessage(updated.Status),
+	})
+}
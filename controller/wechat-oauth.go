@@ -0,0 +1,195 @@
+package controller
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"wechat-server/common"
+
+	"github.com/gin-gonic/gin"
+)
+
+// CreateOAuthURLRequest 设置 redirect_uri 时必须同时设置 client_id（API 客户端默认为自身），
+// 且 redirect_uri 需在该客户端中注册
+type CreateOAuthURLRequest struct {
+	Scope       string `json:"scope"`
+	ClientID    string `json:"client_id"`
+	RedirectURI string `json:"redirect_uri"`
+}
+
+type CreateOAuthURLResponse struct {
+	Success bool `json:"success"`
+	Data    struct {
+		SceneID       string `json:"scene_id"`
+		OAuthURL      string `json:"oauth_url"`
+		LoginToken    string `json:"login_token"`
+		ExpireSeconds int    `json:"expire_seconds"`
+	} `json:"data"`
+	Message string `json:"message"`
+}
+
+func isValidRedirectURI(redirectURI string) bool {
+	u, err := url.Parse(redirectURI)
+	if err != nil {
+		return false
+	}
+	return (u.Scheme == "http" || u.Scheme == "https") && u.Host != "" && u.Fragment == ""
+}
+
+// appendQuery 在跳转地址上追加参数，保留其原有的查询参数
+func appendQuery(redirectURI string, params map[string]string) string {
+	u, err := url.Parse(redirectURI)
+	if err != nil {
+		return redirectURI
+	}
+	query := u.Query()
+	for k, v := range params {
+		if v != "" {
+			query.Set(k, v)
+		}
+	}
+	u.RawQuery = query.Encode()
+	return u.String()
+}
+
+func CreateOAuthURL(c *gin.Context) {
+	var req CreateOAuthURLRequest
+	if c.Request.ContentLength != 0 {
+		if err := json.NewDecoder(c.Request.Body).Decode(&req); err != nil {
+			c.JSON(http.StatusBadRequest, CreateOAuthURLResponse{
+				Success: false,
+				Message: "无效的参数",
+			})
+			return
+		}
+	}
+	if req.Scope == "" {
+		req.Scope = common.WeChatOAuthScopeBase
+	}
+	if !common.IsValidWeChatOAuthScope(req.Scope) {
+		c.JSON(http.StatusBadRequest, CreateOAuthURLResponse{
+			Success: false,
+			Message: "scope 仅支持 snsapi_base 或 snsapi_userinfo",
+		})
+		return
+	}
+	// 设置 redirect_uri 时授权码跳转至该客户端，会话及授权码也绑定到该客户端
+	clientID := apiClientID(c)
+	if req.RedirectURI != "" {
+		client, message := getRedirectClient(c, req.ClientID, req.RedirectURI)
+		if client == nil {
+			c.JSON(http.StatusBadRequest, CreateOAuthURLResponse{
+				Success: false,
+				Message: message,
+			})
+			return
+		}
+		clientID = client.ClientId
+	}
+	if common.WeChatAppID == "" {
+		c.JSON(http.StatusInternalServerError, CreateOAuthURLResponse{
+			Success: false,
+			Message: "未配置微信公众号 AppID",
+		})
+		return
+	}
+	session := common.GetSessionManager().CreateSessionWithOptions(common.LoginSessionOptions{
+		OAuthScope:  req.Scope,
+		RedirectURI: req.RedirectURI,
+		ClientID:    clientID,
+	})
+	if session == nil {
+		c.JSON(http.StatusInternalServerError, CreateOAuthURLResponse{
+			Success: false,
+			Message: "创建登录会话失败",
+		})
+		return
+	}
+	callbackURL := strings.TrimSuffix(common.ServerAddress, "/") + "/api/wechat/oauth/callback"
+	response := CreateOAuthURLResponse{
+		Success: true,
+		Message: "授权链接创建成功",
+	}
+	response.Data.SceneID = session.SceneID
+	response.Data.OAuthURL = common.BuildWeChatOAuthURL(callbackURL, req.Scope, session.SceneID)
+	response.Data.LoginToken = session.LoginToken
+	response.Data.ExpireSeconds = int(session.ExpiredAt.Sub(session.CreatedAt).Seconds())
+	c.JSON(http.StatusOK, response)
+
+	common.RequestLog(c, fmt.Sprintf("Created OAuth login session: scene=%s, token=%s, scope=%s",
+		session.SceneID, session.LoginToken, req.Scope))
+}
+
+// WeChatOAuthCallback 微信网页授权回调，state 即登录会话的 scene_id
+func WeChatOAuthCallback(c *gin.Context) {
+	code := c.Query("code")
+	sceneID := c.Query("state")
+	if sceneID == "" {
+		c.String(http.StatusBadRequest, "无效的参数")
+		return
+	}
+	session := common.GetSessionManager().GetSessionByScene(sceneID)
+	if session == nil || session.OAuthScope == "" {
+		c.String(http.StatusOK, "登录链接无效或已过期，请重新发起登录")
+		return
+	}
+	if code == "" {
+		// 用户拒绝了 snsapi_userinfo 授权
+		c.String(http.StatusOK, "您已取消授权")
+		return
+	}
+	token, err := common.ExchangeWeChatOAuthCode(code)
+	if err != nil {
+		common.RequestError(c, "failed to exchange oauth code: "+err.Error())
+		c.String(http.StatusOK, "微信授权失败，请重试")
+		return
+	}
+	userInfo := &common.WeChatUserInfo{
+		OpenID:  token.OpenID,
+		UnionID: token.UnionID,
+	}
+	if strings.Contains(token.Scope, common.WeChatOAuthScopeUserInfo) {
+		userInfo, err = common.GetWeChatOAuthUserInfo(token)
+		if err != nil {
+			common.RequestError(c, "failed to get oauth user info: "+err.Error())
+			c.String(http.StatusOK, "获取微信用户信息失败，请重试")
+			return
+		}
+	}
+	if common.WeChatLoginConfirmEnabled {
+		// 与扫码登录相同，需用户在确认页确认后才算登录成功，确认页已在微信内打开，直接跳转即可
+		session = common.GetSessionManager().MarkSessionScanned(sceneID, token.OpenID, userInfo)
+		if session == nil {
+			c.String(http.StatusOK, "登录失败，请重新发起登录")
+			return
+		}
+		common.RequestLog(c, fmt.Sprintf("OAuth login awaiting confirmation: scene=%s, wechat=%s", sceneID, token.OpenID))
+		c.Redirect(http.StatusFound, appendQuery("/api/wechat/login/confirm", map[string]string{
+			"scene":  session.SceneID,
+			"ticket": session.ConfirmTicket,
+		}))
+		return
+	}
+	session = common.GetSessionManager().UpdateSessionByScene(sceneID, token.OpenID, userInfo)
+	if session == nil {
+		c.String(http.StatusOK, "登录失败，请重新发起登录")
+		return
+	}
+	common.RequestLog(c, fmt.Sprintf("OAuth login succeeded: scene=%s, wechat=%s", sceneID, token.OpenID))
+	if session.RedirectURI != "" {
+		redirectWithAuthCode(c, http.StatusFound, session)
+		return
+	}
+	c.String(http.StatusOK, "登录成功，请返回原页面继续操作")
+}
+
+// redirectWithAuthCode 网页授权登录成功后带上授权码跳转回客户端
+func redirectWithAuthCode(c *gin.Context, status int, session *common.LoginSession) {
+	c.Redirect(status, appendQuery(session.RedirectURI, map[string]string{
+		"code":  session.AuthCode,
+		"state": session.State,
+	}))
+}
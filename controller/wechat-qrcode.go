@@ -3,10 +3,14 @@ package controller
 import (
 	"bytes"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
+	"net/url"
+	"strings"
 
 	"wechat-server/common"
+	"wechat-server/model"
 
 	"github.com/gin-gonic/gin"
 )
@@ -17,11 +21,24 @@ type CreateQRCodeResponse struct {
 		SceneID       string `json:"scene_id"`
 		QRCodeURL     string `json:"qrcode_url"`
 		LoginToken    string `json:"login_token"`
+		LoginURL      string `json:"login_url,omitempty"`
 		ExpireSeconds int    `json:"expire_seconds"`
 	} `json:"data"`
 	Message string `json:"message"`
 }
 
+// CreateLoginQRCodeRequest 均为可选参数。app_name、client_ip、client_location 在开启扫码确认时
+// 展示给扫码用户，调用方应传入实际发起登录的终端用户的 IP 和位置；
+// 设置 redirect_uri 时必须同时设置 client_id，且 redirect_uri 需在该客户端中注册
+type CreateLoginQRCodeRequest struct {
+	AppName        string `json:"app_name"`
+	ClientIP       string `json:"client_ip"`
+	ClientLocation string `json:"client_location"`
+	ClientID       string `json:"client_id"`
+	RedirectURI    string `json:"redirect_uri"`
+	State          string `json:"state"`
+}
+
 type WeChatQRCodeRequest struct {
 	ExpireSeconds int    `json:"expire_seconds"`
 	ActionName    string `json:"action_name"`
@@ -40,75 +57,146 @@ type WeChatQRCodeResponse struct {
 	ErrorMessage  string `json:"errmsg,omitempty"`
 }
 
-func CreateLoginQRCode(c *gin.Context) {
-	session := common.GetSessionManager().CreateSession()
-	if session == nil {
-		c.JSON(http.StatusInternalServerError, CreateQRCodeResponse{
-			Success: false,
-			Message: "创建登录会话失败",
-		})
-		return
-	}
+// requestWeChatQRCode 调用微信接口创建带 scene 的临时二维码
+func requestWeChatQRCode(sceneID string) (*WeChatQRCodeResponse, error) {
 	accessToken := common.GetAccessToken()
 	if accessToken == "" {
-		c.JSON(http.StatusInternalServerError, CreateQRCodeResponse{
-			Success: false,
-			Message: "获取微信访问令牌失败",
-		})
-		return
+		return nil, errors.New("获取微信访问令牌失败")
 	}
 	qrReq := WeChatQRCodeRequest{
 		ExpireSeconds: 600, // 10分钟
 		ActionName:    "QR_STR_SCENE",
 	}
-	qrReq.ActionInfo.Scene.SceneStr = session.SceneID
+	qrReq.ActionInfo.Scene.SceneStr = sceneID
 
 	qrReqJSON, err := json.Marshal(qrReq)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, CreateQRCodeResponse{
-			Success: false,
-			Message: "构建二维码请求失败",
-		})
-		return
+		return nil, errors.New("构建二维码请求失败")
 	}
-	url := fmt.Sprintf("https://api.weixin.qq.com/cgi-bin/qrcode/create?access_token=%s", accessToken)
-	resp, err := http.Post(url, "application/json", bytes.NewBuffer(qrReqJSON))
+	apiURL := fmt.Sprintf("https://api.weixin.qq.com/cgi-bin/qrcode/create?access_token=%s", accessToken)
+	resp, err := http.Post(apiURL, "application/json", bytes.NewBuffer(qrReqJSON))
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, CreateQRCodeResponse{
-			Success: false,
-			Message: "调用微信API失败: " + err.Error(),
-		})
-		return
+		common.RecordWeChatAPICall("cgi-bin/qrcode/create", 0, err)
+		return nil, errors.New("调用微信API失败: " + err.Error())
 	}
 	defer resp.Body.Close()
 
 	var qrResp WeChatQRCodeResponse
-	if err := json.NewDecoder(resp.Body).Decode(&qrResp); err != nil {
+	err = json.NewDecoder(resp.Body).Decode(&qrResp)
+	common.RecordWeChatAPICall("cgi-bin/qrcode/create", qrResp.ErrorCode, err)
+	if err != nil {
+		return nil, errors.New("解析微信响应失败: " + err.Error())
+	}
+	if qrResp.ErrorCode != 0 {
+		return nil, fmt.Errorf("微信API错误: %s", qrResp.ErrorMessage)
+	}
+	return &qrResp, nil
+}
+
+func (r *WeChatQRCodeResponse) ImageURL() string {
+	return fmt.Sprintf("https://mp.weixin.qq.com/cgi-bin/showqrcode?ticket=%s", url.QueryEscape(r.Ticket))
+}
+
+// getRedirectClient 返回注册了 redirectURI 的客户端，失败时返回 nil 及原因；
+// API 客户端只能使用自身的回调地址，clientID 默认为自身
+func getRedirectClient(c *gin.Context, clientID string, redirectURI string) (*model.Client, string) {
+	if clientId := c.GetString("clientId"); clientId != "" {
+		if clientID == "" {
+			clientID = clientId
+		}
+		if clientID != clientId {
+			return nil, "client_id 与当前客户端不一致"
+		}
+	}
+	if clientID == "" {
+		return nil, "无效的 client_id"
+	}
+	client, err := model.GetClientByClientId(clientID)
+	if err != nil || client.Status != common.ClientStatusEnabled {
+		return nil, "无效的 client_id"
+	}
+	if !client.AllowsRedirectURI(redirectURI) {
+		return nil, "redirect_uri 未在该客户端中注册"
+	}
+	return client, ""
+}
+
+func CreateLoginQRCode(c *gin.Context) {
+	var req CreateLoginQRCodeRequest
+	if c.Request.ContentLength != 0 {
+		if err := json.NewDecoder(c.Request.Body).Decode(&req); err != nil {
+			c.JSON(http.StatusBadRequest, CreateQRCodeResponse{
+				Success: false,
+				Message: "无效的参数",
+			})
+			return
+		}
+	}
+	// 设置 redirect_uri 时授权码跳转至该客户端，会话及授权码也绑定到该客户端
+	clientID := apiClientID(c)
+	if req.RedirectURI != "" {
+		client, message := getRedirectClient(c, req.ClientID, req.RedirectURI)
+		if client == nil {
+			c.JSON(http.StatusBadRequest, CreateQRCodeResponse{
+				Success: false,
+				Message: message,
+			})
+			return
+		}
+		clientID = client.ClientId
+		if req.AppName == "" {
+			req.AppName = client.Name
+		}
+	}
+	if req.AppName == "" {
+		req.AppName = common.SystemName
+	}
+	manager := common.GetSessionManager()
+	session := manager.CreateSessionWithOptions(common.LoginSessionOptions{
+		RedirectURI: req.RedirectURI,
+		ClientID:    clientID,
+		State:       req.State,
+		AppName:     req.AppName,
+		ClientIP:    req.ClientIP,
+		Location:    req.ClientLocation,
+	})
+	if session == nil {
 		c.JSON(http.StatusInternalServerError, CreateQRCodeResponse{
 			Success: false,
-			Message: "解析微信响应失败: " + err.Error(),
+			Message: "创建登录会话失败",
 		})
 		return
 	}
-	if qrResp.ErrorCode != 0 {
+	qrResp, err := requestWeChatQRCode(session.SceneID)
+	if err != nil {
 		c.JSON(http.StatusInternalServerError, CreateQRCodeResponse{
 			Success: false,
-			Message: fmt.Sprintf("微信API错误: %s", qrResp.ErrorMessage),
+			Message: err.Error(),
 		})
 		return
 	}
-	qrcodeURL := fmt.Sprintf("https://mp.weixin.qq.com/cgi-bin/showqrcode?ticket=%s", qrResp.Ticket)
 	response := CreateQRCodeResponse{
 		Success: true,
 		Message: "二维码创建成功",
 	}
 	response.Data.SceneID = session.SceneID
-	response.Data.QRCodeURL = qrcodeURL
+	response.Data.QRCodeURL = qrResp.ImageURL()
 	response.Data.LoginToken = session.LoginToken
 	response.Data.ExpireSeconds = qrResp.ExpireSeconds
+	if session.RedirectURI != "" {
+		if !manager.SetSessionQRCode(session.LoginToken, response.Data.QRCodeURL) {
+			c.JSON(http.StatusInternalServerError, CreateQRCodeResponse{
+				Success: false,
+				Message: "创建登录会话失败",
+			})
+			return
+		}
+		response.Data.LoginURL = strings.TrimSuffix(common.ServerAddress, "/") +
+			"/api/wechat/login?login_token=" + url.QueryEscape(session.LoginToken)
+	}
 
 	c.JSON(http.StatusOK, response)
 
-	common.SysLog(fmt.Sprintf("Created login QRCode: scene=%s, token=%s, ticket=%s",
+	common.RequestLog(c, fmt.Sprintf("Created login QRCode: scene=%s, token=%s, ticket=%s",
 		session.SceneID, session.LoginToken, qrResp.Ticket))
 }
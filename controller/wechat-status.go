@@ -1,12 +1,21 @@
 package controller
 
 import (
+	"context"
 	"fmt"
 	"net/http"
+	"strconv"
+	"time"
 
 	"wechat-server/common"
 
 	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+)
+
+const (
+	loginStatusMaxWaitSeconds  = 60
+	loginStatusStreamKeepAlive = 15 * time.Second
 )
 
 type LoginStatusResponse struct {
@@ -19,6 +28,43 @@ type LoginStatusResponse struct {
 	Message string `json:"message"`
 }
 
+// buildLoginStatusResponse A nil session is reported as expired,
+// the auth code is only revealed to the client which created the session.
+func buildLoginStatusResponse(session *common.LoginSession, clientID string) LoginStatusResponse {
+	response := LoginStatusResponse{
+		Success: true,
+		Message: "查询成功",
+	}
+	if session == nil {
+		response.Data.Status = string(common.SessionStatusExpired)
+		return response
+	}
+	response.Data.Status = string(session.Status)
+	if session.Status == common.SessionStatusSuccess && session.UserInfo != nil {
+		response.Data.WeChatUser = session.UserInfo
+		if session.ClientID == clientID {
+			response.Data.AuthCode = session.AuthCode
+		}
+	}
+	return response
+}
+
+// heldRequestContext is canceled as well when the server begins to shut down, so requests held open
+// for status changes return early instead of delaying the shutdown
+func heldRequestContext(c *gin.Context) (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(c.Request.Context())
+	go func() {
+		select {
+		case <-common.Stopping():
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+	return ctx, cancel
+}
+
+// GetLoginStatus With wait=<seconds> the request is held until the status differs from
+// the status parameter (the current status by default), for clients which can't keep a stream open.
 func GetLoginStatus(c *gin.Context) {
 	loginToken := c.Query("login_token")
 	if loginToken == "" {
@@ -36,18 +82,126 @@ func GetLoginStatus(c *gin.Context) {
 		})
 		return
 	}
-	response := LoginStatusResponse{
-		Success: true,
-		Message: "查询成功",
+	wait, _ := strconv.Atoi(c.Query("wait"))
+	if wait > 0 {
+		if wait > loginStatusMaxWaitSeconds {
+			wait = loginStatusMaxWaitSeconds
+		}
+		lastStatus := session.Status
+		if status := c.Query("status"); status != "" {
+			lastStatus = common.LoginSessionStatus(status)
+		}
+		if session.Status == lastStatus {
+			ctx, cancel := heldRequestContext(c)
+			session = common.GetSessionManager().WaitForChange(ctx, loginToken, lastStatus, time.Duration(wait)*time.Second)
+			cancel()
+		}
 	}
+	response := buildLoginStatusResponse(session, apiClientID(c))
+	c.JSON(http.StatusOK, response)
 
-	response.Data.Status = string(session.Status)
-	if session.Status == common.SessionStatusSuccess && session.UserInfo != nil {
-		response.Data.WeChatUser = session.UserInfo
-		response.Data.AuthCode = session.AuthCode
+	common.SysDebug(fmt.Sprintf("Login status query: token=%s, status=%s", loginToken, response.Data.Status))
+}
+
+// streamLoginStatus calls send with the current status and then on every transition,
+// until the session reaches a final status or the client goes away.
+func streamLoginStatus(ctx context.Context, loginToken string, clientID string, send func(response LoginStatusResponse) error, keepAlive func() error) {
+	manager := common.GetSessionManager()
+	session := manager.GetSession(loginToken)
+	for {
+		response := buildLoginStatusResponse(session, clientID)
+		if err := send(response); err != nil {
+			return
+		}
+		if session == nil || session.Status.IsFinal() {
+			return
+		}
+		lastStatus := session.Status
+		for {
+			session = manager.WaitForChange(ctx, loginToken, lastStatus, loginStatusStreamKeepAlive)
+			if ctx.Err() != nil {
+				return
+			}
+			if session != nil && session.Status == lastStatus {
+				if err := keepAlive(); err != nil {
+					return
+				}
+				continue
+			}
+			break
+		}
 	}
+}
 
-	c.JSON(http.StatusOK, response)
+// GetLoginStatusStream pushes status transitions with Server-Sent Events
+func GetLoginStatusStream(c *gin.Context) {
+	loginToken := c.Query("login_token")
+	if loginToken == "" {
+		c.JSON(http.StatusBadRequest, LoginStatusResponse{
+			Success: false,
+			Message: "缺少login_token参数",
+		})
+		return
+	}
+	c.Header("Content-Type", "text/event-stream")
+	c.Header("Cache-Control", "no-cache")
+	c.Header("Connection", "keep-alive")
+	c.Header("X-Accel-Buffering", "no")
+	ctx, cancel := heldRequestContext(c)
+	defer cancel()
+	streamLoginStatus(ctx, loginToken, apiClientID(c), func(response LoginStatusResponse) error {
+		c.SSEvent("status", response)
+		c.Writer.Flush()
+		return ctx.Err()
+	}, func() error {
+		_, err := c.Writer.WriteString(": keep-alive\n\n")
+		c.Writer.Flush()
+		return err
+	})
+}
 
-	common.SysLog(fmt.Sprintf("Login status query: token=%s, status=%s", loginToken, session.Status))
+var loginStatusUpgrader = websocket.Upgrader{
+	// Authentication is done by the Authorization header rather than cookies, so cross-origin is fine
+	CheckOrigin: func(r *http.Request) bool {
+		return true
+	},
+}
+
+// GetLoginStatusWebSocket pushes status transitions over a WebSocket connection
+func GetLoginStatusWebSocket(c *gin.Context) {
+	loginToken := c.Query("login_token")
+	if loginToken == "" {
+		c.JSON(http.StatusBadRequest, LoginStatusResponse{
+			Success: false,
+			Message: "缺少login_token参数",
+		})
+		return
+	}
+	conn, err := loginStatusUpgrader.Upgrade(c.Writer, c.Request, nil)
+	if err != nil {
+		common.RequestError(c, "failed to upgrade websocket: "+err.Error())
+		return
+	}
+	defer conn.Close()
+	// Drain incoming frames so control messages are processed and a closed connection is noticed
+	ctx, cancel := heldRequestContext(c)
+	defer cancel()
+	go func() {
+		defer cancel()
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}()
+	streamLoginStatus(ctx, loginToken, apiClientID(c), func(response LoginStatusResponse) error {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		return conn.WriteJSON(response)
+	}, func() error {
+		return conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(5*time.Second))
+	})
+	_ = conn.WriteControl(websocket.CloseMessage,
+		websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""), time.Now().Add(5*time.Second))
 }
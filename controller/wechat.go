@@ -4,6 +4,8 @@ import (
 	"crypto/sha1"
 	"encoding/hex"
 	"encoding/xml"
+	"fmt"
+	"net"
 	"net/http"
 	"sort"
 	"strings"
@@ -40,7 +42,7 @@ func ProcessWeChatMessage(c *gin.Context) {
 	var req common.WeChatMessageRequest
 	err := xml.NewDecoder(c.Request.Body).Decode(&req)
 	if err != nil {
-		common.SysError(err.Error())
+		common.RequestError(c, err.Error())
 		c.Abort()
 		return
 	}
@@ -51,6 +53,7 @@ func ProcessWeChatMessage(c *gin.Context) {
 		MsgType:      "text",
 		Content:      "",
 	}
+	common.RecordWeChatCallback(req.MsgType, req.Event)
 	common.ProcessWeChatMessage(&req, &res)
 	if res.Content == "" {
 		c.String(http.StatusOK, "")
@@ -59,16 +62,30 @@ func ProcessWeChatMessage(c *gin.Context) {
 	c.XML(http.StatusOK, &res)
 }
 
+// GetUserIDByCode client_ip is the IP of the end user, failed attempts are limited per caller and end user,
+// so the users mistyping codes can't lock out the other users of the same caller.
+// As client_ip is up to the caller, the failed attempts of the caller are limited as well.
 func GetUserIDByCode(c *gin.Context) {
 	code := c.Query("code")
-	if code == "" {
+	clientIP := c.Query("client_ip")
+	if clientIP == "" {
+		clientIP = c.ClientIP()
+	}
+	if code == "" || net.ParseIP(clientIP) == nil {
 		c.JSON(http.StatusOK, gin.H{
 			"message": "无效的参数",
 			"success": false,
 		})
 		return
 	}
-	id := common.GetWeChatIDByCode(code)
+	id, err := common.GetWeChatIDByCode(code, apiClientID(c), clientIP)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"message": err.Error(),
+			"success": false,
+		})
+		return
+	}
 	c.JSON(http.StatusOK, gin.H{
 		"message": "",
 		"success": true,
@@ -77,17 +94,83 @@ func GetUserIDByCode(c *gin.Context) {
 	return
 }
 
-func GetUserID(c *gin.Context) {
-	wechatID, err := common.FindWeChatIDByAuthCode(c)
+// apiClientID identifies the caller of /api/wechat, login sessions and their auth codes are bound to it.
+// API clients are identified by their client_id, legacy admin tokens by the user id.
+func apiClientID(c *gin.Context) string {
+	if clientId := c.GetString("clientId"); clientId != "" {
+		return clientId
+	}
+	return fmt.Sprintf("user:%d", c.GetInt("id"))
+}
+
+type ExchangeAuthCodeRequest struct {
+	Code string `json:"code" form:"code"`
+}
+
+func authCodeErrorResponse(c *gin.Context, err error) {
+	authCodeErr, ok := err.(*common.AuthCodeError)
+	if !ok {
+		common.RequestError(c, "failed to exchange auth code: "+err.Error())
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"success": false,
+			"message": "服务器内部错误",
+			"error":   "server_error",
+		})
+		return
+	}
+	status := http.StatusBadRequest
+	if authCodeErr == common.ErrAuthCodeClientMismatch {
+		status = http.StatusForbidden
+	}
+	c.JSON(status, gin.H{
+		"success": false,
+		"message": authCodeErr.Message,
+		"error":   authCodeErr.Code,
+	})
+}
+
+// ExchangeAuthCode The auth code can be exchanged only once, by the client which created the login session
+func ExchangeAuthCode(c *gin.Context) {
+	var req ExchangeAuthCodeRequest
+	if err := c.ShouldBind(&req); err != nil || req.Code == "" {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"message": "无效的参数",
+			"error":   "invalid_request",
+		})
+		return
+	}
+	grant, err := common.GetSessionManager().ExchangeAuthCode(req.Code, apiClientID(c))
 	if err != nil {
-		// 如果新的登录方式失败，尝试使用旧的验证码登录方式
+		authCodeErrorResponse(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data": gin.H{
+			"wechat_id":   grant.WeChatID,
+			"wechat_user": grant.UserInfo,
+		},
+	})
+}
+
+func GetUserID(c *gin.Context) {
+	code := c.Query("code")
+	grant, err := common.GetSessionManager().ExchangeAuthCode(code, apiClientID(c))
+	if err == common.ErrAuthCodeInvalid {
+		// 如果不是扫码登录的授权码，尝试使用旧的验证码登录方式
 		GetUserIDByCode(c)
 		return
 	}
+	if err != nil {
+		authCodeErrorResponse(c, err)
+		return
+	}
 	c.JSON(http.StatusOK, gin.H{
 		"message": "",
 		"success": true,
-		"data":    wechatID,
+		"data":    grant.WeChatID,
 	})
 	return
 }
@@ -1,14 +1,18 @@
 package main
 
 import (
+	"context"
 	"embed"
+	"fmt"
 	"github.com/gin-contrib/sessions"
 	"github.com/gin-contrib/sessions/cookie"
 	"github.com/gin-contrib/sessions/redis"
 	"github.com/gin-gonic/gin"
-	"log"
+	"net/http"
 	"os"
+	"os/signal"
 	"strconv"
+	"syscall"
 	"wechat-server/common"
 	"wechat-server/middleware"
 	"wechat-server/model"
@@ -22,6 +26,7 @@ var buildFS embed.FS
 var indexPage []byte
 
 func main() {
+	common.Init()
 	common.SetupGinLog()
 	common.SysLog("system started")
 	if os.Getenv("GIN_MODE") != "debug" {
@@ -39,20 +44,50 @@ func main() {
 		}
 	}()
 
+	if common.Command == common.CommandRotateKey {
+		count, err := model.RotateOptionMasterKey()
+		if err != nil {
+			common.FatalLog(err)
+		}
+		common.SysLog(fmt.Sprintf("re-encrypted %d options with the current master key", count))
+		return
+	}
+
 	// Initialize Redis
 	err = common.InitRedisClient()
 	if err != nil {
 		common.FatalLog(err)
 	}
 
+	// Initialize login session storage
+	common.InitLoginSessionManager()
+	common.InitVerificationStore()
+
 	// Initialize options
-	model.InitOptionMap()
+	err = model.InitOptionMap()
+	if err != nil {
+		common.FatalLog(err)
+	}
+	err = model.InitOIDCSigningKey()
+	if err != nil {
+		common.FatalLog(err)
+	}
+	model.StartOptionSync()
+	model.StartAPITokenCacheSync()
 
 	// Initialize access token store
 	common.InitAccessTokenStore()
 
 	// Initialize HTTP server
-	server := gin.Default()
+	server := gin.New()
+	// gin trusts every proxy by default, which lets any client choose its IP with X-Forwarded-For
+	if err := server.SetTrustedProxies(common.Config.TrustedProxies); err != nil {
+		common.FatalLog("failed to set trusted proxies: " + err.Error())
+	}
+	server.Use(middleware.RequestId())
+	server.Use(middleware.AccessLog())
+	server.Use(gin.Recovery())
+	server.Use(middleware.Metrics())
 	server.Use(middleware.CORS())
 
 	// Initialize session store
@@ -66,10 +101,7 @@ func main() {
 	}
 
 	router.SetRouter(server, buildFS, indexPage)
-	var port = os.Getenv("PORT")
-	if port == "" {
-		port = strconv.Itoa(*common.Port)
-	}
+	port := strconv.Itoa(common.Config.Port)
 	//if *common.Host == "localhost" {
 	//	ip := common.GetIp()
 	//	if ip != "" {
@@ -80,8 +112,41 @@ func main() {
 	//if !*common.NoBrowser {
 	//	common.OpenBrowser(serverUrl)
 	//}
-	err = server.Run(":" + port)
-	if err != nil {
-		log.Println(err)
+	srv := &http.Server{
+		Addr:    ":" + port,
+		Handler: server,
+	}
+	serveErr := make(chan error, 1)
+	go func() {
+		serveErr <- srv.ListenAndServe()
+	}()
+	quit := make(chan os.Signal, 1)
+	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
+	select {
+	case sig := <-quit:
+		common.SysLog("received " + sig.String() + ", shutting down")
+	case err := <-serveErr:
+		common.SysError("failed to start HTTP server: " + err.Error())
+	}
+	shutdown(srv)
+}
+
+// shutdown drains the HTTP requests first, then stops the background workers and waits for the async tasks,
+// the database is closed after it returns
+func shutdown(srv *http.Server) {
+	ctx, cancel := context.WithTimeout(context.Background(), common.ShutdownTimeout)
+	defer cancel()
+	common.BeginShutdown()
+	if err := srv.Shutdown(ctx); err != nil {
+		common.SysError("failed to drain HTTP requests: " + err.Error())
+	}
+	if err := common.StopWorkers(ctx); err != nil {
+		common.SysError("failed to wait for background workers: " + err.Error())
+	}
+	if common.RedisEnabled {
+		if err := common.RDB.Close(); err != nil {
+			common.SysError("failed to close Redis client: " + err.Error())
+		}
 	}
+	common.SysLog("server stopped")
 }
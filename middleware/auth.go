@@ -4,18 +4,41 @@ import (
 	"github.com/gin-contrib/sessions"
 	"github.com/gin-gonic/gin"
 	"net/http"
+	"strings"
 	"wechat-server/common"
 	"wechat-server/model"
 )
 
 func authHelper(c *gin.Context, minRole int) {
+	if authenticateUser(c, minRole) {
+		c.Next()
+	}
+}
+
+// authenticateUser sets the user into the context, or aborts the request and returns false
+func authenticateUser(c *gin.Context, minRole int) bool {
 	session := sessions.Default(c)
-	username := session.Get("username")
-	role := session.Get("role")
-	id := session.Get("id")
-	status := session.Get("status")
+	var username string
+	var role, id, status int
 	authByToken := false
-	if username == nil {
+	key, _ := session.Get(common.UserSessionKey).(string)
+	if key != "" {
+		userSession, user := model.ValidateUserSession(key, c.ClientIP())
+		if userSession == nil {
+			c.JSON(http.StatusOK, gin.H{
+				"success": false,
+				"message": "登录已失效，请重新登录",
+			})
+			c.Abort()
+			return false
+		}
+		// The role and status are read from the database, so changes take effect immediately
+		username = user.Username
+		role = user.Role
+		id = user.Id
+		status = user.Status
+		c.Set("sessionId", userSession.Id)
+	} else {
 		// Check token
 		token := c.Request.Header.Get("Authorization")
 		if token == "" {
@@ -24,46 +47,65 @@ func authHelper(c *gin.Context, minRole int) {
 				"message": "无权进行此操作，未登录或 token 无效",
 			})
 			c.Abort()
-			return
+			return false
 		}
-		user := model.ValidateUserToken(token)
+		apiToken, user, permissions := model.ValidateAPIToken(token, c.ClientIP())
 		if user != nil && user.Username != "" {
 			// Token is valid
 			username = user.Username
 			role = user.Role
 			id = user.Id
 			status = user.Status
+			c.Set("apiToken", apiToken)
+			c.Set("permissions", permissions)
 		} else {
 			c.JSON(http.StatusOK, gin.H{
 				"success": false,
 				"message": "无权进行此操作，未登录或 token 无效",
 			})
 			c.Abort()
-			return
+			return false
 		}
 		authByToken = true
 	}
-	if status.(int) == common.UserStatusDisabled {
+	if status == common.UserStatusDisabled {
 		c.JSON(http.StatusOK, gin.H{
 			"success": false,
 			"message": "用户已被封禁",
 		})
 		c.Abort()
-		return
+		return false
 	}
-	if role.(int) < minRole {
+	if !authByToken && model.IsTwoFactorRequired(role) && !isTwoFactorSetupRequest(c) && !model.IsTwoFactorEnabled(id) {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": "请先在个人设置中启用两步验证",
+		})
+		c.Abort()
+		return false
+	}
+	if role < minRole {
 		c.JSON(http.StatusOK, gin.H{
 			"success": false,
 			"message": "无权进行此操作，未登录或 token 无效，或没有权限",
 		})
 		c.Abort()
-		return
+		return false
 	}
 	c.Set("username", username)
 	c.Set("role", role)
 	c.Set("id", id)
 	c.Set("authByToken", authByToken)
-	c.Next()
+	return true
+}
+
+// isTwoFactorSetupRequest tells whether the request is allowed before the required two-factor authentication is enabled
+func isTwoFactorSetupRequest(c *gin.Context) bool {
+	path := c.FullPath()
+	if strings.HasPrefix(path, "/api/user/self/2fa") {
+		return true
+	}
+	return c.Request.Method == http.MethodGet && (path == "/api/user/self" || path == "/api/user/self/permissions")
 }
 
 func UserAuth() func(c *gin.Context) {
@@ -72,16 +114,56 @@ func UserAuth() func(c *gin.Context) {
 	}
 }
 
-func AdminAuth() func(c *gin.Context) {
+// RequirePermission checks the permissions granted by the roles of the user.
+// You should always use this after normal auth middlewares.
+func RequirePermission(permission string) func(c *gin.Context) {
 	return func(c *gin.Context) {
-		authHelper(c, common.RoleAdminUser)
+		if authorizeUser(c, permission) {
+			c.Next()
+		}
+	}
+}
+
+// authorizeUser Token authenticated requests use the permissions cached with the token
+func authorizeUser(c *gin.Context, permission string) bool {
+	var granted bool
+	if permissions, ok := c.Get("permissions"); ok {
+		granted = model.HasPermission(permissions.([]string), permission)
+	} else {
+		granted = model.UserHasPermission(c.GetInt("id"), c.GetInt("role"), permission)
+	}
+	if !granted {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": "无权进行此操作，缺少权限：" + permission,
+		})
+		c.Abort()
+		return false
 	}
+	return true
 }
 
-func RootAuth() func(c *gin.Context) {
+// RequireTokenScope checks the scopes of the API token, requests authenticated by session pass.
+// You should always use this after normal auth middlewares.
+func RequireTokenScope(scope string) func(c *gin.Context) {
 	return func(c *gin.Context) {
-		authHelper(c, common.RoleRootUser)
+		if checkTokenScope(c, scope) {
+			c.Next()
+		}
+	}
+}
+
+func checkTokenScope(c *gin.Context, scope string) bool {
+	apiToken, ok := c.Get("apiToken")
+	if ok && !apiToken.(*model.APIToken).HasScope(scope) {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": "无权进行此操作，令牌缺少权限：" + scope,
+		})
+		c.Abort()
+		return false
 	}
+	return true
 }
 
 // NoTokenAuth You should always use this after normal auth middlewares.
@@ -0,0 +1,71 @@
+package middleware
+
+import (
+	"net/http"
+	"strings"
+	"wechat-server/common"
+	"wechat-server/model"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ClientAuth authenticates API clients with HTTP Basic auth (client_id:client_secret),
+// requests without Basic credentials fall back to the token of a user with the wechat.api permission for compatibility.
+func ClientAuth() func(c *gin.Context) {
+	return func(c *gin.Context) {
+		if !strings.HasPrefix(c.Request.Header.Get("Authorization"), "Basic ") {
+			if !authenticateUser(c, common.RoleCommonUser) || !authorizeUser(c, common.PermissionWeChatAPI) {
+				return
+			}
+			if !c.GetBool("authByToken") {
+				c.JSON(http.StatusOK, gin.H{
+					"success": false,
+					"message": "本接口仅支持使用 token 进行验证",
+				})
+				c.Abort()
+				return
+			}
+			c.Next()
+			return
+		}
+		clientId, secret, _ := c.Request.BasicAuth()
+		client := model.ValidateClientCredentials(clientId, secret)
+		if client == nil {
+			c.JSON(http.StatusOK, gin.H{
+				"success": false,
+				"message": "无权进行此操作，client_id 或 client_secret 无效",
+			})
+			c.Abort()
+			return
+		}
+		if !client.AllowsIP(c.ClientIP()) {
+			c.JSON(http.StatusOK, gin.H{
+				"success": false,
+				"message": "无权进行此操作，IP 不在该客户端的白名单中",
+			})
+			c.Abort()
+			return
+		}
+		c.Set("client", client)
+		c.Set("clientId", client.ClientId)
+		c.Next()
+	}
+}
+
+// RequireClientScope You should always use this after ClientAuth, user tokens are limited to their own scopes.
+func RequireClientScope(scope string) func(c *gin.Context) {
+	return func(c *gin.Context) {
+		client, ok := c.Get("client")
+		if ok && !client.(*model.Client).HasScope(scope) {
+			c.JSON(http.StatusOK, gin.H{
+				"success": false,
+				"message": "无权进行此操作，客户端缺少权限：" + scope,
+			})
+			c.Abort()
+			return
+		}
+		if checkTokenScope(c, scope) {
+			c.Next()
+		}
+	}
+}
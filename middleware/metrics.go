@@ -0,0 +1,36 @@
+package middleware
+
+import (
+	"crypto/subtle"
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"strings"
+	"time"
+	"wechat-server/common"
+)
+
+// Metrics observes the requests by route pattern, use it before the routes are matched
+func Metrics() func(c *gin.Context) {
+	return func(c *gin.Context) {
+		start := time.Now()
+		c.Next()
+		common.ObserveHTTPRequest(c.Request.Method, c.FullPath(), c.Writer.Status(), time.Since(start))
+	}
+}
+
+// MetricsAuth requires the bearer token METRICS_TOKEN if it's set, otherwise the metrics are public
+func MetricsAuth() func(c *gin.Context) {
+	return func(c *gin.Context) {
+		if common.MetricsToken == "" {
+			c.Next()
+			return
+		}
+		token := strings.TrimPrefix(c.Request.Header.Get("Authorization"), "Bearer ")
+		if subtle.ConstantTimeCompare([]byte(token), []byte(common.MetricsToken)) != 1 {
+			c.Status(http.StatusUnauthorized)
+			c.Abort()
+			return
+		}
+		c.Next()
+	}
+}
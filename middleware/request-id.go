@@ -0,0 +1,52 @@
+package middleware
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"regexp"
+	"time"
+	"wechat-server/common"
+)
+
+const requestIdHeader = "X-Request-Id"
+
+// An id from a proxy in front of us is kept if it looks sane, so it can be traced across services
+var requestIdPattern = regexp.MustCompile(`^[0-9A-Za-z._-]{1,64}$`)
+
+// RequestId assigns an id to each request and returns it in the X-Request-Id header, use it before anything else
+func RequestId() func(c *gin.Context) {
+	return func(c *gin.Context) {
+		id := c.GetHeader(requestIdHeader)
+		if !requestIdPattern.MatchString(id) {
+			id = uuid.New().String()
+		}
+		c.Set(common.RequestIdKey, id)
+		c.Header(requestIdHeader, id)
+		c.Next()
+	}
+}
+
+// AccessLog replaces the logger of gin, the entries are structured and carry the request id.
+// The query string is left out since it may contain codes and tokens.
+func AccessLog() func(c *gin.Context) {
+	return func(c *gin.Context) {
+		start := time.Now()
+		c.Next()
+		fields := common.Fields{
+			"request_id": c.GetString(common.RequestIdKey),
+			"method":     c.Request.Method,
+			"path":       c.Request.URL.Path,
+			"status":     c.Writer.Status(),
+			"latency_ms": time.Since(start).Milliseconds(),
+			"ip":         c.ClientIP(),
+		}
+		if len(c.Errors) > 0 {
+			fields["errors"] = c.Errors.String()
+		}
+		level := common.LogLevelInfo
+		if c.Writer.Status() >= 500 {
+			level = common.LogLevelError
+		}
+		common.LogWithFields(level, "request", fields)
+	}
+}
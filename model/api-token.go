@@ -0,0 +1,337 @@
+package model
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"wechat-server/common"
+
+	"gorm.io/gorm"
+)
+
+// APIToken is a named token of a user for calling the API, only the SHA-256 of the token is stored
+type APIToken struct {
+	Id           int    `json:"id"`
+	UserId       int    `json:"user_id" gorm:"index"`
+	Name         string `json:"name"`
+	Hash         string `json:"-" gorm:"uniqueIndex;size:64"`
+	Prefix       string `json:"prefix"`                     // the first characters of the token to tell tokens apart
+	Scopes       string `json:"scopes"`                     // space separated, see common.TokenScopes
+	ExpiredTime  int64  `json:"expired_time" gorm:"bigint"` // 0 means never
+	LastUsedTime int64  `json:"last_used_time" gorm:"bigint"`
+	LastUsedIP   string `json:"last_used_ip"`
+	CreatedTime  int64  `json:"created_time" gorm:"bigint"`
+}
+
+const apiTokenPrefixLength = 8
+
+// Validated tokens are cached with their user and the permissions of the user, so token authenticated requests
+// don't hit the database each time, the last used time is written back at most once per apiTokenLastUsedInterval.
+// Unknown tokens are cached for a shorter time, up to apiTokenUnknownCacheSize of them.
+// With Redis enabled, revoked tokens and changed users are dropped from the caches of all instances.
+const (
+	apiTokenCacheDuration        = time.Minute
+	apiTokenUnknownCacheDuration = 10 * time.Second
+	apiTokenUnknownCacheSize     = 10000
+	apiTokenLastUsedInterval     = int64(60)
+	apiTokenInvalidationChannel  = "apiTokens:invalidated"
+)
+
+type apiTokenCacheEntry struct {
+	token       APIToken
+	user        User
+	permissions []string
+	expiredAt   time.Time
+}
+
+var apiTokenCache = struct {
+	sync.Mutex
+	entries map[string]*apiTokenCacheEntry
+	unknown map[string]time.Time // the hashes not found and when they expire
+}{entries: make(map[string]*apiTokenCacheEntry), unknown: make(map[string]time.Time)}
+
+var ErrAPITokenLimit = errors.New("令牌数量已达上限，请先删除不再使用的令牌")
+
+func apiTokenPrefix(key string) string {
+	if len(key) <= apiTokenPrefixLength {
+		return key
+	}
+	return key[:apiTokenPrefixLength]
+}
+
+func (token *APIToken) GetScopes() []string {
+	return strings.Fields(token.Scopes)
+}
+
+func (token *APIToken) HasScope(scope string) bool {
+	for _, s := range token.GetScopes() {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+func (token *APIToken) IsExpired() bool {
+	return token.ExpiredTime != 0 && token.ExpiredTime <= time.Now().Unix()
+}
+
+func GetUserAPITokens(userId int) (tokens []*APIToken, err error) {
+	err = DB.Where("user_id = ?", userId).Order("id desc").Find(&tokens).Error
+	return tokens, err
+}
+
+// Insert stores the hash of the plaintext key, which can't be recovered afterwards
+func (token *APIToken) Insert(key string) error {
+	var count int64
+	if err := DB.Model(&APIToken{}).Where("user_id = ?", token.UserId).Count(&count).Error; err != nil {
+		return err
+	}
+	if count >= int64(common.MaxAPITokensPerUser) {
+		return ErrAPITokenLimit
+	}
+	token.Hash = common.Secret2Hash(key)
+	token.Prefix = apiTokenPrefix(key)
+	token.CreatedTime = time.Now().Unix()
+	if err := DB.Create(token).Error; err != nil {
+		return err
+	}
+	// In case the token was tried before being created
+	uncacheAPIToken(token.Hash)
+	return nil
+}
+
+// DeleteUserAPIToken revokes the token, the token is also dropped from the caches
+func DeleteUserAPIToken(userId int, id int) error {
+	token := APIToken{}
+	if err := DB.First(&token, "id = ? AND user_id = ?", id, userId).Error; err != nil {
+		return err
+	}
+	if err := DB.Delete(&token).Error; err != nil {
+		return err
+	}
+	uncacheAPIToken(token.Hash)
+	return nil
+}
+
+func DeleteUserAPITokens(userId int) error {
+	var tokens []*APIToken
+	if err := DB.Where("user_id = ?", userId).Find(&tokens).Error; err != nil {
+		return err
+	}
+	if err := DB.Where("user_id = ?", userId).Delete(&APIToken{}).Error; err != nil {
+		return err
+	}
+	InvalidateUserAPITokens(userId)
+	return nil
+}
+
+func uncacheAPIToken(hash string) {
+	uncacheLocalAPIToken(hash)
+	publishAPITokenInvalidation("token:" + hash)
+}
+
+func uncacheLocalAPIToken(hash string) {
+	apiTokenCache.Lock()
+	delete(apiTokenCache.entries, hash)
+	delete(apiTokenCache.unknown, hash)
+	apiTokenCache.Unlock()
+}
+
+// cacheUnknownAPIToken Expired hashes are dropped once the cache is full, nothing is cached if it's still full
+func cacheUnknownAPIToken(hash string, now time.Time) {
+	apiTokenCache.Lock()
+	defer apiTokenCache.Unlock()
+	if len(apiTokenCache.unknown) >= apiTokenUnknownCacheSize {
+		for h, expiredAt := range apiTokenCache.unknown {
+			if now.After(expiredAt) {
+				delete(apiTokenCache.unknown, h)
+			}
+		}
+		if len(apiTokenCache.unknown) >= apiTokenUnknownCacheSize {
+			return
+		}
+	}
+	apiTokenCache.unknown[hash] = now.Add(apiTokenUnknownCacheDuration)
+}
+
+// ValidateAPIToken returns the token, its user and the permissions of the user,
+// or nil if the token is invalid or expired
+func ValidateAPIToken(key string, ip string) (*APIToken, *User, []string) {
+	key = strings.TrimPrefix(key, "Bearer ")
+	if key == "" {
+		return nil, nil, nil
+	}
+	hash := common.Secret2Hash(key)
+	now := time.Now()
+	apiTokenCache.Lock()
+	if expiredAt, unknown := apiTokenCache.unknown[hash]; unknown {
+		if now.Before(expiredAt) {
+			apiTokenCache.Unlock()
+			return nil, nil, nil
+		}
+		delete(apiTokenCache.unknown, hash)
+	}
+	entry, ok := apiTokenCache.entries[hash]
+	if ok && now.After(entry.expiredAt) {
+		delete(apiTokenCache.entries, hash)
+		ok = false
+	}
+	apiTokenCache.Unlock()
+	if !ok {
+		entry = &apiTokenCacheEntry{expiredAt: now.Add(apiTokenCacheDuration)}
+		if err := DB.First(&entry.token, "hash = ?", hash).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				cacheUnknownAPIToken(hash, now)
+			}
+			return nil, nil, nil
+		}
+		if err := DB.First(&entry.user, "id = ?", entry.token.UserId).Error; err != nil {
+			return nil, nil, nil
+		}
+		permissions, err := GetUserPermissions(entry.user.Id, entry.user.Role)
+		if err != nil {
+			common.SysError("failed to get permissions of user: " + err.Error())
+			return nil, nil, nil
+		}
+		entry.permissions = permissions
+		apiTokenCache.Lock()
+		apiTokenCache.entries[hash] = entry
+		apiTokenCache.Unlock()
+	}
+	apiTokenCache.Lock()
+	if entry.token.IsExpired() {
+		apiTokenCache.Unlock()
+		return nil, nil, nil
+	}
+	if now.Unix()-entry.token.LastUsedTime >= apiTokenLastUsedInterval || entry.token.LastUsedIP != ip {
+		entry.token.LastUsedTime = now.Unix()
+		entry.token.LastUsedIP = ip
+		id, lastUsedTime := entry.token.Id, entry.token.LastUsedTime
+		common.GoAsync(func() {
+			updateAPITokenLastUsed(id, lastUsedTime, ip)
+		})
+	}
+	token, user, permissions := entry.token, entry.user, entry.permissions
+	apiTokenCache.Unlock()
+	return &token, &user, permissions
+}
+
+func updateAPITokenLastUsed(id int, lastUsedTime int64, ip string) {
+	err := DB.Model(&APIToken{}).Where("id = ?", id).
+		Updates(map[string]interface{}{"last_used_time": lastUsedTime, "last_used_ip": ip}).Error
+	if err != nil {
+		common.SysError("failed to update last used time of API token: " + err.Error())
+	}
+}
+
+// InvalidateUserAPITokens drops the cached tokens of the user, e.g. after its role, roles or status changed
+func InvalidateUserAPITokens(userId int) {
+	invalidateLocalUserAPITokens(userId)
+	publishAPITokenInvalidation("user:" + strconv.Itoa(userId))
+}
+
+// InvalidateAllAPITokens drops all the cached tokens, e.g. after the permissions of a role changed
+func InvalidateAllAPITokens() {
+	invalidateLocalAPITokens()
+	publishAPITokenInvalidation("all")
+}
+
+func invalidateLocalAPITokens() {
+	apiTokenCache.Lock()
+	apiTokenCache.entries = make(map[string]*apiTokenCacheEntry)
+	apiTokenCache.Unlock()
+}
+
+func invalidateLocalUserAPITokens(userId int) {
+	apiTokenCache.Lock()
+	for hash, entry := range apiTokenCache.entries {
+		if entry.user.Id == userId {
+			delete(apiTokenCache.entries, hash)
+		}
+	}
+	apiTokenCache.Unlock()
+}
+
+// publishAPITokenInvalidation message is token:<hash>, user:<id> or all
+func publishAPITokenInvalidation(message string) {
+	if !common.RedisEnabled {
+		return
+	}
+	if err := common.RDB.Publish(context.Background(), apiTokenInvalidationChannel, message).Err(); err != nil {
+		common.SysError("failed to publish API token invalidation: " + err.Error())
+	}
+}
+
+// StartAPITokenCacheSync This function is called after InitRedisClient()
+func StartAPITokenCacheSync() {
+	if common.RedisEnabled {
+		common.GoWorker(listenAPITokenInvalidations)
+	}
+}
+
+func listenAPITokenInvalidations(ctx context.Context) {
+	pubsub := common.RDB.Subscribe(ctx, apiTokenInvalidationChannel)
+	defer pubsub.Close()
+	messages := pubsub.Channel()
+	for {
+		select {
+		case msg, ok := <-messages:
+			if !ok {
+				return
+			}
+			kind, value, _ := strings.Cut(msg.Payload, ":")
+			switch kind {
+			case "token":
+				uncacheLocalAPIToken(value)
+			case "user":
+				if userId, err := strconv.Atoi(value); err == nil {
+					invalidateLocalUserAPITokens(userId)
+				}
+			case "all":
+				invalidateLocalAPITokens()
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// migrateLegacyUserTokens moves the single plaintext token stored on the users table by earlier versions
+// into an API token with all scopes.
+func migrateLegacyUserTokens() error {
+	if !DB.Migrator().HasColumn(&User{}, "token") {
+		return nil
+	}
+	var users []struct {
+		Id    int
+		Token string
+	}
+	err := DB.Table("users").Select("id", "token").Where("token <> ''").Find(&users).Error
+	if err != nil {
+		return err
+	}
+	for _, user := range users {
+		err := DB.Transaction(func(tx *gorm.DB) error {
+			token := APIToken{
+				UserId:      user.Id,
+				Name:        "legacy",
+				Hash:        common.Secret2Hash(user.Token),
+				Prefix:      apiTokenPrefix(user.Token),
+				Scopes:      strings.Join(common.TokenScopes, " "),
+				CreatedTime: time.Now().Unix(),
+			}
+			if err := tx.Create(&token).Error; err != nil {
+				return err
+			}
+			return tx.Table("users").Where("id = ?", user.Id).Update("token", "").Error
+		})
+		if err != nil {
+			common.SysError("failed to migrate token of user: " + err.Error())
+		}
+	}
+	return nil
+}
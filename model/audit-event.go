@@ -0,0 +1,149 @@
+package model
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+	"wechat-server/common"
+
+	"gorm.io/gorm"
+)
+
+// AuditEvent records an administrative or security relevant action, events are append-only
+type AuditEvent struct {
+	Id          int    `json:"id"`
+	ActorId     int    `json:"actor_id" gorm:"index"` // 0 for anonymous actors, e.g. a failed login
+	ActorName   string `json:"actor_name"`
+	Action      string `json:"action" gorm:"size:64;index"`
+	TargetType  string `json:"target_type" gorm:"size:32"`
+	TargetId    string `json:"target_id" gorm:"size:191"`
+	Diff        string `json:"diff" gorm:"type:text"` // JSON, {"field": {"before": x, "after": y}}
+	IP          string `json:"ip"`
+	UserAgent   string `json:"user_agent" gorm:"type:text"`
+	CreatedTime int64  `json:"created_time" gorm:"bigint;index"`
+}
+
+type AuditEventFilter struct {
+	ActorId    int
+	ActorName  string
+	Action     string
+	TargetType string
+	TargetId   string
+	IP         string
+	StartTime  int64
+	EndTime    int64
+}
+
+const redactedValue = "******"
+
+// AuditSecret marks the value of an audited field as secret, e.g. a password, the value is redacted
+// so only the fact that it changed is kept. Fields are marked explicitly rather than guessed from their names.
+type AuditSecret string
+
+var ErrAuditEventImmutable = errors.New("审计日志不可修改或删除")
+
+func (event *AuditEvent) BeforeUpdate(tx *gorm.DB) error {
+	return ErrAuditEventImmutable
+}
+
+func (event *AuditEvent) BeforeDelete(tx *gorm.DB) error {
+	return ErrAuditEventImmutable
+}
+
+// auditValueString A missing value is the same as an empty one
+func auditValueString(value interface{}) string {
+	if value == nil {
+		return ""
+	}
+	return fmt.Sprint(value)
+}
+
+func isAuditSecret(value interface{}) bool {
+	_, ok := value.(AuditSecret)
+	return ok
+}
+
+// AuditOptionDiff redacts the values of the options declared secret in the registry, whatever their names are
+func AuditOptionDiff(key string, before string, after string) string {
+	var b, a interface{} = before, after
+	if definition := GetOptionDefinition(key); definition == nil || definition.Secret {
+		b, a = AuditSecret(before), AuditSecret(after)
+	}
+	return AuditDiff(map[string]interface{}{key: b}, map[string]interface{}{key: a})
+}
+
+// AuditDiff returns the changed fields between before and after as JSON, the values marked by AuditSecret are redacted
+func AuditDiff(before map[string]interface{}, after map[string]interface{}) string {
+	diff := make(map[string]map[string]interface{})
+	add := func(field string) {
+		if _, ok := diff[field]; ok {
+			return
+		}
+		b, a := before[field], after[field]
+		if auditValueString(b) == auditValueString(a) {
+			return
+		}
+		if isAuditSecret(b) || isAuditSecret(a) {
+			if auditValueString(b) != "" {
+				b = redactedValue
+			}
+			if auditValueString(a) != "" {
+				a = redactedValue
+			}
+		}
+		diff[field] = map[string]interface{}{"before": b, "after": a}
+	}
+	for field := range before {
+		add(field)
+	}
+	for field := range after {
+		add(field)
+	}
+	if len(diff) == 0 {
+		return ""
+	}
+	data, _ := json.Marshal(diff)
+	return string(data)
+}
+
+// RecordAuditEvent Failures are logged only, they shouldn't fail the audited action
+func RecordAuditEvent(event *AuditEvent) {
+	event.CreatedTime = time.Now().Unix()
+	if err := DB.Create(event).Error; err != nil {
+		common.SysError("failed to record audit event " + event.Action + ": " + err.Error())
+	}
+}
+
+func (filter *AuditEventFilter) apply(tx *gorm.DB) *gorm.DB {
+	if filter.ActorId != 0 {
+		tx = tx.Where("actor_id = ?", filter.ActorId)
+	}
+	if filter.ActorName != "" {
+		tx = tx.Where("actor_name = ?", filter.ActorName)
+	}
+	if filter.Action != "" {
+		tx = tx.Where("action LIKE ?", filter.Action+"%")
+	}
+	if filter.TargetType != "" {
+		tx = tx.Where("target_type = ?", filter.TargetType)
+	}
+	if filter.TargetId != "" {
+		tx = tx.Where("target_id = ?", filter.TargetId)
+	}
+	if filter.IP != "" {
+		tx = tx.Where("ip = ?", filter.IP)
+	}
+	if filter.StartTime != 0 {
+		tx = tx.Where("created_time >= ?", filter.StartTime)
+	}
+	if filter.EndTime != 0 {
+		tx = tx.Where("created_time <= ?", filter.EndTime)
+	}
+	return tx
+}
+
+func QueryAuditEvents(filter *AuditEventFilter, startIdx int, limit int) (events []*AuditEvent, err error) {
+	err = filter.apply(DB.Model(&AuditEvent{})).Order("id desc").Limit(limit).Offset(startIdx).Find(&events).Error
+	return events, err
+}
@@ -0,0 +1,134 @@
+package model
+
+import (
+	"crypto/subtle"
+	"net"
+	"strings"
+	"wechat-server/common"
+)
+
+// Client is a downstream application allowed to log users in through wechat-server
+type Client struct {
+	Id           int    `json:"id"`
+	ClientId     string `json:"client_id" gorm:"uniqueIndex;size:64"`
+	Name         string `json:"name"`
+	Secret       string `json:"-" gorm:"not null"`              // SHA-256 of the client secret
+	RedirectURIs string `json:"redirect_uris" gorm:"type:text"` // one per line
+	Scopes       string `json:"scopes"`                         // space separated, see common.ClientScopes
+	AllowedIPs   string `json:"allowed_ips" gorm:"type:text"`   // IPs or CIDRs, one per line, empty means any
+	Status       int    `json:"status" gorm:"type:int;default:1"`
+	CreatedTime  int64  `json:"created_time" gorm:"bigint"`
+}
+
+func GetAllClients() (clients []*Client, err error) {
+	err = DB.Order("id desc").Find(&clients).Error
+	return clients, err
+}
+
+func GetClientById(id int) (*Client, error) {
+	client := Client{}
+	err := DB.First(&client, "id = ?", id).Error
+	return &client, err
+}
+
+func GetClientByClientId(clientId string) (*Client, error) {
+	client := Client{}
+	err := DB.First(&client, "client_id = ?", clientId).Error
+	return &client, err
+}
+
+func (client *Client) Insert() error {
+	return DB.Create(client).Error
+}
+
+func (client *Client) Update() error {
+	return DB.Model(client).Select("name", "redirect_uris", "scopes", "allowed_ips", "status").Updates(client).Error
+}
+
+func (client *Client) UpdateSecret(secret string) error {
+	client.Secret = common.Secret2Hash(secret)
+	return DB.Model(client).Update("secret", client.Secret).Error
+}
+
+func (client *Client) Delete() error {
+	return DB.Delete(client).Error
+}
+
+func (client *Client) ValidateSecret(secret string) bool {
+	return secret != "" && subtle.ConstantTimeCompare([]byte(client.Secret), []byte(common.Secret2Hash(secret))) == 1
+}
+
+func (client *Client) GetRedirectURIs() []string {
+	var uris []string
+	for _, uri := range strings.Split(client.RedirectURIs, "\n") {
+		uri = strings.TrimSpace(uri)
+		if uri != "" {
+			uris = append(uris, uri)
+		}
+	}
+	return uris
+}
+
+// AllowsRedirectURI Redirect URIs are compared exactly, no prefix or wildcard matching
+func (client *Client) AllowsRedirectURI(redirectURI string) bool {
+	for _, uri := range client.GetRedirectURIs() {
+		if uri == redirectURI {
+			return true
+		}
+	}
+	return false
+}
+
+func (client *Client) HasScope(scope string) bool {
+	for _, s := range strings.Fields(client.Scopes) {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+func (client *Client) GetAllowedIPs() []string {
+	var ips []string
+	for _, ip := range strings.Split(client.AllowedIPs, "\n") {
+		ip = strings.TrimSpace(ip)
+		if ip != "" {
+			ips = append(ips, ip)
+		}
+	}
+	return ips
+}
+
+// AllowsIP An empty allowlist allows any IP
+func (client *Client) AllowsIP(ip string) bool {
+	allowedIPs := client.GetAllowedIPs()
+	if len(allowedIPs) == 0 {
+		return true
+	}
+	parsedIP := net.ParseIP(ip)
+	if parsedIP == nil {
+		return false
+	}
+	for _, allowed := range allowedIPs {
+		if _, network, err := net.ParseCIDR(allowed); err == nil {
+			if network.Contains(parsedIP) {
+				return true
+			}
+		} else if allowedIP := net.ParseIP(allowed); allowedIP != nil && allowedIP.Equal(parsedIP) {
+			return true
+		}
+	}
+	return false
+}
+
+// ValidateClientCredentials returns the enabled client matching the given credentials, or nil
+func ValidateClientCredentials(clientId string, secret string) *Client {
+	if clientId == "" || secret == "" {
+		return nil
+	}
+	client, err := GetClientByClientId(clientId)
+	if err != nil || client.Status != common.ClientStatusEnabled || !client.ValidateSecret(secret) {
+		return nil
+	}
+	return client
+}
@@ -1,13 +1,11 @@
 package model
 
 import (
+	"context"
 	"gorm.io/driver/mysql"
 	"gorm.io/driver/sqlite"
 	"gorm.io/gorm"
-	"os"
 	"wechat-server/common"
-
-	"github.com/joho/godotenv"
 )
 
 var DB *gorm.DB
@@ -38,14 +36,10 @@ func CountTable(tableName string) (num int64) {
 }
 
 func InitDB() (err error) {
-	if err := godotenv.Load(); err != nil {
-		common.SysLog("No .env file found, using system environment variables")
-	}
-
 	var db *gorm.DB
-	if os.Getenv("SQL_DSN") != "" {
+	if common.Config.SQLDSN != "" {
 		// Use MySQL
-		db, err = gorm.Open(mysql.Open(os.Getenv("SQL_DSN")), &gorm.Config{
+		db, err = gorm.Open(mysql.Open(common.Config.SQLDSN), &gorm.Config{
 			PrepareStmt: true, // precompile SQL
 		})
 	} else {
@@ -69,6 +63,50 @@ func InitDB() (err error) {
 		if err != nil {
 			return err
 		}
+		err = db.AutoMigrate(&Client{})
+		if err != nil {
+			return err
+		}
+		err = db.AutoMigrate(&UserIdentity{})
+		if err != nil {
+			return err
+		}
+		err = migrateUserIdentities()
+		if err != nil {
+			return err
+		}
+		err = db.AutoMigrate(&APIToken{})
+		if err != nil {
+			return err
+		}
+		err = db.AutoMigrate(&UserSession{})
+		if err != nil {
+			return err
+		}
+		err = migrateLegacyUserTokens()
+		if err != nil {
+			return err
+		}
+		err = db.AutoMigrate(&TwoFactor{})
+		if err != nil {
+			return err
+		}
+		err = db.AutoMigrate(&AuditEvent{})
+		if err != nil {
+			return err
+		}
+		err = db.AutoMigrate(&Role{})
+		if err != nil {
+			return err
+		}
+		err = db.AutoMigrate(&UserRole{})
+		if err != nil {
+			return err
+		}
+		err = createBuiltinRolesIfNeed()
+		if err != nil {
+			return err
+		}
 		err = createRootAccountIfNeed()
 		return err
 	} else {
@@ -85,3 +123,11 @@ func CloseDB() error {
 	err = sqlDB.Close()
 	return err
 }
+
+func PingDB(ctx context.Context) error {
+	sqlDB, err := DB.DB()
+	if err != nil {
+		return err
+	}
+	return sqlDB.PingContext(ctx)
+}
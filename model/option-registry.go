@@ -0,0 +1,230 @@
+package model
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"wechat-server/common"
+)
+
+type OptionType string
+
+const (
+	OptionTypeString OptionType = "string"
+	OptionTypeText   OptionType = "text" // multiline, like HTML and JSON
+	OptionTypeBool   OptionType = "bool"
+	OptionTypeInt    OptionType = "int"
+)
+
+type OptionRange struct {
+	Min int `json:"min"`
+	Max int `json:"max"`
+}
+
+// OptionDefinition declares an option, values of options not in the registry are rejected.
+// Validate is only run on updates since it may depend on other options, the values in the database are
+// only checked against the type when loaded.
+type OptionDefinition struct {
+	Key      string                   `json:"key"`
+	Type     OptionType               `json:"type"`
+	Group    string                   `json:"group"`
+	Label    string                   `json:"label"`
+	Default  string                   `json:"default"`
+	Secret   bool                     `json:"secret"` // never returned by the API
+	Range    *OptionRange             `json:"range,omitempty"`
+	Enum     []string                 `json:"enum,omitempty"`
+	Internal bool                     `json:"-"` // managed by the server, can't be read or set by the API
+	Validate func(value string) error `json:"-"`
+	Apply    func(value string)       `json:"-"` // called with the option map locked
+}
+
+func boolOption(key string, group string, label string, target *bool) *OptionDefinition {
+	return &OptionDefinition{
+		Key:     key,
+		Type:    OptionTypeBool,
+		Group:   group,
+		Label:   label,
+		Default: strconv.FormatBool(*target),
+		Apply: func(value string) {
+			*target = value == "true"
+		},
+	}
+}
+
+func intOption(key string, group string, label string, target *int, min int, max int) *OptionDefinition {
+	return &OptionDefinition{
+		Key:     key,
+		Type:    OptionTypeInt,
+		Group:   group,
+		Label:   label,
+		Default: strconv.Itoa(*target),
+		Range:   &OptionRange{Min: min, Max: max},
+		Apply: func(value string) {
+			*target, _ = strconv.Atoi(value)
+		},
+	}
+}
+
+func stringOption(key string, group string, label string, target *string) *OptionDefinition {
+	definition := &OptionDefinition{
+		Key:   key,
+		Type:  OptionTypeString,
+		Group: group,
+		Label: label,
+	}
+	if target != nil {
+		definition.Default = *target
+		definition.Apply = func(value string) {
+			*target = value
+		}
+	}
+	return definition
+}
+
+func secretOption(key string, group string, label string, target *string) *OptionDefinition {
+	definition := stringOption(key, group, label, target)
+	definition.Secret = true
+	return definition
+}
+
+func textOption(key string, group string, label string, target *string) *OptionDefinition {
+	definition := stringOption(key, group, label, target)
+	definition.Type = OptionTypeText
+	return definition
+}
+
+func permissionOption(key string, label string, target *int) *OptionDefinition {
+	definition := intOption(key, "permission", label, target, common.RoleGuestUser, common.RoleRootUser)
+	definition.Enum = []string{strconv.Itoa(common.RoleGuestUser), strconv.Itoa(common.RoleCommonUser),
+		strconv.Itoa(common.RoleAdminUser), strconv.Itoa(common.RoleRootUser)}
+	return definition
+}
+
+var optionDefinitions = []*OptionDefinition{
+	stringOption("ServerAddress", "general", "服务器地址", &common.ServerAddress),
+	textOption("Notice", "general", "公告", nil),
+	textOption("FooterHTML", "general", "页脚 HTML", &common.FooterHTML),
+	permissionOption("FileUploadPermission", "文件上传权限", &common.FileUploadPermission),
+	permissionOption("FileDownloadPermission", "文件下载权限", &common.FileDownloadPermission),
+	permissionOption("ImageUploadPermission", "图片上传权限", &common.ImageUploadPermission),
+	permissionOption("ImageDownloadPermission", "图片下载权限", &common.ImageDownloadPermission),
+	boolOption("PasswordLoginEnabled", "login", "允许通过密码进行登录", &common.PasswordLoginEnabled),
+	boolOption("RegisterEnabled", "login", "允许新用户注册", &common.RegisterEnabled),
+	boolOption("EmailVerificationEnabled", "login", "通过密码注册时需要进行邮箱验证", &common.EmailVerificationEnabled),
+	withValidate(boolOption("GitHubOAuthEnabled", "login", "允许通过 GitHub 账户登录 & 注册", &common.GitHubOAuthEnabled),
+		func(value string) error {
+			if value == "true" && common.GitHubClientId == "" {
+				return errors.New("无法启用 GitHub OAuth，请先填入 GitHub Client ID 以及 GitHub Client Secret！")
+			}
+			return nil
+		}),
+	boolOption("WeChatLoginConfirmEnabled", "login", "扫码登录需在微信中确认", &common.WeChatLoginConfirmEnabled),
+	boolOption("WeChatAuthEnabled", "login", "允许通过微信登录 & 注册", &common.WeChatAuthEnabled),
+	boolOption("TwoFactorRequiredForAdmin", "login", "要求管理员启用两步验证", &common.TwoFactorRequiredForAdmin),
+	intOption("LoginMaxFailures", "login", "登录失败锁定次数", &common.LoginMaxFailures, 0, 100),
+	intOption("LoginLockoutMinutes", "login", "登录锁定时长（分钟）", &common.LoginLockoutMinutes, 1, 1440),
+	stringOption("SMTPServer", "smtp", "SMTP 服务器地址", &common.SMTPServer),
+	stringOption("SMTPAccount", "smtp", "SMTP 账户", &common.SMTPAccount),
+	secretOption("SMTPToken", "smtp", "SMTP 访问凭证", &common.SMTPToken),
+	stringOption("GitHubClientId", "github", "GitHub Client ID", &common.GitHubClientId),
+	secretOption("GitHubClientSecret", "github", "GitHub Client Secret", &common.GitHubClientSecret),
+	secretOption("WeChatToken", "wechat", "令牌（Token）", &common.WeChatToken),
+	stringOption("WeChatAppID", "wechat", "开发者 ID（AppID）", &common.WeChatAppID),
+	secretOption("WeChatAppSecret", "wechat", "开发者密码（AppSecret）", &common.WeChatAppSecret),
+	secretOption("WeChatEncodingAESKey", "wechat", "消息加解密密钥（EncodingAESKey）", &common.WeChatEncodingAESKey),
+	stringOption("WeChatOwnerID", "wechat", "公众号拥有者的 OpenID", &common.WeChatOwnerID),
+	withValidate(textOption("WeChatMenu", "wechat", "公众号菜单", &common.WeChatMenu), func(value string) error {
+		if !json.Valid([]byte(value)) {
+			return errors.New("WeChatMenu 必须为合法的 JSON")
+		}
+		return nil
+	}),
+	intOption("EmailVerificationCodeLength", "verification", "邮箱验证码长度", &common.EmailVerificationPolicy.CodeLength, 4, 32),
+	intOption("EmailVerificationValidMinutes", "verification", "邮箱验证码有效期（分钟）", &common.EmailVerificationPolicy.ValidMinutes, 1, 1440),
+	intOption("PasswordResetValidMinutes", "verification", "密码重置链接有效期（分钟）", &common.PasswordResetPolicy.ValidMinutes, 1, 1440),
+	intOption("WeChatVerificationCodeLength", "verification", "微信验证码长度", &common.WeChatVerificationPolicy.CodeLength, 4, 10),
+	intOption("WeChatVerificationValidMinutes", "verification", "微信验证码有效期（分钟）", &common.WeChatVerificationPolicy.ValidMinutes, 1, 1440),
+	intOption("VerificationMaxAttempts", "verification", "验证码最大尝试次数", &common.VerificationMaxAttempts, 0, 100), // 0 disables the lockout
+	intOption("VerificationLockoutMinutes", "verification", "验证码锁定时长（分钟）", &common.VerificationLockoutMinutes, 1, 1440),
+	{
+		Key:      "OIDCSigningKey",
+		Type:     OptionTypeText,
+		Group:    "oidc",
+		Secret:   true,
+		Internal: true,
+		Apply: func(value string) {
+			if value != "" {
+				if _, err := common.LoadOIDCSigningKey(value); err != nil {
+					common.SysError("failed to load OIDC signing key: " + err.Error())
+				}
+			}
+		},
+	},
+}
+
+func withValidate(definition *OptionDefinition, validate func(value string) error) *OptionDefinition {
+	definition.Validate = validate
+	return definition
+}
+
+var optionDefinitionMap = func() map[string]*OptionDefinition {
+	m := make(map[string]*OptionDefinition, len(optionDefinitions))
+	for _, definition := range optionDefinitions {
+		m[definition.Key] = definition
+	}
+	return m
+}()
+
+// GetOptionDefinition returns nil if the option doesn't exist
+func GetOptionDefinition(key string) *OptionDefinition {
+	return optionDefinitionMap[key]
+}
+
+// GetOptionDefinitions returns the options visible to the API in the order they are declared
+func GetOptionDefinitions() []*OptionDefinition {
+	definitions := make([]*OptionDefinition, 0, len(optionDefinitions))
+	for _, definition := range optionDefinitions {
+		if !definition.Internal {
+			definitions = append(definitions, definition)
+		}
+	}
+	return definitions
+}
+
+func (definition *OptionDefinition) checkType(value string) error {
+	switch definition.Type {
+	case OptionTypeBool:
+		if value != "true" && value != "false" {
+			return fmt.Errorf("%s 必须为 true 或 false", definition.Key)
+		}
+	case OptionTypeInt:
+		intValue, err := strconv.Atoi(value)
+		if err != nil {
+			return fmt.Errorf("%s 必须为整数", definition.Key)
+		}
+		if r := definition.Range; r != nil && (intValue < r.Min || intValue > r.Max) {
+			return fmt.Errorf("%s 必须为 %d 到 %d 之间的整数", definition.Key, r.Min, r.Max)
+		}
+	}
+	if len(definition.Enum) > 0 {
+		for _, v := range definition.Enum {
+			if v == value {
+				return nil
+			}
+		}
+		return fmt.Errorf("%s 必须为 %s 之一", definition.Key, strings.Join(definition.Enum, "、"))
+	}
+	return nil
+}
+
+func (definition *OptionDefinition) validate(value string) error {
+	if err := definition.checkType(value); err != nil {
+		return err
+	}
+	if definition.Validate != nil {
+		return definition.Validate(value)
+	}
+	return nil
+}
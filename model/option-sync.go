@@ -0,0 +1,105 @@
+package model
+
+import (
+	"context"
+	"errors"
+	"time"
+	"wechat-server/common"
+
+	"gorm.io/gorm"
+)
+
+// Options are changed by one instance and then reapplied by the others: with Redis enabled the key of the option
+// is published right away, and the options are also reconciled with the database periodically in case an event
+// was missed or Redis isn't enabled. Only the key is published, the value is always read from the database.
+
+const optionChangeChannel = "options:changed"
+
+func publishOptionChange(key string) {
+	if !common.RedisEnabled {
+		return
+	}
+	if err := common.RDB.Publish(context.Background(), optionChangeChannel, key).Err(); err != nil {
+		common.SysError("failed to publish option change: " + err.Error())
+	}
+}
+
+// StartOptionSync This function is called after InitOptionMap()
+func StartOptionSync() {
+	if common.RedisEnabled {
+		common.GoWorker(listenOptionChanges)
+	}
+	common.GoWorker(reconcileOptionsPeriodically)
+}
+
+func listenOptionChanges(ctx context.Context) {
+	pubsub := common.RDB.Subscribe(ctx, optionChangeChannel)
+	defer pubsub.Close()
+	messages := pubsub.Channel()
+	for {
+		select {
+		case msg, ok := <-messages:
+			if !ok {
+				return
+			}
+			reloadOption(msg.Payload)
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func reconcileOptionsPeriodically(ctx context.Context) {
+	ticker := time.NewTicker(time.Duration(common.Config.OptionSyncInterval) * time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			reconcileOptions()
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func reloadOption(key string) {
+	option := Option{}
+	if err := DB.Where(&Option{Key: key}).First(&option).Error; err != nil {
+		if !errors.Is(err, gorm.ErrRecordNotFound) {
+			common.SysError("failed to reload option: " + err.Error())
+		}
+		return
+	}
+	applyChangedOption(&option)
+}
+
+func reconcileOptions() {
+	options, err := AllOption()
+	if err != nil {
+		common.SysError("failed to reconcile options: " + err.Error())
+		return
+	}
+	for _, option := range options {
+		applyChangedOption(option)
+	}
+}
+
+// applyChangedOption skips the options not changed, so the apply hooks aren't run needlessly
+func applyChangedOption(option *Option) {
+	definition, value, err := decodeOption(option)
+	if err != nil {
+		common.SysError(err.Error())
+		return
+	}
+	if definition == nil {
+		return
+	}
+	common.OptionMapRWMutex.RLock()
+	current, ok := common.OptionMap[option.Key]
+	common.OptionMapRWMutex.RUnlock()
+	if ok && current == value {
+		return
+	}
+	updateOptionMap(option.Key, value)
+	common.SysLog("option reloaded: " + option.Key)
+}
@@ -0,0 +1,55 @@
+package model
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"gorm.io/driver/mysql"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// sqlRecorder records the statements built by a dry run session
+type sqlRecorder struct {
+	logger.Interface
+	statements []string
+}
+
+func (r *sqlRecorder) Trace(ctx context.Context, begin time.Time, fc func() (string, int64), err error) {
+	sql, _ := fc()
+	r.statements = append(r.statements, sql)
+}
+
+// setDryRunMySQL points DB at a MySQL dialect session which builds the statements without running them
+func setDryRunMySQL(t *testing.T) *sqlRecorder {
+	t.Helper()
+	recorder := &sqlRecorder{Interface: logger.Discard}
+	db, err := gorm.Open(mysql.New(mysql.Config{
+		DSN:                       "user:pass@tcp(127.0.0.1:3306)/wechat",
+		SkipInitializeWithVersion: true,
+	}), &gorm.Config{DryRun: true, DisableAutomaticPing: true, Logger: recorder})
+	if err != nil {
+		t.Fatalf("gorm.Open: %v", err)
+	}
+	previous := DB
+	t.Cleanup(func() {
+		DB = previous
+	})
+	DB = db
+	return recorder
+}
+
+func TestReloadOptionQuotesKey(t *testing.T) {
+	recorder := setDryRunMySQL(t)
+	reloadOption("SMTPServer")
+	if len(recorder.statements) != 1 {
+		t.Fatalf("got %d statements, want 1", len(recorder.statements))
+	}
+	// KEY is a reserved word in MySQL
+	sql := recorder.statements[0]
+	if !strings.Contains(sql, "`options`.`key` = 'SMTPServer'") {
+		t.Errorf("the key column isn't quoted: %s", sql)
+	}
+}
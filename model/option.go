@@ -1,10 +1,10 @@
 package model
 
 import (
-	"errors"
-	"strconv"
-	"strings"
+	"fmt"
 	"wechat-server/common"
+
+	"gorm.io/gorm/clause"
 )
 
 type Option struct {
@@ -19,110 +19,151 @@ func AllOption() ([]*Option, error) {
 	return options, err
 }
 
-func InitOptionMap() {
+// InitOptionMap sets the defaults declared in the registry, then applies the values in the database.
+// Secret options still stored in plaintext are encrypted once the master key is set.
+func InitOptionMap() error {
 	common.OptionMapRWMutex.Lock()
 	common.OptionMap = make(map[string]string)
-	common.OptionMap["FileUploadPermission"] = strconv.Itoa(common.FileUploadPermission)
-	common.OptionMap["FileDownloadPermission"] = strconv.Itoa(common.FileDownloadPermission)
-	common.OptionMap["ImageUploadPermission"] = strconv.Itoa(common.ImageUploadPermission)
-	common.OptionMap["ImageDownloadPermission"] = strconv.Itoa(common.ImageDownloadPermission)
-	common.OptionMap["PasswordLoginEnabled"] = strconv.FormatBool(common.PasswordLoginEnabled)
-	common.OptionMap["RegisterEnabled"] = strconv.FormatBool(common.RegisterEnabled)
-	common.OptionMap["EmailVerificationEnabled"] = strconv.FormatBool(common.EmailVerificationEnabled)
-	common.OptionMap["GitHubOAuthEnabled"] = strconv.FormatBool(common.GitHubOAuthEnabled)
-	common.OptionMap["SMTPServer"] = ""
-	common.OptionMap["SMTPAccount"] = ""
-	common.OptionMap["SMTPToken"] = ""
-	common.OptionMap["Notice"] = ""
-	common.OptionMap["FooterHTML"] = common.FooterHTML
-	common.OptionMap["ServerAddress"] = ""
-	common.OptionMap["GitHubClientId"] = ""
-	common.OptionMap["GitHubClientSecret"] = ""
-	common.OptionMap["WeChatToken"] = ""
-	common.OptionMap["WeChatAppID"] = ""
-	common.OptionMap["WeChatAppSecret"] = ""
-	common.OptionMap["WeChatEncodingAESKey"] = ""
-	common.OptionMap["WeChatOwnerID"] = ""
-	common.OptionMap["WeChatMenu"] = common.WeChatMenu
+	for _, definition := range optionDefinitions {
+		common.OptionMap[definition.Key] = definition.Default
+	}
 	common.OptionMapRWMutex.Unlock()
-	options, _ := AllOption()
+	options, err := AllOption()
+	if err != nil {
+		return err
+	}
 	for _, option := range options {
-		updateOptionMap(option.Key, option.Value)
+		definition, value, err := decodeOption(option)
+		if err != nil {
+			return err
+		}
+		if definition == nil {
+			common.SysWarn("ignored unknown or invalid option " + option.Key)
+			continue
+		}
+		if definition.Secret && value != "" && !common.IsEncryptedSecret(option.Value) && common.SecretEncryptionEnabled() {
+			if err := saveOption(definition, value); err != nil {
+				return fmt.Errorf("failed to encrypt option %s: %v", option.Key, err)
+			}
+			common.SysLog("encrypted option " + option.Key)
+		}
+		updateOptionMap(option.Key, value)
 	}
+	return nil
 }
 
-func UpdateOption(key string, value string) error {
-	if key == "StatEnabled" && value == "true" && !common.RedisEnabled {
-		return errors.New("未启用 Redis，无法启用统计功能")
+// decodeOption returns the decrypted value, the definition is nil if the option is unknown or the value is invalid
+func decodeOption(option *Option) (*OptionDefinition, string, error) {
+	definition := GetOptionDefinition(option.Key)
+	if definition == nil {
+		return nil, "", nil
+	}
+	value := option.Value
+	if common.IsEncryptedSecret(value) {
+		var err error
+		value, err = common.DecryptSecret(option.Key, option.Value)
+		if err != nil {
+			return nil, "", fmt.Errorf("failed to decrypt option %s: %v", option.Key, err)
+		}
+	}
+	if definition.checkType(value) != nil {
+		return nil, "", nil
 	}
+	return definition, value, nil
+}
 
+func UpdateOption(key string, value string) error {
+	definition := GetOptionDefinition(key)
+	if definition == nil {
+		return fmt.Errorf("未知的配置项：%s", key)
+	}
+	if err := definition.validate(value); err != nil {
+		return err
+	}
 	// Save to database first
+	if err := saveOption(definition, value); err != nil {
+		return err
+	}
+	// Update OptionMap
+	updateOptionMap(key, value)
+	publishOptionChange(key)
+	return nil
+}
+
+// saveOption values of secret options are encrypted if the master key is set
+func saveOption(definition *OptionDefinition, value string) error {
+	if definition.Secret && value != "" && common.SecretEncryptionEnabled() {
+		var err error
+		value, err = common.EncryptSecret(definition.Key, value)
+		if err != nil {
+			return err
+		}
+	}
+	return saveOptionValue(definition.Key, value)
+}
+
+func saveOptionValue(key string, value string) error {
 	option := Option{
 		Key:   key,
 		Value: value,
 	}
-	// When updating with struct it will only update non-zero fields by default
-	// So we have to use Select here
-	if DB.Model(&option).Where("key = ?", key).Update("value", option.Value).RowsAffected == 0 {
-		DB.Create(&option)
+	// An upsert instead of an update followed by an insert, since MySQL counts no affected rows
+	// when the value is unchanged
+	return DB.Clauses(clause.OnConflict{UpdateAll: true}).Create(&option).Error
+}
+
+// RotateOptionMasterKey re-encrypts the data keys of the secret options encrypted by the previous master key,
+// and encrypts the ones in plaintext. It returns the number of options updated.
+func RotateOptionMasterKey() (int, error) {
+	if !common.SecretEncryptionEnabled() {
+		return 0, common.ErrNoMasterKey
+	}
+	options, err := AllOption()
+	if err != nil {
+		return 0, err
 	}
-	// Update OptionMap
-	updateOptionMap(key, value)
-	return nil
+	count := 0
+	for _, option := range options {
+		definition := GetOptionDefinition(option.Key)
+		if definition == nil || !definition.Secret || option.Value == "" {
+			continue
+		}
+		value, changed, err := common.ReencryptSecret(option.Key, option.Value)
+		if err != nil {
+			return count, fmt.Errorf("failed to re-encrypt option %s: %v", option.Key, err)
+		}
+		if !changed {
+			continue
+		}
+		if err := saveOptionValue(option.Key, value); err != nil {
+			return count, err
+		}
+		count++
+	}
+	return count, nil
 }
 
 func updateOptionMap(key string, value string) {
 	common.OptionMapRWMutex.Lock()
 	defer common.OptionMapRWMutex.Unlock()
 	common.OptionMap[key] = value
-	if strings.HasSuffix(key, "Permission") {
-		intValue, _ := strconv.Atoi(value)
-		switch key {
-		case "FileUploadPermission":
-			common.FileUploadPermission = intValue
-		case "FileDownloadPermission":
-			common.FileDownloadPermission = intValue
-		case "ImageUploadPermission":
-			common.ImageUploadPermission = intValue
-		case "ImageDownloadPermission":
-			common.ImageDownloadPermission = intValue
-		}
+	if definition := GetOptionDefinition(key); definition != nil && definition.Apply != nil {
+		definition.Apply(value)
+	}
+}
+
+// InitOIDCSigningKey generates and persists the signing key on first start
+func InitOIDCSigningKey() error {
+	common.OptionMapRWMutex.RLock()
+	keyPEM := common.OptionMap["OIDCSigningKey"]
+	common.OptionMapRWMutex.RUnlock()
+	if keyPEM != "" {
+		return nil
 	}
-	boolValue := value == "true"
-	switch key {
-	case "RegisterEnabled":
-		common.RegisterEnabled = boolValue
-	case "PasswordLoginEnabled":
-		common.PasswordLoginEnabled = boolValue
-	case "EmailVerificationEnabled":
-		common.EmailVerificationEnabled = boolValue
-	case "GitHubOAuthEnabled":
-		common.GitHubOAuthEnabled = boolValue
-	case "SMTPServer":
-		common.SMTPServer = value
-	case "SMTPAccount":
-		common.SMTPAccount = value
-	case "SMTPToken":
-		common.SMTPToken = value
-	case "ServerAddress":
-		common.ServerAddress = value
-	case "GitHubClientId":
-		common.GitHubClientId = value
-	case "GitHubClientSecret":
-		common.GitHubClientSecret = value
-	case "FooterHTML":
-		common.FooterHTML = value
-	case "WeChatToken":
-		common.WeChatToken = value
-	case "WeChatAppID":
-		common.WeChatAppID = value
-	case "WeChatAppSecret":
-		common.WeChatAppSecret = value
-	case "WeChatEncodingAESKey":
-		common.WeChatEncodingAESKey = value
-	case "WeChatOwnerID":
-		common.WeChatOwnerID = value
-	case "WeChatMenu":
-		common.WeChatMenu = value
+	keyPEM, err := common.LoadOIDCSigningKey("")
+	if err != nil {
+		return err
 	}
+	common.SysLog("OIDC signing key generated")
+	return UpdateOption("OIDCSigningKey", keyPEM)
 }
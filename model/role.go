@@ -0,0 +1,224 @@
+package model
+
+import (
+	"errors"
+	"strings"
+	"time"
+	"wechat-server/common"
+
+	"gorm.io/gorm"
+)
+
+// Role is a named group of permissions. Every user implicitly has the built-in role of its numeric role,
+// other roles are assigned per user through UserRole.
+type Role struct {
+	Id          int    `json:"id"`
+	Name        string `json:"name" gorm:"uniqueIndex;size:64"`
+	Description string `json:"description"`
+	Permissions string `json:"permissions" gorm:"type:text"` // space separated, see common.Permissions
+	Builtin     bool   `json:"builtin"`
+	CreatedTime int64  `json:"created_time" gorm:"bigint"`
+}
+
+type UserRole struct {
+	UserId int `json:"user_id" gorm:"primaryKey;autoIncrement:false"`
+	RoleId int `json:"role_id" gorm:"primaryKey;autoIncrement:false;index"`
+}
+
+var (
+	ErrBuiltinRole = errors.New("无法修改或删除内置角色")
+	ErrRoleInvalid = errors.New("角色不存在或为内置角色")
+)
+
+var builtinRoles = []Role{
+	{
+		Name:        common.BuiltinRoleUser,
+		Description: "普通用户",
+		Permissions: "",
+	},
+	{
+		Name:        common.BuiltinRoleAdmin,
+		Description: "管理员",
+		Permissions: strings.Join([]string{common.PermissionUsersRead, common.PermissionUsersManage,
+			common.PermissionFilesDelete, common.PermissionWeChatAPI}, " "),
+	},
+	{
+		Name:        common.BuiltinRoleRoot,
+		Description: "超级管理员",
+		Permissions: common.PermissionAll,
+	},
+}
+
+// BuiltinRoleName returns the built-in role granted by a numeric role
+func BuiltinRoleName(role int) string {
+	if role >= common.RoleRootUser {
+		return common.BuiltinRoleRoot
+	}
+	if role >= common.RoleAdminUser {
+		return common.BuiltinRoleAdmin
+	}
+	return common.BuiltinRoleUser
+}
+
+func createBuiltinRolesIfNeed() error {
+	for _, role := range builtinRoles {
+		var count int64
+		if err := DB.Model(&Role{}).Where("name = ?", role.Name).Count(&count).Error; err != nil {
+			return err
+		}
+		if count != 0 {
+			continue
+		}
+		role.Builtin = true
+		role.CreatedTime = time.Now().Unix()
+		if err := DB.Create(&role).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (role *Role) GetPermissions() []string {
+	return strings.Fields(role.Permissions)
+}
+
+func GetAllRoles() (roles []*Role, err error) {
+	err = DB.Order("id").Find(&roles).Error
+	return roles, err
+}
+
+func GetRoleById(id int) (*Role, error) {
+	role := Role{}
+	err := DB.First(&role, "id = ?", id).Error
+	return &role, err
+}
+
+func (role *Role) Insert() error {
+	role.Builtin = false
+	role.CreatedTime = time.Now().Unix()
+	return DB.Create(role).Error
+}
+
+// Update The permissions of the root role are fixed
+func (role *Role) Update() error {
+	origin, err := GetRoleById(role.Id)
+	if err != nil {
+		return err
+	}
+	if origin.Name == common.BuiltinRoleRoot {
+		return ErrBuiltinRole
+	}
+	if origin.Builtin {
+		// Built-in roles are looked up by name
+		role.Name = origin.Name
+	}
+	if err := DB.Model(role).Select("name", "description", "permissions").Updates(role).Error; err != nil {
+		return err
+	}
+	InvalidateAllAPITokens()
+	return nil
+}
+
+func (role *Role) Delete() error {
+	origin, err := GetRoleById(role.Id)
+	if err != nil {
+		return err
+	}
+	if origin.Builtin {
+		return ErrBuiltinRole
+	}
+	err = DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("role_id = ?", role.Id).Delete(&UserRole{}).Error; err != nil {
+			return err
+		}
+		return tx.Delete(role).Error
+	})
+	if err == nil {
+		InvalidateAllAPITokens()
+	}
+	return err
+}
+
+// GetUserRoles returns the roles assigned to the user, the built-in role is not included
+func GetUserRoles(userId int) (roles []*Role, err error) {
+	err = DB.Where("id IN (?)", DB.Model(&UserRole{}).Select("role_id").Where("user_id = ?", userId)).
+		Order("id").Find(&roles).Error
+	return roles, err
+}
+
+// SetUserRoles replaces the roles assigned to the user, built-in roles can't be assigned
+func SetUserRoles(userId int, roleIds []int) error {
+	err := DB.Transaction(func(tx *gorm.DB) error {
+		if len(roleIds) != 0 {
+			var count int64
+			err := tx.Model(&Role{}).Where("id IN ? AND builtin = ?", roleIds, false).Count(&count).Error
+			if err != nil {
+				return err
+			}
+			if int(count) != len(roleIds) {
+				return ErrRoleInvalid
+			}
+		}
+		if err := tx.Where("user_id = ?", userId).Delete(&UserRole{}).Error; err != nil {
+			return err
+		}
+		for _, roleId := range roleIds {
+			if err := tx.Create(&UserRole{UserId: userId, RoleId: roleId}).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err == nil {
+		InvalidateUserAPITokens(userId)
+	}
+	return err
+}
+
+func DeleteUserRoles(userId int) error {
+	err := DB.Where("user_id = ?", userId).Delete(&UserRole{}).Error
+	if err == nil {
+		InvalidateUserAPITokens(userId)
+	}
+	return err
+}
+
+// GetUserPermissions returns the permissions of the built-in role of the numeric role and of the assigned roles
+func GetUserPermissions(userId int, role int) ([]string, error) {
+	var roles []*Role
+	err := DB.Where("name = ?", BuiltinRoleName(role)).
+		Or("id IN (?)", DB.Model(&UserRole{}).Select("role_id").Where("user_id = ?", userId)).
+		Find(&roles).Error
+	if err != nil {
+		return nil, err
+	}
+	seen := make(map[string]bool)
+	var permissions []string
+	for _, r := range roles {
+		for _, p := range r.GetPermissions() {
+			if !seen[p] {
+				seen[p] = true
+				permissions = append(permissions, p)
+			}
+		}
+	}
+	return permissions, nil
+}
+
+func UserHasPermission(userId int, role int, permission string) bool {
+	permissions, err := GetUserPermissions(userId, role)
+	if err != nil {
+		common.SysError("failed to get permissions of user: " + err.Error())
+		return false
+	}
+	return HasPermission(permissions, permission)
+}
+
+func HasPermission(permissions []string, permission string) bool {
+	for _, p := range permissions {
+		if p == permission || p == common.PermissionAll {
+			return true
+		}
+	}
+	return false
+}
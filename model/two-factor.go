@@ -0,0 +1,167 @@
+package model
+
+import (
+	"errors"
+	"strings"
+	"time"
+	"wechat-server/common"
+
+	"gorm.io/gorm"
+)
+
+// TwoFactor holds the TOTP secret of a user, it's only in effect once Enabled after the user confirmed a code
+type TwoFactor struct {
+	UserId        int    `json:"user_id" gorm:"primaryKey;autoIncrement:false"`
+	Secret        string `json:"-"`
+	Enabled       bool   `json:"enabled"`
+	LastCounter   int64  `json:"-" gorm:"bigint"`    // the last TOTP time step used, to reject replayed codes
+	RecoveryCodes string `json:"-" gorm:"type:text"` // SHA-256 of the unused recovery codes, space separated
+	EnabledTime   int64  `json:"enabled_time" gorm:"bigint"`
+}
+
+const (
+	recoveryCodeCount  = 10
+	recoveryCodeLength = 10
+)
+
+var (
+	ErrTwoFactorNotSetup  = errors.New("尚未设置两步验证")
+	ErrTwoFactorEnabled   = errors.New("已启用两步验证，请先关闭")
+	ErrTwoFactorCodeWrong = errors.New("验证码错误")
+)
+
+func GetTwoFactor(userId int) (*TwoFactor, error) {
+	twoFactor := TwoFactor{}
+	err := DB.First(&twoFactor, "user_id = ?", userId).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &twoFactor, nil
+}
+
+// IsTwoFactorRequired tells whether the role must enable two-factor authentication
+func IsTwoFactorRequired(role int) bool {
+	return common.TwoFactorRequiredForAdmin && role >= common.RoleAdminUser
+}
+
+func IsTwoFactorEnabled(userId int) bool {
+	twoFactor, err := GetTwoFactor(userId)
+	return err == nil && twoFactor != nil && twoFactor.Enabled
+}
+
+// SetupTwoFactor generates a new pending secret, the enabled secret can't be replaced this way
+func SetupTwoFactor(userId int) (*TwoFactor, error) {
+	twoFactor, err := GetTwoFactor(userId)
+	if err != nil {
+		return nil, err
+	}
+	if twoFactor != nil && twoFactor.Enabled {
+		return nil, ErrTwoFactorEnabled
+	}
+	twoFactor = &TwoFactor{
+		UserId: userId,
+		Secret: common.GenerateTOTPSecret(),
+	}
+	return twoFactor, DB.Save(twoFactor).Error
+}
+
+// EnableTwoFactor confirms the pending secret with a code and returns the recovery codes
+func EnableTwoFactor(userId int, code string) ([]string, error) {
+	twoFactor, err := GetTwoFactor(userId)
+	if err != nil {
+		return nil, err
+	}
+	if twoFactor == nil {
+		return nil, ErrTwoFactorNotSetup
+	}
+	if twoFactor.Enabled {
+		return nil, ErrTwoFactorEnabled
+	}
+	counter, ok := common.ValidateTOTP(twoFactor.Secret, code, time.Now(), twoFactor.LastCounter)
+	if !ok {
+		return nil, ErrTwoFactorCodeWrong
+	}
+	codes, hashes := generateRecoveryCodes()
+	twoFactor.Enabled = true
+	twoFactor.LastCounter = counter
+	twoFactor.RecoveryCodes = hashes
+	twoFactor.EnabledTime = time.Now().Unix()
+	return codes, DB.Save(twoFactor).Error
+}
+
+func DisableTwoFactor(userId int) error {
+	return DB.Where("user_id = ?", userId).Delete(&TwoFactor{}).Error
+}
+
+func generateRecoveryCodes() ([]string, string) {
+	codes := make([]string, recoveryCodeCount)
+	hashes := make([]string, recoveryCodeCount)
+	for i := range codes {
+		codes[i] = common.GenerateVerificationCode(recoveryCodeLength)
+		hashes[i] = common.Secret2Hash(codes[i])
+	}
+	return codes, strings.Join(hashes, " ")
+}
+
+// RegenerateRecoveryCodes replaces all the recovery codes
+func RegenerateRecoveryCodes(userId int) ([]string, error) {
+	codes, hashes := generateRecoveryCodes()
+	result := DB.Model(&TwoFactor{}).Where("user_id = ? AND enabled = ?", userId, true).Update("recovery_codes", hashes)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	if result.RowsAffected == 0 {
+		return nil, ErrTwoFactorNotSetup
+	}
+	return codes, nil
+}
+
+// VerifyTwoFactor accepts a TOTP code or a recovery code, a recovery code is consumed on use
+func VerifyTwoFactor(userId int, code string) error {
+	code = strings.TrimSpace(code)
+	return DB.Transaction(func(tx *gorm.DB) error {
+		twoFactor := TwoFactor{}
+		if err := tx.First(&twoFactor, "user_id = ? AND enabled = ?", userId, true).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return ErrTwoFactorNotSetup
+			}
+			return err
+		}
+		if counter, ok := common.ValidateTOTP(twoFactor.Secret, code, time.Now(), twoFactor.LastCounter); ok {
+			// The condition makes concurrent uses of the same code fail
+			result := tx.Model(&twoFactor).Where("last_counter = ?", twoFactor.LastCounter).Update("last_counter", counter)
+			if result.Error != nil {
+				return result.Error
+			}
+			if result.RowsAffected == 0 {
+				return ErrTwoFactorCodeWrong
+			}
+			return nil
+		}
+		hash := common.Secret2Hash(strings.ToLower(code))
+		hashes := strings.Fields(twoFactor.RecoveryCodes)
+		for i, h := range hashes {
+			if h != hash {
+				continue
+			}
+			remaining := strings.Join(append(hashes[:i:i], hashes[i+1:]...), " ")
+			result := tx.Model(&twoFactor).Where("recovery_codes = ?", twoFactor.RecoveryCodes).Update("recovery_codes", remaining)
+			if result.Error != nil {
+				return result.Error
+			}
+			if result.RowsAffected == 0 {
+				return ErrTwoFactorCodeWrong
+			}
+			return nil
+		}
+		return ErrTwoFactorCodeWrong
+	})
+}
+
+// CountRecoveryCodes returns the number of unused recovery codes
+func (twoFactor *TwoFactor) CountRecoveryCodes() int {
+	return len(strings.Fields(twoFactor.RecoveryCodes))
+}
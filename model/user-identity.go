@@ -0,0 +1,168 @@
+package model
+
+import (
+	"encoding/json"
+	"errors"
+	"strings"
+	"time"
+	"wechat-server/common"
+
+	"gorm.io/gorm"
+)
+
+// UserIdentity links an external account to a local user, a (provider, subject) pair belongs to one user only
+// and a user has at most one identity per provider.
+type UserIdentity struct {
+	Id          int    `json:"id"`
+	UserId      int    `json:"user_id" gorm:"index"`
+	Provider    string `json:"provider" gorm:"size:32;uniqueIndex:idx_identity_provider_subject"`
+	Subject     string `json:"subject" gorm:"size:191;uniqueIndex:idx_identity_provider_subject"`
+	Metadata    string `json:"metadata" gorm:"type:text"` // JSON, e.g. the nickname or login name
+	CreatedTime int64  `json:"created_time" gorm:"bigint"`
+}
+
+var (
+	ErrIdentityTaken      = errors.New("该账户已被其他用户绑定")
+	ErrIdentityExists     = errors.New("已绑定同类型的账户，请先解除绑定")
+	ErrLastLoginMethod    = errors.New("这是该用户唯一的登录方式，无法解除绑定")
+	ErrIdentityNotLinked  = errors.New("未绑定该类型的账户")
+	ErrIdentityEmailTaken = errors.New("该账户的邮箱已被其他用户使用，请登录该用户后在个人设置中绑定")
+	ErrIdentityLegacyUser = errors.New("已存在同名的未绑定用户，如为本人，请通过其他方式登录后在个人设置中绑定 GitHub 账户")
+)
+
+// NormalizeIdentitySubject Emails are compared case-insensitively
+func NormalizeIdentitySubject(provider string, subject string) string {
+	if provider == common.IdentityProviderEmail {
+		return strings.ToLower(strings.TrimSpace(subject))
+	}
+	return subject
+}
+
+func EncodeIdentityMetadata(metadata map[string]string) string {
+	if len(metadata) == 0 {
+		return ""
+	}
+	data, _ := json.Marshal(metadata)
+	return string(data)
+}
+
+func GetUserIdentities(userId int) (identities []*UserIdentity, err error) {
+	err = DB.Where("user_id = ?", userId).Order("id").Find(&identities).Error
+	return identities, err
+}
+
+// GetUserIdentity returns nil if the identity isn't linked to any user
+func GetUserIdentity(provider string, subject string) (*UserIdentity, error) {
+	identity := UserIdentity{}
+	err := DB.Where("provider = ? AND subject = ?", provider, NormalizeIdentitySubject(provider, subject)).
+		First(&identity).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &identity, nil
+}
+
+// GetUserIdentityByProvider returns nil if the user has no identity of the provider
+func GetUserIdentityByProvider(userId int, provider string) (*UserIdentity, error) {
+	return getUserIdentityByProvider(DB, userId, provider)
+}
+
+func getUserIdentityByProvider(tx *gorm.DB, userId int, provider string) (*UserIdentity, error) {
+	identity := UserIdentity{}
+	err := tx.Where("user_id = ? AND provider = ?", userId, provider).First(&identity).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &identity, nil
+}
+
+// LinkUserIdentity links the identity to the user, linking an identity again to its own user only updates the metadata
+func LinkUserIdentity(userId int, provider string, subject string, metadata string) error {
+	subject = NormalizeIdentitySubject(provider, subject)
+	return DB.Transaction(func(tx *gorm.DB) error {
+		identity := UserIdentity{}
+		err := tx.Where("provider = ? AND subject = ?", provider, subject).First(&identity).Error
+		if err == nil {
+			if identity.UserId != userId {
+				return ErrIdentityTaken
+			}
+			return tx.Model(&identity).Update("metadata", metadata).Error
+		}
+		if !errors.Is(err, gorm.ErrRecordNotFound) {
+			return err
+		}
+		existing, err := getUserIdentityByProvider(tx, userId, provider)
+		if err != nil {
+			return err
+		}
+		if existing != nil {
+			return ErrIdentityExists
+		}
+		return tx.Create(&UserIdentity{
+			UserId:      userId,
+			Provider:    provider,
+			Subject:     subject,
+			Metadata:    metadata,
+			CreatedTime: time.Now().Unix(),
+		}).Error
+	})
+}
+
+// UnlinkUserIdentity refuses to remove the last way a user without password can log in
+func UnlinkUserIdentity(userId int, provider string) error {
+	return DB.Transaction(func(tx *gorm.DB) error {
+		identity, err := getUserIdentityByProvider(tx, userId, provider)
+		if err != nil {
+			return err
+		}
+		if identity == nil {
+			return ErrIdentityNotLinked
+		}
+		user := User{}
+		if err := tx.Select("id", "password").First(&user, "id = ?", userId).Error; err != nil {
+			return err
+		}
+		var count int64
+		if err := tx.Model(&UserIdentity{}).Where("user_id = ? AND provider <> ?", userId, common.IdentityProviderEmail).
+			Count(&count).Error; err != nil {
+			return err
+		}
+		// The email identity alone can't log in, it only resets the password
+		if user.Password == "" && provider != common.IdentityProviderEmail && count <= 1 {
+			return ErrLastLoginMethod
+		}
+		if provider == common.IdentityProviderEmail {
+			if err := tx.Model(&user).Update("email", "").Error; err != nil {
+				return err
+			}
+		}
+		return tx.Delete(identity).Error
+	})
+}
+
+func DeleteUserIdentities(userId int) error {
+	return DB.Where("user_id = ?", userId).Delete(&UserIdentity{}).Error
+}
+
+// migrateUserIdentities links the emails of existing users, conflicts are logged and skipped.
+func migrateUserIdentities() error {
+	var users []User
+	err := DB.Select("id", "email").Where("email <> ''").
+		Where("id NOT IN (?)", DB.Model(&UserIdentity{}).Select("user_id").Where("provider = ?", common.IdentityProviderEmail)).
+		Find(&users).Error
+	if err != nil {
+		return err
+	}
+	for _, user := range users {
+		if err := LinkUserIdentity(user.Id, common.IdentityProviderEmail, user.Email, ""); err != nil {
+			common.SysError("failed to migrate email identity " + user.Email + ": " + err.Error())
+		}
+	}
+	return nil
+}
@@ -0,0 +1,112 @@
+package model
+
+import (
+	"errors"
+	"time"
+	"wechat-server/common"
+
+	"gorm.io/gorm"
+)
+
+// UserSession is a login session of the web UI, the cookie only carries the session key so sessions can be
+// listed and revoked, and the role and status of the user are always read from the database.
+// Only the SHA-256 of the key is stored.
+type UserSession struct {
+	Id           int    `json:"id"`
+	UserId       int    `json:"user_id" gorm:"index"`
+	Hash         string `json:"-" gorm:"uniqueIndex;size:64"`
+	IP           string `json:"ip"`
+	UserAgent    string `json:"user_agent" gorm:"type:text"`
+	CreatedTime  int64  `json:"created_time" gorm:"bigint"`
+	LastSeenTime int64  `json:"last_seen_time" gorm:"bigint"`
+	ExpiredTime  int64  `json:"expired_time" gorm:"bigint;index"`
+	Current      bool   `json:"current" gorm:"-:all"` // whether it's the session of the request
+}
+
+// The same as the default max age of the session cookie
+const userSessionLifetime = int64(30 * 24 * 60 * 60)
+
+const userSessionLastSeenInterval = int64(60)
+
+// CreateUserSession returns the key of the new session, expired sessions of the user are removed on the way
+func CreateUserSession(userId int, ip string, userAgent string) (string, error) {
+	now := time.Now().Unix()
+	if err := DB.Where("user_id = ? AND expired_time <= ?", userId, now).Delete(&UserSession{}).Error; err != nil {
+		common.SysError("failed to delete expired sessions: " + err.Error())
+	}
+	key := common.GenerateVerificationCode(0) + common.GenerateVerificationCode(0)
+	session := UserSession{
+		UserId:       userId,
+		Hash:         common.Secret2Hash(key),
+		IP:           ip,
+		UserAgent:    userAgent,
+		CreatedTime:  now,
+		LastSeenTime: now,
+		ExpiredTime:  now + userSessionLifetime,
+	}
+	return key, DB.Create(&session).Error
+}
+
+// ValidateUserSession returns the session and its user, or nil if the session is revoked or expired
+func ValidateUserSession(key string, ip string) (*UserSession, *User) {
+	if key == "" {
+		return nil, nil
+	}
+	session := UserSession{}
+	if err := DB.First(&session, "hash = ?", common.Secret2Hash(key)).Error; err != nil {
+		if !errors.Is(err, gorm.ErrRecordNotFound) {
+			common.SysError("failed to get session: " + err.Error())
+		}
+		return nil, nil
+	}
+	now := time.Now().Unix()
+	if session.ExpiredTime <= now {
+		return nil, nil
+	}
+	user, err := GetUserById(session.UserId, false)
+	if err != nil {
+		return nil, nil
+	}
+	if now-session.LastSeenTime >= userSessionLastSeenInterval || session.IP != ip {
+		session.LastSeenTime = now
+		session.IP = ip
+		common.GoAsync(func() {
+			updateUserSessionLastSeen(session.Id, now, ip)
+		})
+	}
+	return &session, user
+}
+
+func updateUserSessionLastSeen(id int, lastSeenTime int64, ip string) {
+	err := DB.Model(&UserSession{}).Where("id = ?", id).
+		Updates(map[string]interface{}{"last_seen_time": lastSeenTime, "ip": ip}).Error
+	if err != nil {
+		common.SysError("failed to update last seen time of session: " + err.Error())
+	}
+}
+
+func GetUserSessions(userId int) (sessions []*UserSession, err error) {
+	err = DB.Where("user_id = ? AND expired_time > ?", userId, time.Now().Unix()).
+		Order("last_seen_time desc").Find(&sessions).Error
+	return sessions, err
+}
+
+func DeleteUserSession(userId int, id int) error {
+	result := DB.Where("id = ? AND user_id = ?", id, userId).Delete(&UserSession{})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+	return nil
+}
+
+func DeleteUserSessionByKey(key string) error {
+	return DB.Where("hash = ?", common.Secret2Hash(key)).Delete(&UserSession{}).Error
+}
+
+// DeleteUserSessions signs the user out everywhere, except the session with the id exceptId if it's not 0
+func DeleteUserSessions(userId int, exceptId int) error {
+	return DB.Where("user_id = ? AND id <> ?", userId, exceptId).Delete(&UserSession{}).Error
+}
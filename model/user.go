@@ -13,9 +13,9 @@ type User struct {
 	DisplayName      string `json:"display_name"`
 	Role             int    `json:"role" gorm:"type:int;default:1"`   // admin, common
 	Status           int    `json:"status" gorm:"type:int;default:1"` // enabled, disabled
-	Token            string `json:"token;" gorm:"index"`
 	Email            string `json:"email" gorm:"index"`
 	VerificationCode string `json:"verification_code" gorm:"-:all"`
+	Locked           bool   `json:"locked" gorm:"-:all"` // locked out after too many failed logins
 }
 
 func GetAllUsers() (users []*User, err error) {
@@ -37,7 +37,31 @@ func GetUserById(id int, selectAll bool) (*User, error) {
 func DeleteUserById(id int) (err error) {
 	user := User{Id: id}
 	err = DB.Delete(&user).Error
-	return err
+	if err != nil {
+		return err
+	}
+	return deleteUserRelations(id)
+}
+
+// deleteUserRelations deletes what belongs to a deleted user
+func deleteUserRelations(id int) (err error) {
+	err = DeleteUserIdentities(id)
+	if err != nil {
+		return err
+	}
+	err = DeleteUserRoles(id)
+	if err != nil {
+		return err
+	}
+	err = DeleteUserAPITokens(id)
+	if err != nil {
+		return err
+	}
+	err = DeleteUserSessions(id, 0)
+	if err != nil {
+		return err
+	}
+	return DisableTwoFactor(id)
 }
 
 func QueryUsers(query string, startIdx int) (users []*User, err error) {
@@ -58,6 +82,18 @@ func (user *User) Insert() error {
 	return err
 }
 
+// InsertWithIdentity creates the user together with its first identity
+func (user *User) InsertWithIdentity(provider string, subject string, metadata string) error {
+	if err := user.Insert(); err != nil {
+		return err
+	}
+	if err := LinkUserIdentity(user.Id, provider, subject, metadata); err != nil {
+		DB.Delete(user)
+		return err
+	}
+	return nil
+}
+
 func (user *User) Update(updatePassword bool) error {
 	var err error
 	if updatePassword {
@@ -67,13 +103,20 @@ func (user *User) Update(updatePassword bool) error {
 		}
 	}
 	err = DB.Model(user).Updates(user).Error
+	InvalidateUserAPITokens(user.Id)
+	if err == nil && user.Status == common.UserStatusDisabled {
+		err = DeleteUserSessions(user.Id, 0)
+	}
 	return err
 }
 
 func (user *User) Delete() error {
 	var err error
 	err = DB.Delete(user).Error
-	return err
+	if err != nil {
+		return err
+	}
+	return deleteUserRelations(user.Id)
 }
 
 // ValidateAndFill check password & user status
@@ -94,20 +137,18 @@ func (user *User) FillUserByEmail() {
 	DB.Where(User{Email: user.Email}).First(user)
 }
 
-func (user *User) FillUserByUsername() {
-	DB.Where(User{Username: user.Username}).First(user)
+// FillUserByIdentity fills the user linked to the identity, false means there is none
+func (user *User) FillUserByIdentity(provider string, subject string) (bool, error) {
+	identity, err := GetUserIdentity(provider, subject)
+	if err != nil || identity == nil {
+		return false, err
+	}
+	err = DB.First(user, "id = ?", identity.UserId).Error
+	return err == nil, err
 }
 
-func ValidateUserToken(token string) (user *User) {
-	if token == "" {
-		return nil
-	}
-	token = strings.Replace(token, "Bearer ", "", 1)
-	user = &User{}
-	if DB.Where("token = ?", token).First(user).RowsAffected == 1 {
-		return user
-	}
-	return nil
+func (user *User) FillUserByUsername() {
+	DB.Where(User{Username: user.Username}).First(user)
 }
 
 func IsEmailAlreadyTaken(email string) bool {
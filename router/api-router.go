@@ -2,6 +2,7 @@ package router
 
 import (
 	"github.com/gin-gonic/gin"
+	"wechat-server/common"
 	"wechat-server/controller"
 	"wechat-server/middleware"
 )
@@ -14,15 +15,27 @@ func SetApiRouter(router *gin.Engine) {
 		apiRouter.GET("/notice", controller.GetNotice)
 		apiRouter.GET("/wechat", controller.WeChatVerification)
 		apiRouter.POST("/wechat", controller.ProcessWeChatMessage)
+		apiRouter.GET("/wechat/oauth/callback", controller.WeChatOAuthCallback)
+		apiRouter.GET("/wechat/login", controller.HostedLogin)
+		apiRouter.GET("/wechat/login/status", controller.GetHostedLoginStatus)
+		apiRouter.GET("/wechat/login/confirm", controller.GetLoginConfirm)
+		apiRouter.POST("/wechat/login/confirm", controller.PostLoginConfirm)
 		apiRouter.GET("/verification", middleware.CriticalRateLimit(), controller.SendEmailVerification)
 		apiRouter.GET("/reset_password", middleware.CriticalRateLimit(), controller.SendPasswordResetEmail)
 		apiRouter.GET("/user/reset", controller.SendNewPasswordEmail)
 		apiRouter.GET("/oauth/github", controller.GitHubOAuth)
+		apiRouter.GET("/oauth/github/bind", middleware.CriticalRateLimit(), middleware.UserAuth(), middleware.NoTokenAuth(), controller.GitHubBind)
+		apiRouter.GET("/oauth/email/bind", middleware.CriticalRateLimit(), middleware.UserAuth(), middleware.NoTokenAuth(), controller.EmailBind)
+		apiRouter.GET("/oauth/wechat", middleware.CriticalRateLimit(), controller.WeChatAuth)
+		apiRouter.GET("/oauth/wechat/qrcode", middleware.CriticalRateLimit(), controller.GetWeChatAuthQRCode)
+		apiRouter.GET("/oauth/wechat/status", controller.GetWeChatAuthStatus)
+		apiRouter.GET("/oauth/wechat/bind", middleware.CriticalRateLimit(), middleware.UserAuth(), middleware.NoTokenAuth(), controller.BindWeChat)
 
 		userRoute := apiRouter.Group("/user")
 		{
 			userRoute.POST("/register", middleware.CriticalRateLimit(), controller.Register)
 			userRoute.POST("/login", middleware.CriticalRateLimit(), controller.Login)
+			userRoute.POST("/login/2fa", middleware.CriticalRateLimit(), controller.LoginTwoFactor)
 			userRoute.GET("/logout", controller.Logout)
 
 			selfRoute := userRoute.Group("/")
@@ -31,39 +44,87 @@ func SetApiRouter(router *gin.Engine) {
 				selfRoute.GET("/self", controller.GetSelf)
 				selfRoute.PUT("/self", controller.UpdateSelf)
 				selfRoute.DELETE("/self", controller.DeleteSelf)
-				selfRoute.GET("/token", controller.GenerateToken)
+				selfRoute.GET("/self/tokens", controller.GetSelfAPITokens)
+				selfRoute.POST("/self/tokens", controller.CreateSelfAPIToken)
+				selfRoute.DELETE("/self/tokens/:id", controller.DeleteSelfAPIToken)
+				selfRoute.GET("/self/identities", controller.GetSelfIdentities)
+				selfRoute.DELETE("/self/identities/:provider", controller.UnlinkSelfIdentity)
+				selfRoute.GET("/self/permissions", controller.GetSelfPermissions)
+				selfRoute.GET("/self/sessions", controller.GetSelfSessions)
+				selfRoute.DELETE("/self/sessions", controller.DeleteSelfSessions)
+				selfRoute.DELETE("/self/sessions/:id", controller.DeleteSelfSession)
+				selfRoute.GET("/self/2fa", controller.GetSelfTwoFactor)
+				selfRoute.POST("/self/2fa/setup", controller.SetupSelfTwoFactor)
+				selfRoute.POST("/self/2fa/enable", controller.EnableSelfTwoFactor)
+				selfRoute.POST("/self/2fa/disable", middleware.CriticalRateLimit(), controller.DisableSelfTwoFactor)
+				selfRoute.POST("/self/2fa/recovery_codes", middleware.CriticalRateLimit(), controller.RegenerateSelfRecoveryCodes)
 			}
 
 			adminRoute := userRoute.Group("/")
-			adminRoute.Use(middleware.AdminAuth(), middleware.NoTokenAuth())
+			adminRoute.Use(middleware.UserAuth(), middleware.NoTokenAuth())
 			{
-				adminRoute.GET("/", controller.GetAllUsers)
-				adminRoute.GET("/:id", controller.GetUser)
-				adminRoute.POST("/", controller.CreateUser)
-				adminRoute.POST("/manage", controller.ManageUser)
-				adminRoute.PUT("/", controller.UpdateUser)
-				adminRoute.DELETE("/:id", controller.DeleteUser)
+				adminRoute.GET("/", middleware.RequirePermission(common.PermissionUsersRead), controller.GetAllUsers)
+				adminRoute.GET("/:id", middleware.RequirePermission(common.PermissionUsersRead), controller.GetUser)
+				adminRoute.POST("/", middleware.RequirePermission(common.PermissionUsersManage), controller.CreateUser)
+				adminRoute.POST("/manage", middleware.RequirePermission(common.PermissionUsersManage), controller.ManageUser)
+				adminRoute.PUT("/", middleware.RequirePermission(common.PermissionUsersManage), controller.UpdateUser)
+				adminRoute.DELETE("/:id", middleware.RequirePermission(common.PermissionUsersManage), controller.DeleteUser)
+				adminRoute.GET("/:id/sessions", middleware.RequirePermission(common.PermissionUsersRead), controller.GetUserSessions)
+				adminRoute.DELETE("/:id/sessions", middleware.RequirePermission(common.PermissionUsersManage), controller.DeleteUserSessions)
+				adminRoute.DELETE("/:id/2fa", middleware.RequirePermission(common.PermissionUsersManage), controller.ResetUserTwoFactor)
 			}
 		}
 		optionRoute := apiRouter.Group("/option")
-		optionRoute.Use(middleware.RootAuth(), middleware.NoTokenAuth())
+		optionRoute.Use(middleware.UserAuth(), middleware.NoTokenAuth())
 		{
-			optionRoute.GET("/", controller.GetOptions)
-			optionRoute.PUT("/", controller.UpdateOption)
+			optionRoute.GET("/", middleware.RequirePermission(common.PermissionOptionsRead), controller.GetOptions)
+			optionRoute.GET("/schema", middleware.RequirePermission(common.PermissionOptionsRead), controller.GetOptionSchema)
+			optionRoute.PUT("/", middleware.RequirePermission(common.PermissionOptionsWrite), controller.UpdateOption)
+		}
+		roleRoute := apiRouter.Group("/role")
+		roleRoute.Use(middleware.UserAuth(), middleware.NoTokenAuth(), middleware.RequirePermission(common.PermissionRolesManage))
+		{
+			roleRoute.GET("/", controller.GetAllRoles)
+			roleRoute.GET("/permissions", controller.GetPermissions)
+			roleRoute.POST("/", controller.CreateRole)
+			roleRoute.PUT("/", controller.UpdateRole)
+			roleRoute.DELETE("/:id", controller.DeleteRole)
+			roleRoute.GET("/user/:id", controller.GetUserRoles)
+			roleRoute.PUT("/user/:id", controller.UpdateUserRoles)
+		}
+		auditRoute := apiRouter.Group("/audit")
+		auditRoute.Use(middleware.UserAuth(), middleware.NoTokenAuth(), middleware.RequirePermission(common.PermissionAuditRead))
+		{
+			auditRoute.GET("/", controller.GetAuditEvents)
+			auditRoute.GET("/export", controller.ExportAuditEvents)
+		}
+		clientRoute := apiRouter.Group("/client")
+		clientRoute.Use(middleware.UserAuth(), middleware.NoTokenAuth(), middleware.RequirePermission(common.PermissionClientsManage))
+		{
+			clientRoute.GET("/", controller.GetAllClients)
+			clientRoute.GET("/:id", controller.GetClient)
+			clientRoute.POST("/", controller.CreateClient)
+			clientRoute.PUT("/", controller.UpdateClient)
+			clientRoute.POST("/:id/secret", controller.ResetClientSecret)
+			clientRoute.DELETE("/:id", controller.DeleteClient)
 		}
 		fileRoute := apiRouter.Group("/file")
 		{
 			fileRoute.GET("/:id", middleware.DownloadRateLimit(), controller.DownloadFile)
-			fileRoute.POST("/", middleware.UserAuth(), middleware.UploadRateLimit(), controller.UploadFile)
-			fileRoute.DELETE("/:id", middleware.UserAuth(), controller.DeleteFile)
+			fileRoute.POST("/", middleware.UserAuth(), middleware.RequireTokenScope(common.TokenScopeFilesWrite), middleware.UploadRateLimit(), controller.UploadFile)
+			fileRoute.DELETE("/:id", middleware.UserAuth(), middleware.RequireTokenScope(common.TokenScopeFilesWrite), middleware.RequirePermission(common.PermissionFilesDelete), controller.DeleteFile)
 		}
 		wechatRoute := apiRouter.Group("/wechat")
-		wechatRoute.Use(middleware.AdminAuth(), middleware.TokenOnlyAuth())
+		wechatRoute.Use(middleware.ClientAuth())
 		{
-			wechatRoute.GET("/access_token", controller.GetAccessToken)
-			wechatRoute.GET("/user", controller.GetUserID)
-			wechatRoute.POST("/create_login_qrcode", controller.CreateLoginQRCode)
-			wechatRoute.GET("/login_status", controller.GetLoginStatus)
+			wechatRoute.GET("/access_token", middleware.RequireClientScope(common.ClientScopeAccessTokenRead), controller.GetAccessToken)
+			wechatRoute.GET("/user", middleware.RequireClientScope(common.ClientScopeUserResolve), controller.GetUserID)
+			wechatRoute.POST("/auth_code/exchange", middleware.RequireClientScope(common.ClientScopeUserResolve), controller.ExchangeAuthCode)
+			wechatRoute.POST("/create_login_qrcode", middleware.RequireClientScope(common.ClientScopeLoginCreate), controller.CreateLoginQRCode)
+			wechatRoute.POST("/create_oauth_url", middleware.RequireClientScope(common.ClientScopeLoginCreate), controller.CreateOAuthURL)
+			wechatRoute.GET("/login_status", middleware.RequireClientScope(common.ClientScopeLoginCreate), controller.GetLoginStatus)
+			wechatRoute.GET("/login_status/stream", middleware.RequireClientScope(common.ClientScopeLoginCreate), controller.GetLoginStatusStream)
+			wechatRoute.GET("/login_status/ws", middleware.RequireClientScope(common.ClientScopeLoginCreate), controller.GetLoginStatusWebSocket)
 		}
 	}
 }
@@ -0,0 +1,11 @@
+package router
+
+import (
+	"github.com/gin-gonic/gin"
+	"wechat-server/controller"
+)
+
+func SetHealthRouter(router *gin.Engine) {
+	router.GET("/healthz", controller.Healthz)
+	router.GET("/readyz", controller.Readyz)
+}
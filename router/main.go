@@ -7,5 +7,8 @@ import (
 
 func SetRouter(router *gin.Engine, buildFS embed.FS, indexPage []byte) {
 	SetApiRouter(router)
+	SetOIDCRouter(router)
+	SetMetricsRouter(router)
+	SetHealthRouter(router)
 	setWebRouter(router, buildFS, indexPage)
 }
@@ -0,0 +1,11 @@
+package router
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"wechat-server/middleware"
+)
+
+func SetMetricsRouter(router *gin.Engine) {
+	router.GET("/metrics", middleware.MetricsAuth(), gin.WrapH(promhttp.Handler()))
+}
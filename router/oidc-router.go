@@ -0,0 +1,20 @@
+package router
+
+import (
+	"github.com/gin-gonic/gin"
+	"wechat-server/controller"
+	"wechat-server/middleware"
+)
+
+func SetOIDCRouter(router *gin.Engine) {
+	router.GET("/.well-known/openid-configuration", middleware.GlobalAPIRateLimit(), controller.OIDCDiscovery)
+	oidcRouter := router.Group("/oidc")
+	oidcRouter.Use(middleware.GlobalAPIRateLimit())
+	{
+		oidcRouter.GET("/authorize", controller.OIDCAuthorize)
+		oidcRouter.POST("/token", middleware.CriticalRateLimit(), controller.OIDCToken)
+		oidcRouter.GET("/userinfo", controller.OIDCUserInfo)
+		oidcRouter.POST("/userinfo", controller.OIDCUserInfo)
+		oidcRouter.GET("/jwks", controller.OIDCJWKS)
+	}
+}
@@ -0,0 +1,159 @@
+import React, { useEffect, useState } from 'react';
+import { Button, Form, Header, Modal, Table } from 'semantic-ui-react';
+import { API, copy, showError, showSuccess } from '../helpers';
+
+const scopeOptions = [
+  'access_token:read',
+  'login:create',
+  'user:resolve',
+  'files:write',
+].map((scope) => ({ key: scope, value: scope, text: scope }));
+
+function renderTimestamp(timestamp) {
+  if (!timestamp) return '-';
+  return new Date(timestamp * 1000).toLocaleString();
+}
+
+const APITokenSetting = () => {
+  const [tokens, setTokens] = useState([]);
+  const [showCreate, setShowCreate] = useState(false);
+  const [inputs, setInputs] = useState({
+    name: '',
+    scopes: [],
+    expired_date: '',
+  });
+
+  const loadTokens = async () => {
+    const res = await API.get('/api/user/self/tokens');
+    const { success, message, data } = res.data;
+    if (success) {
+      setTokens(data || []);
+    } else {
+      showError(message);
+    }
+  };
+
+  useEffect(() => {
+    loadTokens().then();
+  }, []);
+
+  const handleInputChange = (e, { name, value }) => {
+    setInputs((inputs) => ({ ...inputs, [name]: value }));
+  };
+
+  const createToken = async () => {
+    if (inputs.name === '') return;
+    let expiredTime = 0;
+    if (inputs.expired_date !== '') {
+      expiredTime = Math.floor(Date.parse(inputs.expired_date) / 1000);
+    }
+    const res = await API.post('/api/user/self/tokens', {
+      name: inputs.name,
+      scopes: inputs.scopes.join(' '),
+      expired_time: expiredTime,
+    });
+    const { success, message, data } = res.data;
+    if (success) {
+      await copy(data.key);
+      showSuccess(`令牌已创建并已复制到剪切板，仅显示一次：${data.key}`);
+      setShowCreate(false);
+      setInputs({ name: '', scopes: [], expired_date: '' });
+      await loadTokens();
+    } else {
+      showError(message);
+    }
+  };
+
+  const deleteToken = async (id) => {
+    const res = await API.delete(`/api/user/self/tokens/${id}`);
+    const { success, message } = res.data;
+    if (success) {
+      showSuccess('令牌已删除');
+      await loadTokens();
+    } else {
+      showError(message);
+    }
+  };
+
+  return (
+    <>
+      <Header as="h4">访问令牌</Header>
+      <Table basic>
+        <Table.Header>
+          <Table.Row>
+            <Table.HeaderCell>名称</Table.HeaderCell>
+            <Table.HeaderCell>令牌</Table.HeaderCell>
+            <Table.HeaderCell>权限范围</Table.HeaderCell>
+            <Table.HeaderCell>过期时间</Table.HeaderCell>
+            <Table.HeaderCell>最后使用</Table.HeaderCell>
+            <Table.HeaderCell>操作</Table.HeaderCell>
+          </Table.Row>
+        </Table.Header>
+        <Table.Body>
+          {tokens.map((token) => (
+            <Table.Row key={token.id}>
+              <Table.Cell>{token.name}</Table.Cell>
+              <Table.Cell>{token.prefix}...</Table.Cell>
+              <Table.Cell>{token.scopes}</Table.Cell>
+              <Table.Cell>
+                {token.expired_time ? renderTimestamp(token.expired_time) : '永不过期'}
+              </Table.Cell>
+              <Table.Cell>
+                {renderTimestamp(token.last_used_time)}
+                {token.last_used_ip ? ` (${token.last_used_ip})` : ''}
+              </Table.Cell>
+              <Table.Cell>
+                <Button
+                  size="small"
+                  negative
+                  onClick={() => deleteToken(token.id)}
+                >
+                  删除
+                </Button>
+              </Table.Cell>
+            </Table.Row>
+          ))}
+        </Table.Body>
+      </Table>
+      <Button onClick={() => setShowCreate(true)}>创建访问令牌</Button>
+      <Modal size="tiny" open={showCreate} onClose={() => setShowCreate(false)}>
+        <Modal.Header>创建访问令牌</Modal.Header>
+        <Modal.Content>
+          <Form>
+            <Form.Input
+              label="名称"
+              name="name"
+              value={inputs.name}
+              onChange={handleInputChange}
+            />
+            <Form.Dropdown
+              label="权限范围"
+              name="scopes"
+              fluid
+              multiple
+              selection
+              options={scopeOptions}
+              value={inputs.scopes}
+              onChange={handleInputChange}
+            />
+            <Form.Input
+              label="过期时间（留空表示永不过期）"
+              name="expired_date"
+              type="datetime-local"
+              value={inputs.expired_date}
+              onChange={handleInputChange}
+            />
+          </Form>
+        </Modal.Content>
+        <Modal.Actions>
+          <Button onClick={() => setShowCreate(false)}>取消</Button>
+          <Button positive onClick={createToken}>
+            创建
+          </Button>
+        </Modal.Actions>
+      </Modal>
+    </>
+  );
+};
+
+export default APITokenSetting;
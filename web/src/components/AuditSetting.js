@@ -0,0 +1,138 @@
+import React, { useEffect, useState } from 'react';
+import { Button, Form, Table } from 'semantic-ui-react';
+import { API, showError } from '../helpers';
+
+function renderTimestamp(timestamp) {
+  return new Date(timestamp * 1000).toLocaleString();
+}
+
+const AuditSetting = () => {
+  const [events, setEvents] = useState([]);
+  const [loading, setLoading] = useState(false);
+  const [page, setPage] = useState(0);
+  const [filters, setFilters] = useState({
+    action: '',
+    actor_name: '',
+    target_id: '',
+    ip: '',
+  });
+
+  const buildQuery = () => {
+    const params = new URLSearchParams();
+    Object.keys(filters).forEach((key) => {
+      if (filters[key] !== '') params.set(key, filters[key]);
+    });
+    return params;
+  };
+
+  const loadEvents = async (p) => {
+    setLoading(true);
+    const params = buildQuery();
+    params.set('p', p);
+    const res = await API.get(`/api/audit/?${params.toString()}`);
+    const { success, message, data } = res.data;
+    if (success) {
+      setEvents(data || []);
+      setPage(p);
+    } else {
+      showError(message);
+    }
+    setLoading(false);
+  };
+
+  useEffect(() => {
+    loadEvents(0).then();
+  }, []);
+
+  const handleFilterChange = (e, { name, value }) => {
+    setFilters((filters) => ({ ...filters, [name]: value }));
+  };
+
+  const exportEvents = () => {
+    window.open(`/api/audit/export?${buildQuery().toString()}`);
+  };
+
+  return (
+    <>
+      <Form onSubmit={() => loadEvents(0)}>
+        <Form.Group widths="equal">
+          <Form.Input
+            placeholder="操作，例如 user.login"
+            name="action"
+            value={filters.action}
+            onChange={handleFilterChange}
+          />
+          <Form.Input
+            placeholder="操作者用户名"
+            name="actor_name"
+            value={filters.actor_name}
+            onChange={handleFilterChange}
+          />
+          <Form.Input
+            placeholder="对象 ID"
+            name="target_id"
+            value={filters.target_id}
+            onChange={handleFilterChange}
+          />
+          <Form.Input
+            placeholder="IP"
+            name="ip"
+            value={filters.ip}
+            onChange={handleFilterChange}
+          />
+        </Form.Group>
+        <Button type="submit" loading={loading}>
+          查询
+        </Button>
+        <Button type="button" onClick={exportEvents}>
+          导出 CSV
+        </Button>
+      </Form>
+      <Table basic compact size="small">
+        <Table.Header>
+          <Table.Row>
+            <Table.HeaderCell>时间</Table.HeaderCell>
+            <Table.HeaderCell>操作者</Table.HeaderCell>
+            <Table.HeaderCell>操作</Table.HeaderCell>
+            <Table.HeaderCell>对象</Table.HeaderCell>
+            <Table.HeaderCell>变更</Table.HeaderCell>
+            <Table.HeaderCell>IP</Table.HeaderCell>
+          </Table.Row>
+        </Table.Header>
+        <Table.Body>
+          {events.map((event) => (
+            <Table.Row key={event.id}>
+              <Table.Cell>{renderTimestamp(event.created_time)}</Table.Cell>
+              <Table.Cell>{event.actor_name}</Table.Cell>
+              <Table.Cell>{event.action}</Table.Cell>
+              <Table.Cell>
+                {event.target_type}
+                {event.target_id ? `:${event.target_id}` : ''}
+              </Table.Cell>
+              <Table.Cell style={{ wordBreak: 'break-all' }}>
+                {event.diff}
+              </Table.Cell>
+              <Table.Cell title={event.user_agent}>{event.ip}</Table.Cell>
+            </Table.Row>
+          ))}
+        </Table.Body>
+      </Table>
+      <Button
+        size="small"
+        disabled={page === 0}
+        onClick={() => loadEvents(page - 1)}
+      >
+        上一页
+      </Button>
+      <Button
+        size="small"
+        disabled={events.length === 0}
+        onClick={() => loadEvents(page + 1)}
+      >
+        下一页
+      </Button>
+    </>
+  );
+};
+
+export default AuditSetting;
@@ -1,7 +1,7 @@
 import React, { useContext, useEffect, useState } from 'react';
 import { Dimmer, Loader, Segment } from 'semantic-ui-react';
 import { useNavigate, useSearchParams } from 'react-router-dom';
-import { API, showError, showSuccess } from '../helpers';
+import { API, showError, showInfo, showSuccess } from '../helpers';
 import { UserContext } from '../context/User';
 
 const GitHubOAuth = () => {
@@ -13,12 +13,32 @@ const GitHubOAuth = () => {
 
   let navigate = useNavigate();
 
-  const sendCode = async (code, count) => {
+  const sendCode = async (code, state, count) => {
+    if (state === 'bind') {
+      const res = await API.get(`/api/oauth/github/bind?code=${code}`);
+      const { success, message } = res.data;
+      if (success) {
+        showSuccess('GitHub 账户绑定成功！');
+      } else {
+        showError(message);
+      }
+      navigate('/setting');
+      return;
+    }
     const res = await API.get(`/api/oauth/github?code=${code}`);
-    const { success, message, data } = res.data;
+    const { success, message, data, require_2fa_setup } = res.data;
     if (success) {
+      if (data.require_2fa) {
+        navigate('/login', { state: { require2FA: true } });
+        return;
+      }
       userDispatch({ type: 'login', payload: data });
       localStorage.setItem('user', JSON.stringify(data));
+      if (require_2fa_setup) {
+        navigate('/setting');
+        showInfo('管理员要求你的账户启用两步验证，请先完成设置');
+        return;
+      }
       navigate('/');
       showSuccess('登录成功！');
     } else {
@@ -31,13 +51,14 @@ const GitHubOAuth = () => {
       count++;
       setPrompt(`出现错误，第 ${count} 次重试中...`);
       await new Promise(resolve => setTimeout(resolve, count * 2000));
-      await sendCode(code, count);
+      await sendCode(code, state, count);
     }
   };
 
   useEffect(() => {
     let code = searchParams.get('code');
-    sendCode(code, 0).then();
+    let state = searchParams.get('state');
+    sendCode(code, state, 0).then();
   }, []);
 
   return (
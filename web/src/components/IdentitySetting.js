@@ -0,0 +1,197 @@
+import React, { useEffect, useState } from 'react';
+import { Button, Form, Header, Modal, Table } from 'semantic-ui-react';
+import { API, showError, showSuccess } from '../helpers';
+import WeChatQRCodeModal from './WeChatQRCodeModal';
+
+const providerNames = {
+  github: 'GitHub',
+  wechat: '微信',
+  email: '邮箱',
+};
+
+function renderTimestamp(timestamp) {
+  return new Date(timestamp * 1000).toLocaleString();
+}
+
+const IdentitySetting = () => {
+  const [identities, setIdentities] = useState([]);
+  const [status, setStatus] = useState({});
+  const [showWeChatBind, setShowWeChatBind] = useState(false);
+  const [showEmailBind, setShowEmailBind] = useState(false);
+  const [emailInputs, setEmailInputs] = useState({
+    email: '',
+    code: '',
+  });
+
+  const loadIdentities = async () => {
+    const res = await API.get('/api/user/self/identities');
+    const { success, message, data } = res.data;
+    if (success) {
+      setIdentities(data || []);
+    } else {
+      showError(message);
+    }
+  };
+
+  useEffect(() => {
+    let status = localStorage.getItem('status');
+    if (status) {
+      setStatus(JSON.parse(status));
+    }
+    loadIdentities().then();
+  }, []);
+
+  const bindGitHub = () => {
+    window.open(
+      `https://github.com/login/oauth/authorize?client_id=${status.github_client_id}&scope=user:email&state=bind`
+    );
+  };
+
+  const bindWeChat = async (code) => {
+    setShowWeChatBind(false);
+    const res = await API.get(`/api/oauth/wechat/bind?code=${code}`);
+    const { success, message } = res.data;
+    if (success) {
+      showSuccess('微信账户绑定成功！');
+      await loadIdentities();
+    } else {
+      showError(message);
+    }
+  };
+
+  const sendEmailCode = async () => {
+    if (emailInputs.email === '') return;
+    const res = await API.get(
+      `/api/verification?email=${encodeURIComponent(emailInputs.email)}`
+    );
+    const { success, message } = res.data;
+    if (success) {
+      showSuccess('验证码发送成功，请检查你的邮箱！');
+    } else {
+      showError(message);
+    }
+  };
+
+  const bindEmail = async () => {
+    if (emailInputs.email === '' || emailInputs.code === '') return;
+    const res = await API.get(
+      `/api/oauth/email/bind?email=${encodeURIComponent(
+        emailInputs.email
+      )}&code=${encodeURIComponent(emailInputs.code)}`
+    );
+    const { success, message } = res.data;
+    if (success) {
+      showSuccess('邮箱绑定成功！');
+      setShowEmailBind(false);
+      setEmailInputs({ email: '', code: '' });
+      await loadIdentities();
+    } else {
+      showError(message);
+    }
+  };
+
+  const unlink = async (provider) => {
+    const res = await API.delete(`/api/user/self/identities/${provider}`);
+    const { success, message } = res.data;
+    if (success) {
+      showSuccess(`已解除${providerNames[provider]}账户绑定`);
+      await loadIdentities();
+    } else {
+      showError(message);
+    }
+  };
+
+  const handleEmailChange = (e, { name, value }) => {
+    setEmailInputs((inputs) => ({ ...inputs, [name]: value }));
+  };
+
+  const linked = (provider) =>
+    identities.some((identity) => identity.provider === provider);
+
+  return (
+    <>
+      <Header as="h4">账户绑定</Header>
+      <Table basic>
+        <Table.Header>
+          <Table.Row>
+            <Table.HeaderCell>类型</Table.HeaderCell>
+            <Table.HeaderCell>账户</Table.HeaderCell>
+            <Table.HeaderCell>绑定时间</Table.HeaderCell>
+            <Table.HeaderCell>操作</Table.HeaderCell>
+          </Table.Row>
+        </Table.Header>
+        <Table.Body>
+          {identities.map((identity) => (
+            <Table.Row key={identity.id}>
+              <Table.Cell>{providerNames[identity.provider]}</Table.Cell>
+              <Table.Cell>{identity.subject}</Table.Cell>
+              <Table.Cell>{renderTimestamp(identity.created_time)}</Table.Cell>
+              <Table.Cell>
+                <Button
+                  size="small"
+                  negative
+                  onClick={() => unlink(identity.provider)}
+                >
+                  解除绑定
+                </Button>
+              </Table.Cell>
+            </Table.Row>
+          ))}
+        </Table.Body>
+      </Table>
+      {status.github_oauth && !linked('github') ? (
+        <Button onClick={bindGitHub}>绑定 GitHub 账户</Button>
+      ) : (
+        <></>
+      )}
+      {status.wechat_login && !linked('wechat') ? (
+        <Button onClick={() => setShowWeChatBind(true)}>绑定微信账户</Button>
+      ) : (
+        <></>
+      )}
+      {!linked('email') ? (
+        <Button onClick={() => setShowEmailBind(true)}>绑定邮箱</Button>
+      ) : (
+        <></>
+      )}
+      <WeChatQRCodeModal
+        open={showWeChatBind}
+        onClose={() => setShowWeChatBind(false)}
+        onCode={bindWeChat}
+      />
+      <Modal size="tiny" open={showEmailBind} onClose={() => setShowEmailBind(false)}>
+        <Modal.Header>绑定邮箱</Modal.Header>
+        <Modal.Content>
+          <Form>
+            <Form.Input
+              fluid
+              placeholder="输入邮箱地址"
+              name="email"
+              type="email"
+              value={emailInputs.email}
+              onChange={handleEmailChange}
+              action={
+                <Button onClick={sendEmailCode}>获取验证码</Button>
+              }
+            />
+            <Form.Input
+              fluid
+              placeholder="输入验证码"
+              name="code"
+              value={emailInputs.code}
+              onChange={handleEmailChange}
+            />
+          </Form>
+        </Modal.Content>
+        <Modal.Actions>
+          <Button onClick={() => setShowEmailBind(false)}>取消</Button>
+          <Button positive onClick={bindEmail}>
+            绑定
+          </Button>
+        </Modal.Actions>
+      </Modal>
+    </>
+  );
+};
+
+export default IdentitySetting;
@@ -9,9 +9,10 @@ import {
   Message,
   Segment,
 } from 'semantic-ui-react';
-import { Link, useNavigate } from 'react-router-dom';
+import { Link, useLocation, useNavigate } from 'react-router-dom';
 import { UserContext } from '../context/User';
-import { API, showError, showSuccess } from '../helpers';
+import { API, showError, showInfo, showSuccess } from '../helpers';
+import WeChatQRCodeModal from './WeChatQRCodeModal';
 
 const LoginForm = () => {
   const [inputs, setInputs] = useState({
@@ -22,8 +23,12 @@ const LoginForm = () => {
   const { username, password } = inputs;
   const [userState, userDispatch] = useContext(UserContext);
   let navigate = useNavigate();
+  const location = useLocation();
 
   const [status, setStatus] = useState({});
+  const [showWeChatLogin, setShowWeChatLogin] = useState(false);
+  const [requireTwoFactor, setRequireTwoFactor] = useState(false);
+  const [twoFactorCode, setTwoFactorCode] = useState('');
 
   useEffect(() => {
     let status = localStorage.getItem('status');
@@ -39,6 +44,50 @@ const LoginForm = () => {
     );
   };
 
+  useEffect(() => {
+    if (location.state && location.state.require2FA) {
+      setRequireTwoFactor(true);
+    }
+  }, []);
+
+  // handleLoginResponse handles the responses of all login methods,
+  // users with two-factor authentication enabled have to submit a code next
+  const handleLoginResponse = (res) => {
+    const { success, message, data, require_2fa_setup } = res.data;
+    if (!success) {
+      showError(message);
+      return;
+    }
+    if (data.require_2fa) {
+      setTwoFactorCode('');
+      setRequireTwoFactor(true);
+      return;
+    }
+    userDispatch({ type: 'login', payload: data });
+    localStorage.setItem('user', JSON.stringify(data));
+    if (require_2fa_setup) {
+      navigate('/setting');
+      showInfo('管理员要求你的账户启用两步验证，请先完成设置');
+      return;
+    }
+    navigate('/');
+    showSuccess('登录成功！');
+  };
+
+  const onWeChatCode = async (code) => {
+    setShowWeChatLogin(false);
+    const res = await API.get(`/api/oauth/wechat?code=${code}`);
+    handleLoginResponse(res);
+  };
+
+  const submitTwoFactorCode = async () => {
+    if (!twoFactorCode) return;
+    const res = await API.post('/api/user/login/2fa', {
+      code: twoFactorCode,
+    });
+    handleLoginResponse(res);
+  };
+
   function handleChange(e) {
     const { name, value } = e.target;
     setInputs((inputs) => ({ ...inputs, [name]: value }));
@@ -51,18 +100,42 @@ const LoginForm = () => {
         username,
         password,
       });
-      const { success, message, data } = res.data;
-      if (success) {
-        userDispatch({ type: 'login', payload: data });
-        localStorage.setItem('user', JSON.stringify(data));
-        navigate('/');
-        showSuccess('登录成功！');
-      } else {
-        showError(message);
-      }
+      handleLoginResponse(res);
     }
   }
 
+  if (requireTwoFactor) {
+    return (
+      <Grid textAlign="center" style={{ marginTop: '48px' }}>
+        <Grid.Column style={{ maxWidth: 450 }}>
+          <Header as="h2" textAlign="center">
+            <Image src="/logo.png" /> 两步验证
+          </Header>
+          <Form size="large">
+            <Segment>
+              <Form.Input
+                fluid
+                icon="lock"
+                iconPosition="left"
+                placeholder="身份验证器中的验证码或恢复码"
+                value={twoFactorCode}
+                onChange={(e) => setTwoFactorCode(e.target.value)}
+              />
+              <Button fluid size="large" onClick={submitTwoFactorCode}>
+                验证
+              </Button>
+            </Segment>
+          </Form>
+          <Message>
+            <Link to="/login" onClick={() => setRequireTwoFactor(false)}>
+              返回登录
+            </Link>
+          </Message>
+        </Grid.Column>
+      </Grid>
+    );
+  }
+
   return (
     <Grid textAlign="center" style={{ marginTop: '48px' }}>
       <Grid.Column style={{ maxWidth: 450 }}>
@@ -105,19 +178,36 @@ const LoginForm = () => {
             点击注册
           </Link>
         </Message>
+        {status.github_oauth || status.wechat_login ? (
+          <Divider horizontal>Or</Divider>
+        ) : (
+          <></>
+        )}
         {status.github_oauth ? (
-          <>
-            <Divider horizontal>Or</Divider>
-            <Button
-              circular
-              color="black"
-              icon="github"
-              onClick={onGitHubOAuthClicked}
-            />
-          </>
+          <Button
+            circular
+            color="black"
+            icon="github"
+            onClick={onGitHubOAuthClicked}
+          />
+        ) : (
+          <></>
+        )}
+        {status.wechat_login ? (
+          <Button
+            circular
+            color="green"
+            icon="wechat"
+            onClick={() => setShowWeChatLogin(true)}
+          />
         ) : (
           <></>
         )}
+        <WeChatQRCodeModal
+          open={showWeChatLogin}
+          onClose={() => setShowWeChatLogin(false)}
+          onCode={onWeChatCode}
+        />
       </Grid.Column>
     </Grid>
   );
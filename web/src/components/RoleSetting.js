@@ -0,0 +1,168 @@
+import React, { useEffect, useState } from 'react';
+import { Button, Form, Label, Table } from 'semantic-ui-react';
+import { API, showError, showSuccess } from '../helpers';
+
+const emptyRole = {
+  id: 0,
+  name: '',
+  description: '',
+  permissions: [],
+};
+
+const RoleSetting = () => {
+  const [roles, setRoles] = useState([]);
+  const [permissionOptions, setPermissionOptions] = useState([]);
+  const [inputs, setInputs] = useState(emptyRole);
+
+  const loadRoles = async () => {
+    const res = await API.get('/api/role/');
+    const { success, message, data } = res.data;
+    if (success) {
+      setRoles(data);
+    } else {
+      showError(message);
+    }
+  };
+
+  const loadPermissions = async () => {
+    const res = await API.get('/api/role/permissions');
+    const { success, message, data } = res.data;
+    if (success) {
+      setPermissionOptions(
+        data.map((permission) => ({
+          key: permission,
+          value: permission,
+          text: permission,
+        }))
+      );
+    } else {
+      showError(message);
+    }
+  };
+
+  useEffect(() => {
+    loadRoles().then();
+    loadPermissions().then();
+  }, []);
+
+  const handleInputChange = (e, { name, value }) => {
+    setInputs((inputs) => ({ ...inputs, [name]: value }));
+  };
+
+  const editRole = (role) => {
+    setInputs({
+      id: role.id,
+      name: role.name,
+      description: role.description,
+      permissions: role.permissions.split(' ').filter((p) => p !== ''),
+    });
+  };
+
+  const submit = async () => {
+    const role = { ...inputs, permissions: inputs.permissions.join(' ') };
+    let res;
+    if (role.id) {
+      res = await API.put('/api/role/', role);
+    } else {
+      res = await API.post('/api/role/', role);
+    }
+    const { success, message } = res.data;
+    if (success) {
+      showSuccess(role.id ? '角色更新成功！' : '角色创建成功！');
+      setInputs(emptyRole);
+      await loadRoles();
+    } else {
+      showError(message);
+    }
+  };
+
+  const deleteRole = async (id) => {
+    const res = await API.delete(`/api/role/${id}`);
+    const { success, message } = res.data;
+    if (success) {
+      showSuccess('角色已删除');
+      await loadRoles();
+    } else {
+      showError(message);
+    }
+  };
+
+  return (
+    <>
+      <Table basic>
+        <Table.Header>
+          <Table.Row>
+            <Table.HeaderCell>名称</Table.HeaderCell>
+            <Table.HeaderCell>描述</Table.HeaderCell>
+            <Table.HeaderCell>权限</Table.HeaderCell>
+            <Table.HeaderCell>操作</Table.HeaderCell>
+          </Table.Row>
+        </Table.Header>
+        <Table.Body>
+          {roles.map((role) => (
+            <Table.Row key={role.id}>
+              <Table.Cell>
+                {role.name}
+                {role.builtin ? <Label size="mini">内置</Label> : <></>}
+              </Table.Cell>
+              <Table.Cell>{role.description}</Table.Cell>
+              <Table.Cell>{role.permissions}</Table.Cell>
+              <Table.Cell>
+                <Button
+                  size="small"
+                  disabled={role.name === 'root'}
+                  onClick={() => editRole(role)}
+                >
+                  编辑
+                </Button>
+                <Button
+                  size="small"
+                  negative
+                  disabled={role.builtin}
+                  onClick={() => deleteRole(role.id)}
+                >
+                  删除
+                </Button>
+              </Table.Cell>
+            </Table.Row>
+          ))}
+        </Table.Body>
+      </Table>
+      <Form>
+        <Form.Group widths="equal">
+          <Form.Input
+            label="名称"
+            name="name"
+            onChange={handleInputChange}
+            value={inputs.name}
+            placeholder="例如：operator"
+          />
+          <Form.Input
+            label="描述"
+            name="description"
+            onChange={handleInputChange}
+            value={inputs.description}
+          />
+        </Form.Group>
+        <Form.Dropdown
+          label="权限"
+          name="permissions"
+          fluid
+          multiple
+          selection
+          options={permissionOptions}
+          value={inputs.permissions}
+          onChange={handleInputChange}
+        />
+        <Button onClick={submit}>{inputs.id ? '更新角色' : '创建角色'}</Button>
+        {inputs.id ? (
+          <Button onClick={() => setInputs(emptyRole)}>取消</Button>
+        ) : (
+          <></>
+        )}
+      </Form>
+    </>
+  );
+};
+
+export default RoleSetting;
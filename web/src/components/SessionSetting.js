@@ -0,0 +1,92 @@
+import React, { useEffect, useState } from 'react';
+import { Button, Header, Label, Table } from 'semantic-ui-react';
+import { API, showError, showSuccess } from '../helpers';
+
+function renderTimestamp(timestamp) {
+  return new Date(timestamp * 1000).toLocaleString();
+}
+
+const SessionSetting = () => {
+  const [sessions, setSessions] = useState([]);
+
+  const loadSessions = async () => {
+    const res = await API.get('/api/user/self/sessions');
+    const { success, message, data } = res.data;
+    if (success) {
+      setSessions(data || []);
+    } else {
+      showError(message);
+    }
+  };
+
+  useEffect(() => {
+    loadSessions().then();
+  }, []);
+
+  const deleteSession = async (id) => {
+    const res = await API.delete(`/api/user/self/sessions/${id}`);
+    const { success, message } = res.data;
+    if (success) {
+      showSuccess('会话已注销');
+      await loadSessions();
+    } else {
+      showError(message);
+    }
+  };
+
+  const deleteOtherSessions = async () => {
+    const res = await API.delete('/api/user/self/sessions');
+    const { success, message } = res.data;
+    if (success) {
+      showSuccess('其他会话已全部注销');
+      await loadSessions();
+    } else {
+      showError(message);
+    }
+  };
+
+  return (
+    <>
+      <Header as="h4">登录会话</Header>
+      <Table basic>
+        <Table.Header>
+          <Table.Row>
+            <Table.HeaderCell>设备</Table.HeaderCell>
+            <Table.HeaderCell>IP</Table.HeaderCell>
+            <Table.HeaderCell>登录时间</Table.HeaderCell>
+            <Table.HeaderCell>最后活动</Table.HeaderCell>
+            <Table.HeaderCell>操作</Table.HeaderCell>
+          </Table.Row>
+        </Table.Header>
+        <Table.Body>
+          {sessions.map((session) => (
+            <Table.Row key={session.id}>
+              <Table.Cell style={{ wordBreak: 'break-all' }}>
+                {session.user_agent}
+              </Table.Cell>
+              <Table.Cell>{session.ip}</Table.Cell>
+              <Table.Cell>{renderTimestamp(session.created_time)}</Table.Cell>
+              <Table.Cell>{renderTimestamp(session.last_seen_time)}</Table.Cell>
+              <Table.Cell>
+                {session.current ? (
+                  <Label>当前会话</Label>
+                ) : (
+                  <Button
+                    size="small"
+                    negative
+                    onClick={() => deleteSession(session.id)}
+                  >
+                    注销
+                  </Button>
+                )}
+              </Table.Cell>
+            </Table.Row>
+          ))}
+        </Table.Body>
+      </Table>
+      <Button onClick={deleteOtherSessions}>注销其他全部会话</Button>
+    </>
+  );
+};
+
+export default SessionSetting;
@@ -8,6 +8,9 @@ const SystemSetting = () => {
     RegisterEnabled: '',
     EmailVerificationEnabled: '',
     GitHubOAuthEnabled: '',
+    WeChatLoginConfirmEnabled: '',
+    WeChatAuthEnabled: '',
+    TwoFactorRequiredForAdmin: '',
     GitHubClientId: '',
     GitHubClientSecret: '',
     Notice: '',
@@ -46,6 +49,9 @@ const SystemSetting = () => {
       case 'RegisterEnabled':
       case 'EmailVerificationEnabled':
       case 'GitHubOAuthEnabled':
+      case 'WeChatLoginConfirmEnabled':
+      case 'WeChatAuthEnabled':
+      case 'TwoFactorRequiredForAdmin':
         value = inputs[key] === 'true' ? 'false' : 'true';
         break;
       default:
@@ -186,6 +192,24 @@ const SystemSetting = () => {
               name="GitHubOAuthEnabled"
               onChange={handleInputChange}
             />
+            <Form.Checkbox
+              checked={inputs.WeChatAuthEnabled === 'true'}
+              label="允许通过微信登录"
+              name="WeChatAuthEnabled"
+              onChange={handleInputChange}
+            />
+            <Form.Checkbox
+              checked={inputs.WeChatLoginConfirmEnabled === 'true'}
+              label="扫码登录需在微信中确认"
+              name="WeChatLoginConfirmEnabled"
+              onChange={handleInputChange}
+            />
+            <Form.Checkbox
+              checked={inputs.TwoFactorRequiredForAdmin === 'true'}
+              label="管理员必须启用两步验证"
+              name="TwoFactorRequiredForAdmin"
+              onChange={handleInputChange}
+            />
           </Form.Group>
           <Form.Group widths={3}>
             <Form.Input
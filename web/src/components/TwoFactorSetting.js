@@ -0,0 +1,161 @@
+import React, { useEffect, useState } from 'react';
+import { Button, Form, Header, Image, Message, Modal } from 'semantic-ui-react';
+import { API, showError, showSuccess } from '../helpers';
+
+const TwoFactorSetting = () => {
+  const [status, setStatus] = useState({
+    enabled: false,
+    required: false,
+    recovery_codes_left: 0,
+  });
+  const [setup, setSetup] = useState(null);
+  const [recoveryCodes, setRecoveryCodes] = useState([]);
+  const [action, setAction] = useState('');
+  const [code, setCode] = useState('');
+
+  const loadStatus = async () => {
+    const res = await API.get('/api/user/self/2fa');
+    const { success, message, data } = res.data;
+    if (success) {
+      setStatus(data);
+    } else {
+      showError(message);
+    }
+  };
+
+  useEffect(() => {
+    loadStatus().then();
+  }, []);
+
+  const startSetup = async () => {
+    const res = await API.post('/api/user/self/2fa/setup');
+    const { success, message, data } = res.data;
+    if (success) {
+      setSetup(data);
+      setCode('');
+    } else {
+      showError(message);
+    }
+  };
+
+  const enable = async () => {
+    const res = await API.post('/api/user/self/2fa/enable', { code });
+    const { success, message, data } = res.data;
+    if (success) {
+      showSuccess('两步验证已启用');
+      setSetup(null);
+      setRecoveryCodes(data);
+      await loadStatus();
+    } else {
+      showError(message);
+    }
+  };
+
+  const openAction = (action) => {
+    setCode('');
+    setAction(action);
+  };
+
+  const submitAction = async () => {
+    const res = await API.post(`/api/user/self/2fa/${action}`, { code });
+    const { success, message, data } = res.data;
+    if (success) {
+      if (action === 'disable') {
+        showSuccess('两步验证已关闭');
+      } else {
+        setRecoveryCodes(data);
+      }
+      setAction('');
+      await loadStatus();
+    } else {
+      showError(message);
+    }
+  };
+
+  return (
+    <>
+      <Header as="h4">两步验证</Header>
+      {status.required && !status.enabled ? (
+        <Message warning>管理员要求你的账户启用两步验证</Message>
+      ) : (
+        <></>
+      )}
+      {status.enabled ? (
+        <>
+          <p>已启用，剩余 {status.recovery_codes_left} 个恢复码</p>
+          <Button onClick={() => openAction('recovery_codes')}>
+            重新生成恢复码
+          </Button>
+          {status.required ? (
+            <></>
+          ) : (
+            <Button negative onClick={() => openAction('disable')}>
+              关闭两步验证
+            </Button>
+          )}
+        </>
+      ) : (
+        <Button onClick={startSetup}>启用两步验证</Button>
+      )}
+      <Modal onClose={() => setSetup(null)} open={setup !== null} size="mini">
+        <Modal.Header>启用两步验证</Modal.Header>
+        <Modal.Content>
+          {setup ? (
+            <>
+              <p>使用身份验证器应用扫描二维码，或手动输入密钥：</p>
+              <Image centered src={setup.qrcode} />
+              <p style={{ wordBreak: 'break-all' }}>{setup.secret}</p>
+            </>
+          ) : (
+            <></>
+          )}
+          <Form size="large">
+            <Form.Input
+              fluid
+              placeholder="输入应用中显示的 6 位验证码"
+              value={code}
+              onChange={(e) => setCode(e.target.value)}
+            />
+            <Button fluid onClick={enable}>
+              确认
+            </Button>
+          </Form>
+        </Modal.Content>
+      </Modal>
+      <Modal onClose={() => setAction('')} open={action !== ''} size="mini">
+        <Modal.Header>
+          {action === 'disable' ? '关闭两步验证' : '重新生成恢复码'}
+        </Modal.Header>
+        <Modal.Content>
+          <Form size="large">
+            <Form.Input
+              fluid
+              placeholder="验证码或恢复码"
+              value={code}
+              onChange={(e) => setCode(e.target.value)}
+            />
+            <Button fluid onClick={submitAction}>
+              确认
+            </Button>
+          </Form>
+        </Modal.Content>
+      </Modal>
+      <Modal
+        onClose={() => setRecoveryCodes([])}
+        open={recoveryCodes.length > 0}
+        size="mini"
+      >
+        <Modal.Header>恢复码</Modal.Header>
+        <Modal.Content>
+          <p>请妥善保存以下恢复码，每个恢复码只能使用一次，且仅显示一次：</p>
+          <pre>{recoveryCodes.join('\n')}</pre>
+        </Modal.Content>
+        <Modal.Actions>
+          <Button onClick={() => setRecoveryCodes([])}>我已保存</Button>
+        </Modal.Actions>
+      </Modal>
+    </>
+  );
+};
+
+export default TwoFactorSetting;
@@ -62,6 +62,16 @@ const UsersTable = () => {
     })();
   };
 
+  const revokeSessions = async (id) => {
+    const res = await API.delete(`/api/user/${id}/sessions`);
+    const { success, message } = res.data;
+    if (success) {
+      showSuccess('该用户的会话已全部注销');
+    } else {
+      showError(message);
+    }
+  };
+
   const renderStatus = (status, id) => {
     switch (status) {
       case 1:
@@ -97,7 +107,23 @@ const UsersTable = () => {
                   <Table.Cell>{user.display_name}</Table.Cell>
                   <Table.Cell>{user.email ? user.email : '无'}</Table.Cell>
                   <Table.Cell>{renderRole(user.role)}</Table.Cell>
-                  <Table.Cell>{renderStatus(user.status, user.id)}</Table.Cell>
+                  <Table.Cell>
+                    {renderStatus(user.status, user.id)}
+                    {user.locked ? (
+                      <Label
+                        as="a"
+                        color="red"
+                        title="点击解锁"
+                        onClick={() => {
+                          manageUser(user.username, 'unlock');
+                        }}
+                      >
+                        已锁定
+                      </Label>
+                    ) : (
+                      <></>
+                    )}
+                  </Table.Cell>
                   <Table.Cell>
                     <div>
                       <Button
@@ -138,6 +164,14 @@ const UsersTable = () => {
                       >
                         {user.status === 1 ? '禁用' : '启用'}
                       </Button>
+                      <Button
+                        size={'small'}
+                        onClick={() => {
+                          revokeSessions(user.id);
+                        }}
+                      >
+                        注销会话
+                      </Button>
                       <Button
                         size={'small'}
                         as={Link}
@@ -0,0 +1,72 @@
+import React, { useEffect, useState } from 'react';
+import { Image, Modal } from 'semantic-ui-react';
+import { API, showError } from '../helpers';
+
+const hints = {
+  pending: '请使用微信扫描二维码',
+  scanned: '已扫码，请在微信中确认',
+  rejected: '已在微信中拒绝，请关闭后重试',
+  expired: '二维码已过期，请关闭后重试',
+};
+
+// Shows a WeChat QR code and calls onCode with the auth code once it is scanned
+const WeChatQRCodeModal = ({ open, onClose, onCode }) => {
+  const [qrCodeURL, setQRCodeURL] = useState('');
+  const [status, setStatus] = useState('pending');
+
+  useEffect(() => {
+    if (!open) {
+      return;
+    }
+    let stopped = false;
+    let timer = null;
+    const poll = async () => {
+      const res = await API.get('/api/oauth/wechat/status');
+      const { success, message, data } = res.data;
+      if (stopped) return;
+      if (!success) {
+        showError(message);
+        return;
+      }
+      setStatus(data.status);
+      if (data.status === 'success') {
+        onCode(data.code);
+        return;
+      }
+      if (data.status === 'pending' || data.status === 'scanned') {
+        timer = setTimeout(poll, 2000);
+      }
+    };
+    const start = async () => {
+      setQRCodeURL('');
+      setStatus('pending');
+      const res = await API.get('/api/oauth/wechat/qrcode');
+      const { success, message, data } = res.data;
+      if (stopped) return;
+      if (!success) {
+        showError(message);
+        onClose();
+        return;
+      }
+      setQRCodeURL(data.qrcode_url);
+      timer = setTimeout(poll, 2000);
+    };
+    start().then();
+    return () => {
+      stopped = true;
+      clearTimeout(timer);
+    };
+  }, [open]);
+
+  return (
+    <Modal size="mini" open={open} onClose={onClose}>
+      <Modal.Header>微信扫码</Modal.Header>
+      <Modal.Content style={{ textAlign: 'center' }}>
+        {qrCodeURL ? <Image src={qrCodeURL} centered size="medium" /> : <></>}
+        <p>{hints[status] || ''}</p>
+      </Modal.Content>
+    </Modal>
+  );
+};
+
+export default WeChatQRCodeModal;
@@ -2,9 +2,15 @@ import React, { useState } from 'react';
 import { Button, Modal, Segment, Tab } from 'semantic-ui-react';
 import SystemSetting from '../../components/SystemSetting';
 import { Link } from 'react-router-dom';
-import { API, copy, isRoot, showError, showSuccess } from '../../helpers';
+import { API, isRoot, showSuccess } from '../../helpers';
 import { marked } from 'marked';
 import WeChatSetting from '../../components/WeChatSetting';
+import IdentitySetting from '../../components/IdentitySetting';
+import APITokenSetting from '../../components/APITokenSetting';
+import TwoFactorSetting from '../../components/TwoFactorSetting';
+import SessionSetting from '../../components/SessionSetting';
+import RoleSetting from '../../components/RoleSetting';
+import AuditSetting from '../../components/AuditSetting';
 
 const Setting = () => {
   const [showUpdateModal, setShowUpdateModal] = useState(false);
@@ -13,17 +19,6 @@ const Setting = () => {
     content: '',
   });
 
-  const generateToken = async () => {
-    const res = await API.get('/api/user/token');
-    const { success, message, data } = res.data;
-    if (success) {
-      await copy(data);
-      showSuccess(`令牌已重置并已复制到剪切板：${data}`);
-    } else {
-      showError(message);
-    }
-  };
-
   const openGitHubRelease = () => {
     window.location =
       'https://github.com/songquanpeng/wechat-server/releases/latest';
@@ -53,7 +48,10 @@ const Setting = () => {
           <Button as={Link} to={`/user/edit/`}>
             更新个人信息
           </Button>
-          <Button onClick={generateToken}>生成访问令牌</Button>
+          <TwoFactorSetting />
+          <IdentitySetting />
+          <APITokenSetting />
+          <SessionSetting />
         </Tab.Pane>
       ),
     },
@@ -68,6 +66,14 @@ const Setting = () => {
         </Tab.Pane>
       ),
     });
+    panes.push({
+      menuItem: '角色设置',
+      render: () => (
+        <Tab.Pane attached={false}>
+          <RoleSetting />
+        </Tab.Pane>
+      ),
+    });
     panes.push({
       menuItem: '微信设置',
       render: () => (
@@ -76,6 +82,14 @@ const Setting = () => {
         </Tab.Pane>
       ),
     });
+    panes.push({
+      menuItem: '审计日志',
+      render: () => (
+        <Tab.Pane attached={false}>
+          <AuditSetting />
+        </Tab.Pane>
+      ),
+    });
     panes.push({
       menuItem: '其他设置',
       render: () => (
@@ -13,6 +13,9 @@ const EditUser = () => {
     password: '',
   });
   const { username, display_name, password } = inputs;
+  const [canManageRoles, setCanManageRoles] = useState(false);
+  const [roleOptions, setRoleOptions] = useState([]);
+  const [roleIds, setRoleIds] = useState([]);
   const handleInputChange = (e, { name, value }) => {
     setInputs((inputs) => ({ ...inputs, [name]: value }));
   };
@@ -33,7 +36,40 @@ const EditUser = () => {
     }
     setLoading(false);
   };
+  const loadRoles = async () => {
+    let res = await API.get(`/api/user/self/permissions`);
+    const { success, data } = res.data;
+    if (!success || !(data.includes('*') || data.includes('roles.manage'))) {
+      return;
+    }
+    setCanManageRoles(true);
+    res = await API.get(`/api/role/`);
+    if (res.data.success) {
+      setRoleOptions(
+        res.data.data
+          .filter((role) => !role.builtin)
+          .map((role) => ({
+            key: role.id,
+            value: role.id,
+            text: role.description
+              ? `${role.name}（${role.description}）`
+              : role.name,
+          }))
+      );
+    }
+    res = await API.get(`/api/role/user/${userId}`);
+    if (res.data.success) {
+      setRoleIds(res.data.data.map((role) => role.id));
+    }
+  };
   useEffect(() => {
+    if (userId) {
+      loadRoles()
+        .then()
+        .catch((reason) => {
+          showError(reason);
+        });
+    }
     loadUser()
       .then()
       .catch((reason) => {
@@ -49,6 +85,13 @@ const EditUser = () => {
       res = await API.put(`/api/user/self`, inputs);
     }
     const { success, message } = res.data;
+    if (success && userId && canManageRoles) {
+      res = await API.put(`/api/role/user/${userId}`, { role_ids: roleIds });
+      if (!res.data.success) {
+        showError(res.data.message);
+        return;
+      }
+    }
     if (success) {
       showSuccess('用户信息更新成功！');
     } else {
@@ -92,6 +135,20 @@ const EditUser = () => {
               autoComplete="off"
             />
           </Form.Field>
+          {canManageRoles ? (
+            <Form.Dropdown
+              label="角色"
+              placeholder="内置角色由用户等级决定，此处可额外分配角色"
+              fluid
+              multiple
+              selection
+              options={roleOptions}
+              value={roleIds}
+              onChange={(e, { value }) => setRoleIds(value)}
+            />
+          ) : (
+            <></>
+          )}
           <Button onClick={submit}>提交</Button>
         </Form>
       </Segment>